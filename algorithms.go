@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHAlgorithms 协商使用的算法，为空的类别使用默认值。
+// 列表中以 + 开头的算法追加到默认列表之后，以 - 开头的算法从默认列表中去掉，
+// 例如 ciphers: +aes128-cbc 可以连接只支持 CBC 模式的旧设备
+type SSHAlgorithms struct {
+	KeyExchanges stringList `json:"kex,omitempty" yaml:"kex"`
+	Ciphers      stringList `json:"ciphers,omitempty" yaml:"ciphers"`
+	MACs         stringList `json:"macs,omitempty" yaml:"macs"`
+	HostKeys     stringList `json:"host_key_algorithms,omitempty" yaml:"host_key_algorithms"`
+}
+
+// defaultHostKeyAlgorithms 与 x/crypto 默认的主机密钥算法一致，ssh.ClientConfig 没有导出该列表
+var defaultHostKeyAlgorithms = []string{
+	ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01,
+	ssh.CertAlgoRSAv01, ssh.CertAlgoDSAv01, ssh.CertAlgoECDSA256v01,
+	ssh.CertAlgoECDSA384v01, ssh.CertAlgoECDSA521v01, ssh.CertAlgoED25519v01,
+	ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+	ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256,
+	ssh.KeyAlgoRSA, ssh.KeyAlgoDSA,
+	ssh.KeyAlgoED25519,
+}
+
+// parseSSHAlgorithms 读取选项 {kex, ciphers, macs, host_key_algorithms}，值可以是数组或逗号分隔的字符串，都未指定时返回 nil
+func parseSSHAlgorithms(opts map[string]interface{}) *SSHAlgorithms {
+	algos := &SSHAlgorithms{
+		KeyExchanges: optStrings(opts, "kex"),
+		Ciphers:      optStrings(opts, "ciphers"),
+		MACs:         optStrings(opts, "macs"),
+		HostKeys:     optStrings(opts, "host_key_algorithms"),
+	}
+	if algos.empty() {
+		return nil
+	}
+	return algos
+}
+
+func (a *SSHAlgorithms) empty() bool {
+	return a == nil || len(a.KeyExchanges)+len(a.Ciphers)+len(a.MACs)+len(a.HostKeys) == 0
+}
+
+// merge 按类别合并，a 中已设置的类别优先，其余取 base 的设置
+func (a *SSHAlgorithms) merge(base *SSHAlgorithms) *SSHAlgorithms {
+	if a.empty() {
+		return base
+	}
+	if base.empty() {
+		return a
+	}
+	return &SSHAlgorithms{
+		KeyExchanges: firstList(a.KeyExchanges, base.KeyExchanges),
+		Ciphers:      firstList(a.Ciphers, base.Ciphers),
+		MACs:         firstList(a.MACs, base.MACs),
+		HostKeys:     firstList(a.HostKeys, base.HostKeys),
+	}
+}
+
+// firstList 返回第一个非空列表
+func firstList(lists ...stringList) stringList {
+	for _, list := range lists {
+		if len(list) > 0 {
+			return list
+		}
+	}
+	return nil
+}
+
+// apply 把算法设置写入客户端配置
+func (a *SSHAlgorithms) apply(config *ssh.ClientConfig) {
+	if a.empty() {
+		return
+	}
+	config.SetDefaults()
+	config.KeyExchanges = expandAlgorithms(a.KeyExchanges, config.KeyExchanges)
+	config.Ciphers = expandAlgorithms(a.Ciphers, config.Ciphers)
+	config.MACs = expandAlgorithms(a.MACs, config.MACs)
+	if len(a.HostKeys) > 0 {
+		config.HostKeyAlgorithms = expandAlgorithms(a.HostKeys, defaultHostKeyAlgorithms)
+	}
+}
+
+// expandAlgorithms 按 +/- 前缀在默认列表上增删算法，与 OpenSSH 一样前缀作用于其后的所有算法；
+// 没有前缀时列表整体替换默认值
+func expandAlgorithms(list []string, defaults []string) []string {
+	if len(list) == 0 {
+		return defaults
+	}
+	result := make([]string, 0, len(defaults)+len(list))
+	prefix := ""
+	if strings.HasPrefix(list[0], "+") || strings.HasPrefix(list[0], "-") {
+		result = append(result, defaults...)
+	}
+	for _, algo := range list {
+		if strings.HasPrefix(algo, "+") || strings.HasPrefix(algo, "-") {
+			prefix, algo = algo[:1], algo[1:]
+		}
+		if prefix != "-" {
+			result = appendUnique(result, algo)
+			continue
+		}
+		for i := 0; i < len(result); i++ {
+			if result[i] == algo {
+				result = append(result[:i], result[i+1:]...)
+				i--
+			}
+		}
+	}
+	return result
+}
+
+func appendUnique(list []string, item string) []string {
+	for _, val := range list {
+		if val == item {
+			return list
+		}
+	}
+	return append(list, item)
+}
+
+// noCommonAlgorithmRe 匹配 x/crypto 协商失败的错误
+var noCommonAlgorithmRe = regexp.MustCompile(`no common algorithm for ([a-zA-Z ]+); client offered: \[([^\]]*)\], server offered: \[([^\]]*)\]`)
+
+// algorithmError 把协商失败的错误改写为指出算法类别与对应选项的错误，其它错误原样返回
+func algorithmError(err error) error {
+	m := noCommonAlgorithmRe.FindStringSubmatch(err.Error())
+	if m == nil {
+		return err
+	}
+	var category, option string
+	switch {
+	case m[1] == "key exchange":
+		category, option = "key exchange", "kex"
+	case m[1] == "host key":
+		category, option = "host key", "host_key_algorithms"
+	case strings.HasSuffix(m[1], "cipher"):
+		category, option = "cipher", "ciphers"
+	case strings.HasSuffix(m[1], "MAC"):
+		category, option = "MAC", "macs"
+	default:
+		return err
+	}
+	return errors.New("Failed to negotiate " + category + " algorithm: server offered [" + m[3] + "], client offered [" + m[2] + "]; set the " + option + " option to enable one the server supports")
+}
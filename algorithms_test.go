@@ -0,0 +1,101 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// startLegacySSHServer 只支持 diffie-hellman-group1-sha1 与 aes128-cbc 的旧设备
+func startLegacySSHServer(t *testing.T) *testSSHServer {
+	return startTestSSHServer(t, func(config *ssh.ServerConfig) {
+		config.KeyExchanges = []string{"diffie-hellman-group1-sha1"}
+		config.Ciphers = []string{"aes128-cbc"}
+	})
+}
+
+func TestSSHAlgorithmsHandshakeError(t *testing.T) {
+	server := startLegacySSHServer(t)
+
+	target := &SSHTarget{Host: server.Host, Port: server.Port, User: testSSHUser, Password: testSSHPassword}
+	_, err := target.Dial()
+	if err == nil || !strings.Contains(err.Error(), "key exchange") || !strings.Contains(err.Error(), "kex option") {
+		t.Fatalf("expected key exchange error, got %v", err)
+	}
+
+	target.Algorithms = &SSHAlgorithms{KeyExchanges: stringList{"+diffie-hellman-group1-sha1"}}
+	_, err = target.Dial()
+	if err == nil || !strings.Contains(err.Error(), "cipher") || !strings.Contains(err.Error(), "ciphers option") {
+		t.Fatalf("expected cipher error, got %v", err)
+	}
+}
+
+func TestSSHAlgorithmsPerCall(t *testing.T) {
+	server := startLegacySSHServer(t)
+
+	opts := map[string]interface{}{"kex": "+diffie-hellman-group1-sha1", "ciphers": []interface{}{"aes128-cbc"}}
+	out := execOutput(t, "remote", server.Host, server.Port, testSSHUser, testSSHPassword, "echo legacy", opts)
+	if strings.TrimSpace(out) != "legacy" {
+		t.Errorf("unexpected output %q", out)
+	}
+}
+
+func TestSSHAlgorithmsInventory(t *testing.T) {
+	server := startLegacySSHServer(t)
+	file := filepath.Join(t.TempDir(), "inventory.yml")
+	writeTestFile(t, file, `
+defaults:
+  user: yao
+  credential: test
+  algorithms:
+    kex: +diffie-hellman-group1-sha1
+credentials:
+  test:
+    password_env: CMDT_TEST_PASSWORD
+groups:
+  legacy:
+    hosts: [sw1]
+    algorithms:
+      ciphers: [aes128-ctr, aes128-cbc]
+hosts:
+  sw1:
+    host: `+server.Host+`
+    port: `+server.Port+`
+`)
+	t.Setenv("CMDT_INVENTORY", file)
+	t.Setenv("CMDT_TEST_PASSWORD", testSSHPassword)
+
+	if out := execOutput(t, "remote", "sw1", "", "", "", "echo inventory"); strings.TrimSpace(out) != "inventory" {
+		t.Errorf("unexpected output %q", out)
+	}
+	inv, err := parseInventoryFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, _ := inv.Host("sw1")
+	if !reflect.DeepEqual([]string(host.Algorithms.KeyExchanges), []string{"+diffie-hellman-group1-sha1"}) || len(host.Algorithms.Ciphers) != 2 {
+		t.Errorf("unexpected algorithms %+v", host.Algorithms)
+	}
+}
+
+func TestExpandAlgorithms(t *testing.T) {
+	defaults := []string{"a", "b", "c"}
+	cases := []struct {
+		list []string
+		want []string
+	}{
+		{nil, []string{"a", "b", "c"}},
+		{[]string{"+d", "e"}, []string{"a", "b", "c", "d", "e"}},
+		{[]string{"-b", "c"}, []string{"a"}},
+		{[]string{"+a", "-c"}, []string{"a", "b"}},
+		{[]string{"d", "a"}, []string{"d", "a"}},
+	}
+	for _, c := range cases {
+		if got := expandAlgorithms(c.list, defaults); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("expandAlgorithms(%v) = %v, want %v", c.list, got, c.want)
+		}
+	}
+}
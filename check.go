@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// SSH 诊断失败的阶段
+const (
+	CheckStageConfig    = "config"
+	CheckStageTCP       = "tcp"
+	CheckStageHandshake = "handshake"
+	CheckStageAuth      = "auth"
+)
+
+// SSHHostKey 服务端主机密钥
+type SSHHostKey struct {
+	Type           string `json:"type"`
+	Fingerprint    string `json:"fingerprint"` // SHA256:...，与 ssh-keygen -l 的输出一致
+	FingerprintMD5 string `json:"fingerprint_md5"`
+}
+
+// SSHCheckResult ssh_check 的诊断结果，前一阶段失败时后面的检查不再进行，failed_stage 指出失败的阶段
+type SSHCheckResult struct {
+	Host          string      `json:"host"`
+	Ok            bool        `json:"ok"` // TCP 连接、握手与认证都成功
+	Reachable     bool        `json:"reachable"`
+	LatencyMs     float64     `json:"latency_ms"` // 建立 TCP 连接的耗时，经代理时包含代理握手
+	Banner        string      `json:"banner,omitempty"`
+	HostKey       *SSHHostKey `json:"host_key,omitempty"`
+	AuthMethods   []string    `json:"auth_methods"`
+	Authenticated bool        `json:"authenticated"`
+	SFTP          bool        `json:"sftp"`
+	SFTPError     string      `json:"sftp_error,omitempty"`
+	FailedStage   string      `json:"failed_stage,omitempty"`
+	Error         string      `json:"error,omitempty"`
+}
+
+// errAuthProbe 探测到一种认证方式后中止认证，不提交任何凭据
+var errAuthProbe = errors.New("auth probe")
+
+// SSHCheck 逐项诊断到目标主机的 SSH 连接，不在主机上执行任何命令，timeout 作用于每一次连接
+func SSHCheck(target *SSHTarget, timeout time.Duration) *SSHCheckResult {
+	result := &SSHCheckResult{AuthMethods: []string{}}
+	fail := func(stage string, err error) *SSHCheckResult {
+		result.FailedStage, result.Error = stage, err.Error()
+		return result
+	}
+	target.Timeout = timeout
+	if target.Host == "" {
+		return fail(CheckStageConfig, errors.New("missing host"))
+	}
+	if err := target.resolveInventory(); err != nil {
+		return fail(CheckStageConfig, err)
+	}
+	result.Host = target.Address()
+
+	start := time.Now()
+	conn, err := target.dialTCP()
+	result.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+	if err != nil {
+		return fail(CheckStageTCP, err)
+	}
+	result.Reachable = true
+
+	// 第一次探测同时完成握手，记录版本行与主机密钥
+	vc := &versionConn{Conn: conn}
+	methods, remaining, err := probeAuthMethods(vc, target, timeout, []string{"keyboard-interactive", "password"}, result)
+	if result.HostKey == nil {
+		return fail(CheckStageHandshake, algorithmError(err))
+	}
+	result.Banner = vc.version
+	result.AuthMethods = append(result.AuthMethods, methods...)
+	// 需要交互的认证方式每次连接只能探测一种
+	for err == errAuthProbe && len(remaining) > 0 {
+		if conn, err = target.dialTCP(); err != nil {
+			break
+		}
+		methods, remaining, err = probeAuthMethods(conn, target, timeout, remaining, result)
+		for _, method := range methods {
+			result.AuthMethods = appendUnique(result.AuthMethods, method)
+		}
+	}
+
+	if target.Password == "" && target.PrivateKey == "" {
+		return fail(CheckStageAuth, errors.New("missing password or private key"))
+	}
+	config, err := target.clientConfig()
+	if err != nil {
+		return fail(CheckStageAuth, err)
+	}
+	if conn, err = target.dialTCP(); err != nil {
+		return fail(CheckStageAuth, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	c, chans, reqs, err := ssh.NewClientConn(conn, target.Address(), config)
+	if err != nil {
+		return fail(CheckStageAuth, algorithmError(err))
+	}
+	client := ssh.NewClient(c, chans, reqs)
+	defer client.Close()
+	result.Authenticated = true
+	result.Ok = true
+
+	sftpClient, err := sftp.NewClient(client)
+	if err != nil {
+		result.SFTPError = err.Error()
+		return result
+	}
+	sftpClient.Close()
+	result.SFTP = true
+	return result
+}
+
+// probeAuthMethods 用不带凭据的连接探测服务端提供的认证方式。x/crypto 不公开服务端返回的方式列表，
+// 只有服务端提供某种方式时才会调用对应的回调，回调记录方式后返回 errAuthProbe 中止认证。
+// 返回探测到的方式与还没有探测的交互式方式
+func probeAuthMethods(conn net.Conn, target *SSHTarget, timeout time.Duration, interactive []string, result *SSHCheckResult) ([]string, []string, error) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	config, err := getSShConfig(target.User, "", "")
+	if err != nil {
+		return nil, nil, err
+	}
+	target.Algorithms.apply(config)
+	config.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		result.HostKey = &SSHHostKey{Type: key.Type(), Fingerprint: ssh.FingerprintSHA256(key), FingerprintMD5: ssh.FingerprintLegacyMD5(key)}
+		return nil
+	}
+	methods := make([]string, 0)
+	var remaining []string
+	// 公钥方式不提供密钥时不会发送认证请求，总是可以探测
+	config.Auth = []ssh.AuthMethod{ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+		methods = append(methods, "publickey")
+		return nil, nil
+	})}
+	for _, method := range interactive {
+		method := method
+		switch method {
+		case "keyboard-interactive":
+			config.Auth = append(config.Auth, ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+				methods = append(methods, method)
+				return nil, errAuthProbe
+			}))
+		case "password":
+			config.Auth = append(config.Auth, ssh.PasswordCallback(func() (string, error) {
+				methods = append(methods, method)
+				return "", errAuthProbe
+			}))
+		}
+	}
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, target.Address(), config)
+	if err == nil {
+		// 服务端不要求认证
+		ssh.NewClient(c, chans, reqs).Close()
+		return []string{"none"}, nil, nil
+	}
+	if strings.Contains(err.Error(), errAuthProbe.Error()) {
+		// 中止处之前的方式服务端没有提供或没有发起交互，只需继续探测之后的方式
+		err = errAuthProbe
+		for i, method := range interactive {
+			if method == methods[len(methods)-1] {
+				remaining = interactive[i+1:]
+			}
+		}
+	}
+	return methods, remaining, err
+}
+
+// versionConn 记录服务端发送的版本行，版本行之前可能有其它文本
+type versionConn struct {
+	net.Conn
+	buf     []byte
+	version string
+}
+
+func (c *versionConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if c.version == "" && len(c.buf) < 8192 {
+		c.buf = append(c.buf, p[:n]...)
+		for _, line := range strings.SplitAfter(string(c.buf), "\n") {
+			if strings.HasPrefix(line, "SSH-") && strings.HasSuffix(line, "\n") {
+				c.version = strings.TrimRight(line, "\r\n")
+				break
+			}
+		}
+	}
+	return n, err
+}
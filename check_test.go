@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSSHCheck(t *testing.T) {
+	server := startTestSSHServer(t, func(config *ssh.ServerConfig) {
+		config.ServerVersion = "SSH-2.0-TestServer_1.0"
+		config.KeyboardInteractiveCallback = func(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			client("", "", []string{"Password: "}, []bool{false})
+			return nil, ssh.ErrNoAuth
+		}
+	})
+
+	result := &SSHCheckResult{}
+	json.Unmarshal([]byte(execOutput(t, "ssh_check", server.Host, server.Port, testSSHUser, testSSHPassword)), result)
+	if !result.Ok || !result.Reachable || !result.Authenticated || !result.SFTP || result.FailedStage != "" {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if result.Banner != "SSH-2.0-TestServer_1.0" || result.HostKey == nil || result.HostKey.Type != "ssh-ed25519" || !strings.HasPrefix(result.HostKey.Fingerprint, "SHA256:") {
+		t.Errorf("unexpected banner or host key %+v %+v", result, result.HostKey)
+	}
+	if !reflect.DeepEqual(result.AuthMethods, []string{"keyboard-interactive", "password"}) {
+		t.Errorf("unexpected auth methods %v", result.AuthMethods)
+	}
+
+	// 认证失败与 SFTP 不可用分别记录
+	result = SSHCheck(&SSHTarget{Host: server.Host, Port: server.Port, User: testSSHUser, Password: "wrong"}, 5*time.Second)
+	if result.Ok || result.Authenticated || result.FailedStage != CheckStageAuth || result.HostKey == nil || len(result.AuthMethods) != 2 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	server.noSFTP = true
+	result = SSHCheck(&SSHTarget{Host: server.Host, Port: server.Port, User: testSSHUser, Password: testSSHPassword}, 5*time.Second)
+	if !result.Ok || result.SFTP || result.SFTPError == "" {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
+
+func TestSSHCheckUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+
+	result := SSHCheck(&SSHTarget{Host: host, Port: port, User: testSSHUser, Password: testSSHPassword}, 5*time.Second)
+	if result.Ok || result.Reachable || result.FailedStage != CheckStageTCP || result.Error == "" {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	// 端口可以连接但不是 SSH 服务
+	listener, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("HTTP/1.0 400 Bad Request\r\n\r\n"))
+			conn.Close()
+		}
+	}()
+	host, port, _ = net.SplitHostPort(listener.Addr().String())
+	result = SSHCheck(&SSHTarget{Host: host, Port: port, User: testSSHUser, Password: testSSHPassword}, 5*time.Second)
+	if result.Ok || !result.Reachable || result.FailedStage != CheckStageHandshake {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os/exec"
 	"reflect"
@@ -53,6 +54,7 @@ func NewCommandExecutor(logger hclog.Logger) *CommandExecutor {
 // CommandArgs 命令参数结构体
 type CommandArgs struct {
 	cmdArgs    []string
+	rawArgs    []interface{}
 	isRemote   bool
 	isOk       bool
 	isDone 		bool
@@ -60,6 +62,7 @@ type CommandArgs struct {
 	outputStr  string
 	statusCode int
 	statusText string
+	pty        *PTYOptions
 }
 
 // parseArgs 解析命令参数
@@ -84,6 +87,7 @@ func (e *CommandExecutor) parseArgs(args ...interface{}) *CommandArgs {
 
 	return &CommandArgs{
 		cmdArgs:    cmdArgs,
+		rawArgs:    args,
 		isRemote:   false,
 		isOk:       true,
 		errStr:     "",
@@ -99,6 +103,8 @@ func (e *CommandExecutor) processCommandType(name string, args *CommandArgs) {
 	case "cmd","powershell":
 		args.cmdArgs = append([]string{name, "/c"}, args.cmdArgs...)
 	case "bash", "sh", "csh", "ksh", "zsh", "fish":
+		// 最后一个参数是选项表时读取 pty 选项 {pty, rows, cols, term, capture, ansi, input, timeout}
+		args.pty = parsePTYOptions(args.trailingOptions(1))
 		args.cmdArgs = append([]string{name, "-c"}, args.cmdArgs...)
 	case "scp":
 		if len(args.cmdArgs) < 2 {
@@ -109,6 +115,7 @@ func (e *CommandExecutor) processCommandType(name string, args *CommandArgs) {
 		}
 	case "remote":
 		args.isRemote = true
+		options := args.trailingOptions(5)
 		if len(args.cmdArgs) < 5 {
 			args.isOk = false
 			args.errStr = "参数不足，需要5个参数"
@@ -120,7 +127,9 @@ func (e *CommandExecutor) processCommandType(name string, args *CommandArgs) {
 			// args.cmdArgs[2]: 用户名
 			// args.cmdArgs[3]: 密码
 			// args.cmdArgs[4:]: 命令行参数
-			result, eStr, err := SSHRun(args.cmdArgs[0], args.cmdArgs[1], args.cmdArgs[2], args.cmdArgs[3], "", commane_line)
+			// 最后一个参数可以是选项 {proxy, kex, ciphers, macs, host_key_algorithms}
+			target := &SSHTarget{Host: args.cmdArgs[0], Port: args.cmdArgs[1], User: args.cmdArgs[2], Password: args.cmdArgs[3], PrivateKey: "", Proxy: optString(options, "proxy", ""), Algorithms: parseSSHAlgorithms(options)}
+			result, eStr, _, err := target.Run(commane_line, 10*time.Second)
 			if err != nil {
 				args.errStr = err.Error()
 			} else {
@@ -130,6 +139,7 @@ func (e *CommandExecutor) processCommandType(name string, args *CommandArgs) {
 		}
 	case "remote_key":
 		args.isRemote = true
+		options := args.trailingOptions(5)
 		if len(args.cmdArgs) < 5 {
 			args.isOk = false
 			args.errStr = "参数不足，需要5个参数"
@@ -141,7 +151,9 @@ func (e *CommandExecutor) processCommandType(name string, args *CommandArgs) {
 			// args.cmdArgs[2]: 用户名
 			// args.cmdArgs[3]: 密钥文件路径
 			// args.cmdArgs[4:]: 命令行参数
-			result, eStr, err := SSHRun(args.cmdArgs[0], args.cmdArgs[1], args.cmdArgs[2], "", args.cmdArgs[3], commane_line)
+			// 最后一个参数可以是选项 {proxy, kex, ciphers, macs, host_key_algorithms}
+			target := &SSHTarget{Host: args.cmdArgs[0], Port: args.cmdArgs[1], User: args.cmdArgs[2], Password: "", PrivateKey: args.cmdArgs[3], Proxy: optString(options, "proxy", ""), Algorithms: parseSSHAlgorithms(options)}
+			result, eStr, _, err := target.Run(commane_line, 10*time.Second)
 			if err != nil {
 				args.errStr = err.Error()
 			} else {
@@ -161,12 +173,10 @@ func (e *CommandExecutor) processCommandType(name string, args *CommandArgs) {
 			// args.cmdArgs[3]: 密码
 			// args.cmdArgs[4]: 本地文件路径
 			// args.cmdArgs[5]: 远程文件路径
-			err := SSHCopyFile(args.cmdArgs[0], args.cmdArgs[1], args.cmdArgs[2], args.cmdArgs[3], "", args.cmdArgs[4], args.cmdArgs[5])
-			if err != nil {
-				args.errStr = err.Error()
-			} else {
-				args.statusCode = 0
-			}
+			// args.cmdArgs[6]: 可选的传输选项 {resume, verify, id, async}
+			e.runTransfer(args, args.optionsAt(6), func(opts *TransferOptions) (*TransferResult, error) {
+				return SSHCopyFile(args.cmdArgs[0], args.cmdArgs[1], args.cmdArgs[2], args.cmdArgs[3], "", args.cmdArgs[4], args.cmdArgs[5], opts)
+			})
 		}
 	case "remote_copy_file_key":
 		args.isRemote = true
@@ -180,12 +190,10 @@ func (e *CommandExecutor) processCommandType(name string, args *CommandArgs) {
 			// args.cmdArgs[3]: 密钥文件路径
 			// args.cmdArgs[4]: 本地文件路径
 			// args.cmdArgs[5]: 远程文件路径
-			err := SSHCopyFile(args.cmdArgs[0], args.cmdArgs[1], args.cmdArgs[2], "", args.cmdArgs[3], args.cmdArgs[4], args.cmdArgs[5])
-			if err != nil {
-				args.errStr = err.Error()
-			} else {
-				args.statusCode = 0
-			}
+			// args.cmdArgs[6]: 可选的传输选项 {resume, verify, id, async}
+			e.runTransfer(args, args.optionsAt(6), func(opts *TransferOptions) (*TransferResult, error) {
+				return SSHCopyFile(args.cmdArgs[0], args.cmdArgs[1], args.cmdArgs[2], "", args.cmdArgs[3], args.cmdArgs[4], args.cmdArgs[5], opts)
+			})
 		}
 	case "remote_copy_folder":
 		args.isRemote = true
@@ -199,12 +207,10 @@ func (e *CommandExecutor) processCommandType(name string, args *CommandArgs) {
 			// args.cmdArgs[3]: 密码
 			// args.cmdArgs[4]: 本地文件夹路径
 			// args.cmdArgs[5]: 远程文件夹路径
-			err := SSHCopyFolder(args.cmdArgs[0], args.cmdArgs[1], args.cmdArgs[2], args.cmdArgs[3], "", args.cmdArgs[4], args.cmdArgs[5])
-			if err != nil {
-				args.errStr = err.Error()
-			} else {
-				args.statusCode = 0
-			}
+			// args.cmdArgs[6]: 可选的传输选项 {resume, verify, id, async}
+			e.runTransfer(args, args.optionsAt(6), func(opts *TransferOptions) (*TransferResult, error) {
+				return SSHCopyFolder(args.cmdArgs[0], args.cmdArgs[1], args.cmdArgs[2], args.cmdArgs[3], "", args.cmdArgs[4], args.cmdArgs[5], opts)
+			})
 		}
 	case "scan":
 		args.isDone = true;
@@ -237,12 +243,93 @@ func (e *CommandExecutor) processCommandType(name string, args *CommandArgs) {
 			// args.cmdArgs[3]: 密钥文件路径
 			// args.cmdArgs[4]: 本地文件夹路径
 			// args.cmdArgs[5]: 远程文件夹路径
-			err := SSHCopyFolder(args.cmdArgs[0], args.cmdArgs[1], args.cmdArgs[2], "", args.cmdArgs[3], args.cmdArgs[4], args.cmdArgs[5])
-			if err != nil {
-				args.errStr = err.Error()
-			} else {
-				args.statusCode = 0
-			}
+			// args.cmdArgs[6]: 可选的传输选项 {resume, verify, id, async}
+			e.runTransfer(args, args.optionsAt(6), func(opts *TransferOptions) (*TransferResult, error) {
+				return SSHCopyFolder(args.cmdArgs[0], args.cmdArgs[1], args.cmdArgs[2], "", args.cmdArgs[3], args.cmdArgs[4], args.cmdArgs[5], opts)
+			})
+		}
+	case "remote_download_file":
+		args.isRemote = true
+		if len(args.cmdArgs) < 6 {
+			args.isOk = false
+			args.errStr = "参数不足，需要6个参数"
+		} else {
+			// args.cmdArgs[0]: 主机地址
+			// args.cmdArgs[1]: 端口号
+			// args.cmdArgs[2]: 用户名
+			// args.cmdArgs[3]: 密码
+			// args.cmdArgs[4]: 远程文件路径
+			// args.cmdArgs[5]: 本地文件路径
+			// args.cmdArgs[6]: 可选的传输选项 {resume, verify, id, async}
+			e.runTransfer(args, args.optionsAt(6), func(opts *TransferOptions) (*TransferResult, error) {
+				return SSHDownloadFile(args.cmdArgs[0], args.cmdArgs[1], args.cmdArgs[2], args.cmdArgs[3], "", args.cmdArgs[4], args.cmdArgs[5], opts)
+			})
+		}
+	case "remote_download_file_key":
+		args.isRemote = true
+		if len(args.cmdArgs) < 6 {
+			args.isOk = false
+			args.errStr = "参数不足，需要6个参数"
+		} else {
+			// args.cmdArgs[0]: 主机地址
+			// args.cmdArgs[1]: 端口号
+			// args.cmdArgs[2]: 用户名
+			// args.cmdArgs[3]: 密钥文件路径
+			// args.cmdArgs[4]: 远程文件路径
+			// args.cmdArgs[5]: 本地文件路径
+			// args.cmdArgs[6]: 可选的传输选项 {resume, verify, id, async}
+			e.runTransfer(args, args.optionsAt(6), func(opts *TransferOptions) (*TransferResult, error) {
+				return SSHDownloadFile(args.cmdArgs[0], args.cmdArgs[1], args.cmdArgs[2], "", args.cmdArgs[3], args.cmdArgs[4], args.cmdArgs[5], opts)
+			})
+		}
+	case "remote_download_folder":
+		args.isRemote = true
+		if len(args.cmdArgs) < 6 {
+			args.isOk = false
+			args.errStr = "参数不足，需要6个参数"
+		} else {
+			// args.cmdArgs[0]: 主机地址
+			// args.cmdArgs[1]: 端口号
+			// args.cmdArgs[2]: 用户名
+			// args.cmdArgs[3]: 密码
+			// args.cmdArgs[4]: 远程文件夹路径
+			// args.cmdArgs[5]: 本地文件夹路径
+			// args.cmdArgs[6]: 可选的传输选项 {resume, verify, id, async}
+			e.runTransfer(args, args.optionsAt(6), func(opts *TransferOptions) (*TransferResult, error) {
+				return SSHDownloadFolder(args.cmdArgs[0], args.cmdArgs[1], args.cmdArgs[2], args.cmdArgs[3], "", args.cmdArgs[4], args.cmdArgs[5], opts)
+			})
+		}
+	case "remote_download_folder_key":
+		args.isRemote = true
+		if len(args.cmdArgs) < 6 {
+			args.isOk = false
+			args.errStr = "参数不足，需要6个参数"
+		} else {
+			// args.cmdArgs[0]: 主机地址
+			// args.cmdArgs[1]: 端口号
+			// args.cmdArgs[2]: 用户名
+			// args.cmdArgs[3]: 密钥文件路径
+			// args.cmdArgs[4]: 远程文件夹路径
+			// args.cmdArgs[5]: 本地文件夹路径
+			// args.cmdArgs[6]: 可选的传输选项 {resume, verify, id, async}
+			e.runTransfer(args, args.optionsAt(6), func(opts *TransferOptions) (*TransferResult, error) {
+				return SSHDownloadFolder(args.cmdArgs[0], args.cmdArgs[1], args.cmdArgs[2], "", args.cmdArgs[3], args.cmdArgs[4], args.cmdArgs[5], opts)
+			})
+		}
+	case "remote_transfer":
+		args.isRemote = true
+		if len(args.cmdArgs) < 2 {
+			args.isOk = false
+			args.errStr = "参数不足，需要2个参数"
+		} else {
+			// args.rawArgs[0]: 源主机 {host, port, user, password, private_key, path}
+			// args.rawArgs[1]: 目标主机 {host, port, user, password, private_key, path}
+			// args.rawArgs[2]: 可选的传输选项 {resume, verify, include, exclude, id, async}
+			source := args.optionsAt(0)
+			target := args.optionsAt(1)
+			e.runTransfer(args, args.optionsAt(2), func(opts *TransferOptions) (*TransferResult, error) {
+				return SSHRemoteTransfer(parseSSHTarget(source), parseSSHTarget(target), optString(source, "path", ""), optString(target, "path", ""), opts)
+			})
 		}
 	case "remote_write_file":
 		args.isRemote = true
@@ -257,12 +344,10 @@ func (e *CommandExecutor) processCommandType(name string, args *CommandArgs) {
 			// args.cmdArgs[3]: 密码
 			// args.cmdArgs[4]: 远程文件路径
 			// args.cmdArgs[5]: 文件内容
-			err := SSHWriteFile(args.cmdArgs[0], args.cmdArgs[1], args.cmdArgs[2], args.cmdArgs[3], "", args.cmdArgs[4], args.cmdArgs[5])
-			if err != nil {
-				args.errStr = err.Error()
-			} else {
-				args.statusCode = 0
-			}
+			// args.cmdArgs[6]: 可选的写入选项 {atomic, append, backup, mode, owner, group, encoding}
+			e.runWriteFile(args, func(opts *WriteOptions) (*WriteResult, error) {
+				return SSHWriteFile(args.cmdArgs[0], args.cmdArgs[1], args.cmdArgs[2], args.cmdArgs[3], "", args.cmdArgs[4], args.cmdArgs[5], opts)
+			})
 		}
 	case "remote_write_file_key":
 		args.isRemote = true
@@ -277,23 +362,637 @@ func (e *CommandExecutor) processCommandType(name string, args *CommandArgs) {
 			// args.cmdArgs[3]: 密钥文件路径
 			// args.cmdArgs[4]: 远程文件路径
 			// args.cmdArgs[5]: 文件内容
-			err := SSHWriteFile(args.cmdArgs[0], args.cmdArgs[1], args.cmdArgs[2], "", args.cmdArgs[3], args.cmdArgs[4], args.cmdArgs[5])
+			// args.cmdArgs[6]: 可选的写入选项 {atomic, append, backup, mode, owner, group, encoding}
+			e.runWriteFile(args, func(opts *WriteOptions) (*WriteResult, error) {
+				return SSHWriteFile(args.cmdArgs[0], args.cmdArgs[1], args.cmdArgs[2], "", args.cmdArgs[3], args.cmdArgs[4], args.cmdArgs[5], opts)
+			})
+		}
+	case "remote_multi", "remote_multi_key":
+		args.isRemote = true
+		if len(args.cmdArgs) < 5 {
+			args.isOk = false
+			args.errStr = "参数不足，需要5个参数"
+		} else {
+			// args.rawArgs[0]: 主机列表，数组或逗号分隔的字符串，主机可写成 host:port
+			// args.cmdArgs[1]: 默认端口号
+			// args.cmdArgs[2]: 用户名
+			// args.cmdArgs[3]: 密码（remote_multi_key 为密钥文件路径）
+			// args.cmdArgs[4]: 命令行
+			// args.cmdArgs[5]: 可选的执行选项 {concurrency, timeout, stop_on_failure, proxy, kex, ciphers, macs, host_key_algorithms}
+			password, privateKey := args.cmdArgs[3], ""
+			if name == "remote_multi_key" {
+				password, privateKey = "", args.cmdArgs[3]
+			}
+			hosts, err := expandHostList(parseHostList(args.rawArgs[0]))
+			if err != nil {
+				args.errStr = err.Error()
+				break
+			}
+			targets := multiTargets(hosts, args.cmdArgs[1], args.cmdArgs[2], password, privateKey)
+			for _, target := range targets {
+				target.Proxy = optString(args.optionsAt(5), "proxy", "")
+				target.Algorithms = parseSSHAlgorithms(args.optionsAt(5))
+			}
+			if len(targets) == 0 {
+				args.errStr = "主机列表为空"
+				break
+			}
+			result := SSHRunMulti(targets, args.cmdArgs[4], parseMultiOptions(args.optionsAt(5)))
+			e.Logger.Log(hclog.Trace, "remote multi finished: "+result.Summary.String())
+			output, err := formatJSON(result)
 			if err != nil {
 				args.errStr = err.Error()
 			} else {
-				args.statusCode = 0
+				args.outputStr = output
 			}
 		}
+	case "remote_facts", "remote_facts_key":
+		args.isRemote = true
+		if len(args.cmdArgs) < 4 {
+			args.isOk = false
+			args.errStr = "参数不足，需要4个参数"
+		} else {
+			// args.rawArgs[0]: 主机列表，数组或逗号分隔的字符串，主机可写成 host:port，可以是清单中的分组名
+			// args.cmdArgs[1]: 默认端口号
+			// args.cmdArgs[2]: 用户名
+			// args.cmdArgs[3]: 密码（remote_facts_key 为密钥文件路径）
+			// args.cmdArgs[4]: 可选的执行选项 {concurrency, timeout, stop_on_failure, proxy, kex, ciphers, macs, host_key_algorithms}
+			password, privateKey := args.cmdArgs[3], ""
+			if name == "remote_facts_key" {
+				password, privateKey = "", args.cmdArgs[3]
+			}
+			hosts, err := expandHostList(parseHostList(args.rawArgs[0]))
+			if err != nil {
+				args.errStr = err.Error()
+				break
+			}
+			targets := multiTargets(hosts, args.cmdArgs[1], args.cmdArgs[2], password, privateKey)
+			for _, target := range targets {
+				target.Proxy = optString(args.optionsAt(4), "proxy", "")
+				target.Algorithms = parseSSHAlgorithms(args.optionsAt(4))
+			}
+			if len(targets) == 0 {
+				args.errStr = "主机列表为空"
+				break
+			}
+			result := SSHGatherFactsMulti(targets, parseMultiOptions(args.optionsAt(4)))
+			e.Logger.Log(hclog.Trace, "remote facts finished: "+result.Summary.String())
+			e.setJSONOutput(args, result, nil)
+		}
+	case "sysinfo":
+		args.isDone = true
+		// args.rawArgs[0]: 可选的选项 {interval, all_mounts, loopback}
+		result, err := GetSystemInfo(parseSysinfoOptions(args.optionsAt(0)))
+		e.setJSONOutput(args, result, err)
+	case "inventory_list", "inventory_host", "inventory_group", "inventory_query":
+		args.isDone = true
+		// inventory_host/inventory_group: args.cmdArgs[0] 主机名或分组名
+		// inventory_query: args.rawArgs[0] 筛选条件 {group, pattern, vars}
+		data, err := e.queryInventory(name, args)
+		if err != nil {
+			args.errStr = err.Error()
+			break
+		}
+		output, err := formatJSON(data)
+		if err != nil {
+			args.errStr = err.Error()
+		} else {
+			args.outputStr = output
+		}
+	case "remote_script", "remote_script_key":
+		args.isRemote = true
+		if len(args.cmdArgs) < 5 {
+			args.isOk = false
+			args.errStr = "参数不足，需要5个参数"
+		} else {
+			// args.cmdArgs[0]: 主机地址
+			// args.cmdArgs[1]: 端口号
+			// args.cmdArgs[2]: 用户名
+			// args.cmdArgs[3]: 密码（remote_script_key 为密钥文件路径）
+			// args.cmdArgs[4]: 脚本内容或本地脚本文件路径
+			// args.cmdArgs[5]: 可选的执行选项 {interpreter, args, vars, timeout, tmp_dir, keep, proxy}
+			options := args.optionsAt(5)
+			target := &SSHTarget{Host: args.cmdArgs[0], Port: args.cmdArgs[1], User: args.cmdArgs[2], Password: args.cmdArgs[3], Proxy: optString(options, "proxy", ""), Algorithms: parseSSHAlgorithms(options)}
+			if name == "remote_script_key" {
+				target.Password, target.PrivateKey = "", args.cmdArgs[3]
+			}
+			result, err := SSHRunScript(target, args.cmdArgs[4], parseScriptOptions(options))
+			e.setJSONOutput(args, result, err)
+		}
+	case "ssh_check", "ssh_check_key":
+		args.isRemote = true
+		if len(args.cmdArgs) < 4 {
+			args.isOk = false
+			args.errStr = "参数不足，需要4个参数"
+		} else {
+			// args.cmdArgs[0]: 主机地址
+			// args.cmdArgs[1]: 端口号
+			// args.cmdArgs[2]: 用户名
+			// args.cmdArgs[3]: 密码（ssh_check_key 为密钥文件路径）
+			// args.cmdArgs[4]: 可选的选项 {timeout, proxy, kex, ciphers, macs, host_key_algorithms}
+			options := args.optionsAt(4)
+			target := &SSHTarget{Host: args.cmdArgs[0], Port: args.cmdArgs[1], User: args.cmdArgs[2], Password: args.cmdArgs[3], Proxy: optString(options, "proxy", ""), Algorithms: parseSSHAlgorithms(options)}
+			if name == "ssh_check_key" {
+				target.Password, target.PrivateKey = "", args.cmdArgs[3]
+			}
+			timeout := time.Duration(optInt(options, "timeout", 10)) * time.Second
+			if timeout <= 0 {
+				timeout = 10 * time.Second
+			}
+			e.setJSONOutput(args, SSHCheck(target, timeout), nil)
+		}
+	case "ssh_keygen":
+		args.isDone = true
+		// args.rawArgs[0]: 可选的选项 {type, bits, comment, credential, user, dir, overwrite}
+		opts, err := parseKeygenOptions(args.optionsAt(0))
+		if err != nil {
+			args.errStr = err.Error()
+			break
+		}
+		result, err := GenerateKeyPair(opts)
+		e.setJSONOutput(args, result, err)
+	case "ssh_copy_id":
+		args.isRemote = true
+		if len(args.cmdArgs) < 5 {
+			args.isOk = false
+			args.errStr = "参数不足，需要5个参数"
+		} else {
+			// args.cmdArgs[0]: 主机地址
+			// args.cmdArgs[1]: 端口号
+			// args.cmdArgs[2]: 用户名
+			// args.cmdArgs[3]: 密码
+			// args.cmdArgs[4]: 公钥，可以是清单中的凭据名称、公钥或私钥文件路径、公钥行或私钥内容
+			// args.cmdArgs[5]: 可选的选项 {path, private_key, verify, timeout, proxy, kex, ciphers, macs, host_key_algorithms}
+			options := args.optionsAt(5)
+			target := &SSHTarget{Host: args.cmdArgs[0], Port: args.cmdArgs[1], User: args.cmdArgs[2], Password: args.cmdArgs[3], Proxy: optString(options, "proxy", ""), Algorithms: parseSSHAlgorithms(options)}
+			result, err := SSHDeployKey(target, args.cmdArgs[4], parseDeployKeyOptions(options))
+			e.setJSONOutput(args, result, err)
+		}
+	case "runbook_run":
+		args.isRemote = true
+		if len(args.cmdArgs) < 1 {
+			args.isOk = false
+			args.errStr = "参数不足，需要1个参数"
+		} else {
+			// args.cmdArgs[0]: 运行手册文件路径或 YAML 内容
+			// args.rawArgs[1]: 可选的执行选项 {vars, dry_run, start_at, concurrency}
+			book, err := loadRunbook(args.cmdArgs[0])
+			if err != nil {
+				args.errStr = err.Error()
+				break
+			}
+			report, err := RunRunbook(book, parseRunbookOptions(args.optionsAt(1)))
+			if err != nil {
+				args.errStr = err.Error()
+				break
+			}
+			output, err := formatJSON(report)
+			if err != nil {
+				args.errStr = err.Error()
+			} else {
+				args.outputStr = output
+			}
+		}
+	case "tunnel_open":
+		args.isDone = true
+		if len(args.cmdArgs) < 1 {
+			args.isOk = false
+			args.errStr = "参数不足，需要1个参数"
+		} else {
+			// args.rawArgs[0]: {type, host, port, user, password, private_key, bind, target, id}
+			// type: local(-L)、remote(-R) 或 dynamic(-D，本地 SOCKS5 代理，不需要 target)
+			opts := args.optionsAt(0)
+			tunnel, err := OpenTunnel(optString(opts, "id", ""), optString(opts, "type", TunnelLocal), parseSSHTarget(opts), optString(opts, "bind", ""), optString(opts, "target", ""))
+			e.setJSONOutput(args, tunnel, err)
+		}
+	case "tunnel_list":
+		args.isDone = true
+		// args.cmdArgs[0]: 可选的隧道ID，为空时返回全部隧道
+		if len(args.cmdArgs) > 0 && args.cmdArgs[0] != "" {
+			tunnel, ok := tunnels.get(args.cmdArgs[0])
+			if !ok {
+				args.errStr = "隧道不存在: " + args.cmdArgs[0]
+				break
+			}
+			e.setJSONOutput(args, tunnel.snapshot(), nil)
+		} else {
+			e.setJSONOutput(args, tunnels.list(), nil)
+		}
+	case "tunnel_close":
+		args.isDone = true
+		if len(args.cmdArgs) < 1 {
+			args.isOk = false
+			args.errStr = "参数不足，需要1个参数"
+		} else {
+			// args.cmdArgs[0]: 隧道ID
+			tunnel, ok := tunnels.get(args.cmdArgs[0])
+			if !ok {
+				args.errStr = "隧道不存在: " + args.cmdArgs[0]
+				break
+			}
+			tunnel.Close()
+			e.setJSONOutput(args, tunnel.snapshot(), nil)
+		}
+	case "expect":
+		args.isDone = true
+		if len(args.cmdArgs) < 2 {
+			args.isOk = false
+			args.errStr = "参数不足，需要2个参数"
+		} else {
+			// args.cmdArgs[0]: 本地命令，在伪终端中通过 sh -c 执行
+			// args.rawArgs[1]: 步骤数组 [{expect, send, timeout}]，expect 为正则表达式
+			// args.rawArgs[2]: 可选的选项 {timeout, wait, rows, cols, term, ansi}
+			opts, err := parseExpectOptions(args.rawArgs[1], args.optionsAt(2))
+			if err != nil {
+				args.errStr = err.Error()
+				break
+			}
+			result, err := Expect(args.cmdArgs[0], opts)
+			e.setJSONOutput(args, result, err)
+		}
+	case "remote_expect", "remote_expect_key":
+		args.isRemote = true
+		if len(args.cmdArgs) < 6 {
+			args.isOk = false
+			args.errStr = "参数不足，需要6个参数"
+		} else {
+			// args.cmdArgs[0]: 主机地址
+			// args.cmdArgs[1]: 端口号
+			// args.cmdArgs[2]: 用户名
+			// args.cmdArgs[3]: 密码（remote_expect_key 为密钥文件路径）
+			// args.cmdArgs[4]: 远程命令，为空时启动登录 shell
+			// args.rawArgs[5]: 步骤数组 [{expect, send, timeout}]
+			// args.rawArgs[6]: 可选的选项 {timeout, wait, rows, cols, term, ansi, proxy}
+			options := args.optionsAt(6)
+			opts, err := parseExpectOptions(args.rawArgs[5], options)
+			if err != nil {
+				args.errStr = err.Error()
+				break
+			}
+			target := &SSHTarget{Host: args.cmdArgs[0], Port: args.cmdArgs[1], User: args.cmdArgs[2], Password: args.cmdArgs[3], Proxy: optString(options, "proxy", ""), Algorithms: parseSSHAlgorithms(options)}
+			if name == "remote_expect_key" {
+				target.Password, target.PrivateKey = "", args.cmdArgs[3]
+			}
+			result, err := SSHExpect(target, args.cmdArgs[4], opts)
+			e.setJSONOutput(args, result, err)
+		}
+	case "device_exec", "device_exec_key":
+		args.isRemote = true
+		if len(args.cmdArgs) < 5 {
+			args.isOk = false
+			args.errStr = "参数不足，需要5个参数"
+		} else {
+			// args.cmdArgs[0]: 主机地址
+			// args.cmdArgs[1]: 端口号
+			// args.cmdArgs[2]: 用户名
+			// args.cmdArgs[3]: 密码（device_exec_key 为密钥文件路径）
+			// args.rawArgs[4]: 命令数组，或每行一条命令的字符串
+			// args.rawArgs[5]: 可选的选项 {prompt, pager, pager_response, enable, enable_command, enable_password,
+			//                  password_prompt, paging_command, newline, timeout, term, rows, cols, proxy}
+			options := args.optionsAt(5)
+			opts, err := parseDeviceOptions(options)
+			if err != nil {
+				args.errStr = err.Error()
+				break
+			}
+			commands := deviceCommands(args.rawArgs[4])
+			if len(commands) == 0 {
+				args.errStr = "没有要执行的命令"
+				break
+			}
+			target := &SSHTarget{Host: args.cmdArgs[0], Port: args.cmdArgs[1], User: args.cmdArgs[2], Password: args.cmdArgs[3], Proxy: optString(options, "proxy", ""), Algorithms: parseSSHAlgorithms(options)}
+			if name == "device_exec_key" {
+				target.Password, target.PrivateKey = "", args.cmdArgs[3]
+			}
+			result, err := DeviceExec(target, commands, opts)
+			e.setJSONOutput(args, result, err)
+		}
+	case "telnet_exec":
+		args.isRemote = true
+		options := args.trailingOptions(5)
+		if len(args.cmdArgs) < 5 {
+			args.isOk = false
+			args.errStr = "参数不足，需要5个参数"
+		} else {
+			// args.cmdArgs[0]: 主机地址
+			// args.cmdArgs[1]: 端口号，为空时使用 23
+			// args.cmdArgs[2]: 用户名，为空时不输入用户名
+			// args.cmdArgs[3]: 密码，为空时不输入密码
+			// args.cmdArgs[4:]: 命令及参数，与 remote 相同；单个参数可以是命令数组或每行一条命令的字符串
+			// 最后一个参数可以是选项 {prompt, login_prompt, password_prompt, login_failure, pager, pager_response,
+			//                  enable, enable_password, paging_command, newline, timeout, term, rows, cols, proxy}
+			opts, err := parseTelnetOptions(options)
+			if err != nil {
+				args.errStr = err.Error()
+				break
+			}
+			commands := deviceCommands(strings.Join(args.cmdArgs[4:], " "))
+			if len(args.rawArgs) == 5 {
+				commands = deviceCommands(args.rawArgs[4])
+			}
+			if len(commands) == 0 {
+				args.errStr = "没有要执行的命令"
+				break
+			}
+			result, err := TelnetExec(args.cmdArgs[0], args.cmdArgs[1], args.cmdArgs[2], args.cmdArgs[3], commands, opts)
+			e.setJSONOutput(args, result, err)
+		}
+	case "shell_open":
+		args.isDone = true
+		if len(args.cmdArgs) < 1 {
+			args.isOk = false
+			args.errStr = "参数不足，需要1个参数"
+		} else {
+			// args.rawArgs[0]: {type, host, port, user, password, private_key, proxy, id, shell, cwd, env, idle_timeout}
+			// type: remote（默认）或 local，local 为本地常驻 shell 进程，cwd/env 只对本地会话有效
+			// shell: 启动的 shell 命令，例如 bash，远程为空时使用登录 shell，本地默认 sh
+			// idle_timeout: 空闲超过该秒数后自动关闭，默认 1800，0 表示不关闭
+			opts := args.optionsAt(0)
+			var session *ShellSession
+			var err error
+			if optString(opts, "type", "remote") == "local" {
+				session, err = OpenLocalShell(parseShellOptions(opts))
+			} else {
+				session, err = OpenRemoteShell(parseSSHTarget(opts), parseShellOptions(opts))
+			}
+			e.setJSONOutput(args, session, err)
+		}
+	case "shell_send":
+		args.isDone = true
+		if len(args.cmdArgs) < 2 {
+			args.isOk = false
+			args.errStr = "参数不足，需要2个参数"
+		} else {
+			// args.cmdArgs[0]: 会话ID
+			// args.rawArgs[1]: 一条命令或命令数组
+			// args.rawArgs[2]: 可选的选项 {timeout}，每条命令的超时秒数，默认 30
+			session, ok := shells.get(args.cmdArgs[0])
+			if !ok {
+				args.errStr = "会话不存在: " + args.cmdArgs[0]
+				break
+			}
+			commands := shellCommands(args.rawArgs[1])
+			if len(commands) == 0 {
+				args.errStr = "没有要执行的命令"
+				break
+			}
+			timeout := time.Duration(optInt(args.optionsAt(2), "timeout", 30)) * time.Second
+			results, err := session.Send(commands, timeout)
+			e.setJSONOutput(args, &ShellSendResult{ID: session.ID, Results: results}, err)
+		}
+	case "shell_list":
+		args.isDone = true
+		// args.cmdArgs[0]: 可选的会话ID，为空时返回全部会话
+		if len(args.cmdArgs) > 0 && args.cmdArgs[0] != "" {
+			session, ok := shells.get(args.cmdArgs[0])
+			if !ok {
+				args.errStr = "会话不存在: " + args.cmdArgs[0]
+				break
+			}
+			e.setJSONOutput(args, session.snapshot(), nil)
+		} else {
+			e.setJSONOutput(args, shells.list(), nil)
+		}
+	case "shell_close":
+		args.isDone = true
+		if len(args.cmdArgs) < 1 {
+			args.isOk = false
+			args.errStr = "参数不足，需要1个参数"
+		} else {
+			// args.cmdArgs[0]: 会话ID
+			session, ok := shells.get(args.cmdArgs[0])
+			if !ok {
+				args.errStr = "会话不存在: " + args.cmdArgs[0]
+				break
+			}
+			session.Close()
+			e.setJSONOutput(args, session.snapshot(), nil)
+		}
+	case "transfer_progress":
+		args.isDone = true
+		// args.cmdArgs[0]: 可选的传输任务ID，为空时返回全部任务
+		var progress interface{}
+		if len(args.cmdArgs) > 0 && args.cmdArgs[0] != "" {
+			item, ok := transferProgress.get(args.cmdArgs[0])
+			if !ok {
+				args.errStr = "传输任务不存在: " + args.cmdArgs[0]
+				break
+			}
+			progress = item
+		} else {
+			progress = transferProgress.list()
+		}
+		output, err := formatJSON(progress)
+		if err != nil {
+			args.errStr = err.Error()
+		} else {
+			args.outputStr = output
+		}
 	default:
 		args.cmdArgs = append(args.cmdArgs, name)
 	}
 }
 
+// inventoryGroupMethods 第一个参数为主机地址的 SSH 方法，主机地址可以是清单中的分组名
+var inventoryGroupMethods = map[string]bool{
+	"remote":                     true,
+	"remote_key":                 true,
+	"remote_copy_file":           true,
+	"remote_copy_file_key":       true,
+	"remote_copy_folder":         true,
+	"remote_copy_folder_key":     true,
+	"remote_download_file":       true,
+	"remote_download_file_key":   true,
+	"remote_download_folder":     true,
+	"remote_download_folder_key": true,
+	"remote_write_file":          true,
+	"remote_write_file_key":      true,
+	"remote_script":              true,
+	"remote_script_key":          true,
+	"remote_expect":              true,
+	"remote_expect_key":          true,
+	"device_exec":                true,
+	"device_exec_key":            true,
+	"ssh_check":                  true,
+	"ssh_check_key":              true,
+	"ssh_copy_id":                true,
+}
+
+// runInventoryGroup 主机地址是清单分组时，在分组内的每台主机上执行同一方法，返回多主机执行结果
+func (e *CommandExecutor) runInventoryGroup(name string, args *CommandArgs) bool {
+	if !inventoryGroupMethods[name] || len(args.cmdArgs) == 0 {
+		return false
+	}
+	inv, err := loadInventory()
+	if err != nil {
+		return false
+	}
+	if _, ok := inv.Groups[args.cmdArgs[0]]; !ok {
+		return false
+	}
+	args.isRemote = true
+	members, err := inv.GroupMembers(args.cmdArgs[0])
+	if err != nil {
+		args.errStr = err.Error()
+		return true
+	}
+	if len(members) == 0 {
+		args.errStr = "分组内没有主机: " + args.cmdArgs[0]
+		return true
+	}
+	targets := make([]*SSHTarget, 0, len(members))
+	for _, member := range members {
+		targets = append(targets, &SSHTarget{Host: member})
+	}
+	result := runOnHosts(targets, &MultiOptions{Concurrency: 10}, func(target *SSHTarget) *HostResult {
+		hostArgs := &CommandArgs{
+			cmdArgs: append([]string{target.Host}, args.cmdArgs[1:]...),
+			rawArgs: append([]interface{}{target.Host}, args.rawArgs[1:]...),
+			isOk:    true,
+		}
+		e.processCommandType(name, hostArgs)
+		return &HostResult{Ok: hostArgs.isOk && hostArgs.errStr == "", Stdout: hostArgs.outputStr, Error: hostArgs.errStr}
+	})
+	e.Logger.Log(hclog.Trace, "inventory group "+args.cmdArgs[0]+" finished: "+result.Summary.String())
+	output, err := formatJSON(result)
+	if err != nil {
+		args.errStr = err.Error()
+	} else {
+		args.outputStr = output
+	}
+	return true
+}
+
+// queryInventory 列出或查询主机清单，结果不包含凭据内容
+func (e *CommandExecutor) queryInventory(name string, args *CommandArgs) (interface{}, error) {
+	inv, err := loadInventory()
+	if err != nil {
+		return nil, err
+	}
+	switch name {
+	case "inventory_host":
+		if len(args.cmdArgs) < 1 {
+			return nil, errors.New("参数不足，需要1个参数")
+		}
+		host, ok := inv.Host(args.cmdArgs[0])
+		if !ok {
+			return nil, errors.New("Inventory host not found: " + args.cmdArgs[0])
+		}
+		return host, nil
+	case "inventory_group":
+		if len(args.cmdArgs) < 1 {
+			return nil, errors.New("参数不足，需要1个参数")
+		}
+		return inv.Query(args.cmdArgs[0], "", nil)
+	case "inventory_query":
+		filter := args.optionsAt(0)
+		vars, _ := filter["vars"].(map[string]interface{})
+		return inv.Query(optString(filter, "group", ""), optString(filter, "pattern", ""), vars)
+	}
+	hosts, err := inv.Query("", "", nil)
+	if err != nil {
+		return nil, err
+	}
+	groups := map[string][]string{}
+	for group := range inv.Groups {
+		groups[group], _ = inv.GroupMembers(group)
+	}
+	return map[string]interface{}{"file": inv.file, "hosts": hosts, "groups": groups}, nil
+}
+
+// runTransfer 登记传输进度并执行传输，async 为 true 时后台执行并立即返回任务ID
+func (e *CommandExecutor) runTransfer(args *CommandArgs, options map[string]interface{}, transfer func(opts *TransferOptions) (*TransferResult, error)) {
+	opts := parseTransferOptions(options)
+	opts.Progress = transferProgress.start(optString(options, "id", ""))
+
+	run := func() (*TransferResult, error) {
+		result, err := transfer(opts)
+		opts.Progress.finish(err)
+		if result != nil {
+			result.ID = opts.Progress.ID
+		}
+		return result, err
+	}
+
+	if optBool(options, "async", false) {
+		go func() {
+			if _, err := run(); err != nil {
+				e.Logger.Error("transfer failed", "id", opts.Progress.ID, "error", err)
+			}
+		}()
+		e.setTransferResult(args, &TransferResult{ID: opts.Progress.ID, Files: []*TransferFile{}}, nil)
+		return
+	}
+	result, err := run()
+	e.setTransferResult(args, result, err)
+}
+
+// runWriteFile 解析写入选项并执行远程写文件
+func (e *CommandExecutor) runWriteFile(args *CommandArgs, write func(opts *WriteOptions) (*WriteResult, error)) {
+	opts, err := parseWriteOptions(args.optionsAt(6))
+	if err != nil {
+		args.errStr = err.Error()
+		return
+	}
+	result, err := write(opts)
+	if err != nil {
+		args.errStr = err.Error()
+		return
+	}
+	output, err := formatJSON(result)
+	if err != nil {
+		args.errStr = err.Error()
+		return
+	}
+	args.outputStr = output
+}
+
+// setJSONOutput 将结果序列化为 JSON 作为命令输出
+func (e *CommandExecutor) setJSONOutput(args *CommandArgs, result interface{}, err error) {
+	if err != nil {
+		args.errStr = err.Error()
+		return
+	}
+	output, err := formatJSON(result)
+	if err != nil {
+		args.errStr = err.Error()
+		return
+	}
+	args.outputStr = output
+}
+
+// setTransferResult 将文件传输结果写入命令输出
+func (e *CommandExecutor) setTransferResult(args *CommandArgs, result *TransferResult, err error) {
+	if err != nil {
+		args.errStr = err.Error()
+		return
+	}
+	output, err := formatJSON(result)
+	if err != nil {
+		args.errStr = err.Error()
+		return
+	}
+	args.outputStr = output
+	args.statusCode = 0
+}
+
 // executeLocalCommand 执行本地命令
 func (e *CommandExecutor) executeLocalCommand(args *CommandArgs) {
 	commane_line := strings.Join(args.cmdArgs, " ")
 	e.Logger.Log(hclog.Trace, "excute command:"+commane_line)
 
+	// 在伪终端中执行，标准输出与错误输出合并
+	if args.pty != nil {
+		output, err := runPTY(args.cmdArgs, args.pty)
+		if err != nil {
+			args.errStr = err.Error()
+			args.isOk = false
+			return
+		}
+		args.outputStr = output
+		return
+	}
+
 	timeout := 10 * time.Second
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
@@ -342,8 +1041,10 @@ func (e *CommandExecutor) ExecuteCommand(name string, args ...interface{}) (*grp
 	// 解析参数
 	cmdArgs := e.parseArgs(args...)
 
-	// 处理命令类型
-	e.processCommandType(name, cmdArgs)
+	// 处理命令类型，主机地址是清单分组时在分组内所有主机上执行
+	if !e.runInventoryGroup(name, cmdArgs) {
+		e.processCommandType(name, cmdArgs)
+	}
 
 	// 执行本地命令
 	if !cmdArgs.isDone && cmdArgs.isOk && !cmdArgs.isRemote {
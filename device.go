@@ -0,0 +1,310 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// defaultDevicePrompt 识别常见网络设备的提示符，例如 sw1>、sw1#、R1(config)#、[~HUAWEI]
+var defaultDevicePrompt = `[\w.\-@:/~\[\]()]+ ?[>#$%\]] ?$`
+
+// defaultDevicePager 常见的分页提示
+var defaultDevicePager = `(?i)-+ ?more ?-+|<-+ ?more ?-+>|press any key to continue`
+
+// DeviceOptions 网络设备命令行选项
+type DeviceOptions struct {
+	Prompt         *regexp.Regexp // 为空时根据登录后的提示符自动生成
+	Pager          *regexp.Regexp // 为空时不处理分页
+	PagerResponse  string
+	Enable         bool
+	EnableCommand  string
+	EnablePassword string
+	PasswordPrompt *regexp.Regexp
+	PagingCommand  string // 登录后执行的关闭分页命令，例如 terminal length 0
+	Newline        string
+	Timeout        time.Duration // 每条命令的超时
+	Term           string
+	Rows           int
+	Cols           int
+}
+
+// DeviceCommand 一条设备命令的输出，已去掉命令回显、提示符与分页提示
+type DeviceCommand struct {
+	Command  string  `json:"command"`
+	Output   string  `json:"output"`
+	Duration float64 `json:"duration_ms"`
+}
+
+// DeviceResult device_exec 的执行结果
+type DeviceResult struct {
+	Host       string           `json:"host"`
+	Prompt     string           `json:"prompt"`
+	Privileged bool             `json:"privileged"`
+	Commands   []*DeviceCommand `json:"commands"`
+}
+
+// parseDeviceOptions 读取设备选项，timeout 单位为秒，默认 30；pager 为 none 时不处理分页
+func parseDeviceOptions(opts map[string]interface{}) (*DeviceOptions, error) {
+	dopts := &DeviceOptions{
+		PagerResponse:  optString(opts, "pager_response", " "),
+		Enable:         optBool(opts, "enable", false),
+		EnableCommand:  optString(opts, "enable_command", "enable"),
+		EnablePassword: optString(opts, "enable_password", ""),
+		PagingCommand:  optString(opts, "paging_command", ""),
+		Newline:        optString(opts, "newline", "\n"),
+		Timeout:        time.Duration(optInt(opts, "timeout", 30)) * time.Second,
+		Term:           optString(opts, "term", "vt100"),
+		Rows:           optInt(opts, "rows", 24),
+		Cols:           optInt(opts, "cols", 511),
+	}
+	if dopts.Timeout <= 0 {
+		dopts.Timeout = 30 * time.Second
+	}
+	var err error
+	if prompt := optString(opts, "prompt", ""); prompt != "" {
+		if dopts.Prompt, err = regexp.Compile(prompt); err != nil {
+			return nil, errors.New("Invalid prompt: " + err.Error())
+		}
+	}
+	if pager := optString(opts, "pager", defaultDevicePager); pager != "none" && pager != "" {
+		if dopts.Pager, err = regexp.Compile(pager); err != nil {
+			return nil, errors.New("Invalid pager: " + err.Error())
+		}
+	}
+	if dopts.PasswordPrompt, err = regexp.Compile(optString(opts, "password_prompt", `(?i)password\s*: ?$`)); err != nil {
+		return nil, errors.New("Invalid password prompt: " + err.Error())
+	}
+	return dopts, nil
+}
+
+// deviceCommands 读取命令列表，字符串中每行是一条命令
+func deviceCommands(val interface{}) []string {
+	commands := make([]string, 0)
+	for _, item := range shellCommands(val) {
+		for _, line := range strings.Split(item, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				commands = append(commands, line)
+			}
+		}
+	}
+	return commands
+}
+
+// deviceCLI 设备交互式命令行
+type deviceCLI struct {
+	proc   *expectProc
+	opts   *DeviceOptions
+	prompt *regexp.Regexp
+	last   string // 最近一次匹配到的提示符
+	ready  bool   // 已经匹配到登录后的提示符
+}
+
+// DeviceExec 在设备的交互式 shell 中依次执行命令，处理分页并按提示符切分每条命令的输出
+func DeviceExec(target *SSHTarget, commands []string, opts *DeviceOptions) (*DeviceResult, error) {
+	conn, err := target.Dial()
+	if err != nil {
+		return nil, err
+	}
+	session, err := conn.NewSession()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	closer := func() {
+		session.Close()
+		conn.Close()
+	}
+	proc, err := startSessionPTY(session, "", opts.Term, opts.Rows, opts.Cols)
+	if err != nil {
+		closer()
+		return nil, err
+	}
+	proc.close = closer
+	defer proc.close()
+
+	cli := &deviceCLI{proc: proc, opts: opts, prompt: opts.Prompt}
+	result, err := cli.run(commands)
+	if result != nil {
+		result.Host = target.Address()
+	}
+	return result, err
+}
+
+func (c *deviceCLI) run(commands []string) (*DeviceResult, error) {
+	if !c.ready {
+		if err := c.login(); err != nil {
+			return nil, err
+		}
+	}
+	if c.opts.Enable && !c.privileged() {
+		if err := c.enable(); err != nil {
+			return nil, err
+		}
+	}
+	if c.opts.PagingCommand != "" {
+		if _, err := c.exec(c.opts.PagingCommand); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &DeviceResult{Commands: make([]*DeviceCommand, 0, len(commands))}
+	for _, command := range commands {
+		start := time.Now()
+		output, err := c.exec(command)
+		if err != nil {
+			return nil, errors.New("command '" + command + "': " + err.Error())
+		}
+		result.Commands = append(result.Commands, &DeviceCommand{Command: command, Output: output, Duration: float64(time.Since(start).Microseconds()) / 1000})
+	}
+	result.Prompt = c.last
+	result.Privileged = c.privileged()
+	return result, nil
+}
+
+// login 等待登录后的第一个提示符
+func (c *deviceCLI) login() error {
+	prompt := c.prompt
+	if prompt == nil {
+		prompt = regexp.MustCompile(defaultDevicePrompt)
+	}
+	_, match, err := c.proc.out.expect(prompt, time.After(c.opts.Timeout))
+	if err != nil {
+		return errors.New("Failed to detect prompt: " + err.Error())
+	}
+	c.learnPrompt(match)
+	return nil
+}
+
+// learnPrompt 记录登录后的提示符，未指定提示符时由它生成后续使用的提示符表达式
+func (c *deviceCLI) learnPrompt(match string) {
+	c.last = strings.TrimSpace(lastLine(match))
+	if c.prompt == nil {
+		c.prompt = learnDevicePrompt(c.last)
+	}
+	c.ready = true
+}
+
+// learnDevicePrompt 以主机名部分生成提示符表达式，兼容 > 与 # 的切换以及 (config) 等模式
+func learnDevicePrompt(prompt string) *regexp.Regexp {
+	base := strings.TrimRight(prompt, " >#$%")
+	if i := strings.Index(base, "("); i > 0 {
+		base = base[:i]
+	}
+	return regexp.MustCompile(`(?:^|[\r\n])` + regexp.QuoteMeta(base) + `(?:\([\w\-/. ]*\))? ?[>#$%] ?$`)
+}
+
+// privileged 当前提示符是否处于特权模式
+func (c *deviceCLI) privileged() bool {
+	return strings.HasSuffix(c.last, "#")
+}
+
+// enable 进入特权模式，需要时输入 enable 密码
+func (c *deviceCLI) enable() error {
+	if err := c.send(c.opts.EnableCommand); err != nil {
+		return err
+	}
+	deadline := time.After(c.opts.Timeout)
+	_, match, index, err := c.proc.out.expectAny([]*regexp.Regexp{c.opts.PasswordPrompt, c.prompt}, deadline)
+	if err != nil {
+		return errors.New("Failed to enter privileged mode: " + err.Error())
+	}
+	if index == 0 {
+		if err := c.send(c.opts.EnablePassword); err != nil {
+			return err
+		}
+		if _, match, err = c.proc.out.expect(c.prompt, deadline); err != nil {
+			return errors.New("Failed to enter privileged mode: " + err.Error())
+		}
+	}
+	c.last = strings.TrimSpace(lastLine(match))
+	if !c.privileged() {
+		return errors.New("Failed to enter privileged mode: prompt is " + c.last)
+	}
+	return nil
+}
+
+func (c *deviceCLI) send(line string) error {
+	_, err := io.WriteString(c.proc.stdin, line+c.opts.Newline)
+	return err
+}
+
+// exec 执行一条命令，遇到分页提示时发送翻页按键，直到再次出现提示符
+func (c *deviceCLI) exec(command string) (string, error) {
+	if err := c.send(command); err != nil {
+		return "", err
+	}
+	patterns := []*regexp.Regexp{c.prompt}
+	if c.opts.Pager != nil {
+		patterns = append(patterns, c.opts.Pager)
+	}
+	deadline := time.After(c.opts.Timeout)
+	var output strings.Builder
+	for {
+		consumed, match, index, err := c.proc.out.expectAny(patterns, deadline)
+		if err != nil {
+			return "", err
+		}
+		output.WriteString(consumed[:len(consumed)-len(match)])
+		if index == 0 {
+			c.last = strings.TrimSpace(lastLine(match))
+			return cleanDeviceOutput(output.String(), command), nil
+		}
+		if _, err := io.WriteString(c.proc.stdin, c.opts.PagerResponse); err != nil {
+			return "", err
+		}
+	}
+}
+
+// cleanDeviceOutput 去掉控制序列与命令回显，并按 \r、退格覆盖的效果还原每一行
+func cleanDeviceOutput(output, command string) string {
+	lines := strings.Split(stripANSI(output), "\n")
+	for i, line := range lines {
+		lines[i] = overwriteLine(strings.TrimRight(line, "\r"))
+	}
+	if len(lines) > 0 && strings.HasSuffix(strings.TrimSpace(lines[0]), strings.TrimSpace(command)) {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// overwriteLine 按终端的效果处理回车与退格，并去掉行尾空白
+func overwriteLine(line string) string {
+	if !strings.ContainsAny(line, "\r\b") {
+		return strings.TrimRight(line, " \t")
+	}
+	buf := make([]rune, 0, len(line))
+	pos := 0
+	for _, r := range line {
+		switch r {
+		case '\r':
+			pos = 0
+		case '\b':
+			if pos > 0 {
+				pos--
+			}
+		default:
+			if pos < len(buf) {
+				buf[pos] = r
+			} else {
+				buf = append(buf, r)
+			}
+			pos++
+		}
+	}
+	return strings.TrimRight(string(buf), " \t")
+}
+
+// lastLine 返回文本的最后一行
+func lastLine(text string) string {
+	text = strings.TrimRight(text, "\r\n")
+	if i := strings.LastIndexAny(text, "\r\n"); i >= 0 {
+		return text[i+1:]
+	}
+	return text
+}
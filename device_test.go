@@ -0,0 +1,90 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testDeviceCLI 模拟交换机命令行：enable 密码为 en，show run 输出三行后分页
+const testDeviceCLI = `mode='>'
+paging=1
+echo 'Welcome to sw1'
+while :; do
+  printf 'sw1%s' "$mode"
+  IFS= read -r line || exit 0
+  case "$line" in
+  enable)
+    printf 'Password: '
+    stty -echo; IFS= read -r pw; stty echo; echo
+    if [ "$pw" = en ]; then mode='#'; else echo '% Access denied'; fi ;;
+  'terminal length 0') paging=0 ;;
+  'show version') echo 'Version 1.0'; echo 'Uptime 5 days' ;;
+  'show run')
+    if [ "$mode" != '#' ]; then echo '% Invalid input'; continue; fi
+    i=1
+    while [ $i -le 6 ]; do
+      echo "interface Gi0/$i"
+      if [ $paging = 1 ] && [ $i = 3 ]; then
+        printf ' --More-- '
+        stty -icanon -echo min 1; dd bs=1 count=1 >/dev/null 2>&1; stty icanon echo
+        printf '\r          \r'
+      fi
+      i=$((i+1))
+    done ;;
+  exit) exit 0 ;;
+  '') ;;
+  *) echo "% Unknown command: $line" ;;
+  esac
+done
+`
+
+func startTestDevice(t *testing.T) *testSSHServer {
+	t.Helper()
+	server := startTestSSHServer(t)
+	script := filepath.Join(t.TempDir(), "cli.sh")
+	writeTestFile(t, script, testDeviceCLI)
+	server.setShell(script)
+	return server
+}
+
+func TestDeviceExec(t *testing.T) {
+	server := startTestDevice(t)
+	result := &DeviceResult{}
+	output := execOutput(t, "device_exec", server.Host, server.Port, testSSHUser, testSSHPassword, "show version\nshow run", map[string]interface{}{"enable": true, "enable_password": "en"})
+	json.Unmarshal([]byte(output), result)
+	if !result.Privileged || result.Prompt != "sw1#" || len(result.Commands) != 2 {
+		t.Fatalf("unexpected result %s", output)
+	}
+	if result.Commands[0].Output != "Version 1.0\nUptime 5 days" {
+		t.Errorf("unexpected output %q", result.Commands[0].Output)
+	}
+	want := "interface Gi0/1\ninterface Gi0/2\ninterface Gi0/3\ninterface Gi0/4\ninterface Gi0/5\ninterface Gi0/6"
+	if result.Commands[1].Output != want {
+		t.Errorf("unexpected output %q", result.Commands[1].Output)
+	}
+
+	// 不进入特权模式，先关闭分页
+	result = &DeviceResult{}
+	output = execOutput(t, "device_exec", server.Host, server.Port, testSSHUser, testSSHPassword, []interface{}{"show run"}, map[string]interface{}{"paging_command": "terminal length 0"})
+	json.Unmarshal([]byte(output), result)
+	if result.Privileged || result.Prompt != "sw1>" || result.Commands[0].Output != "% Invalid input" {
+		t.Errorf("unexpected result %s", output)
+	}
+
+	target := &SSHTarget{Host: server.Host, Port: server.Port, User: testSSHUser, Password: testSSHPassword}
+	opts, _ := parseDeviceOptions(map[string]interface{}{"enable": true, "enable_password": "wrong", "timeout": 5})
+	if _, err := DeviceExec(target, []string{"show run"}, opts); err == nil || !strings.Contains(err.Error(), "privileged") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestCleanDeviceOutput(t *testing.T) {
+	got := cleanDeviceOutput("show ip\r\n10.0.0.1  \r\n\r          \r10.0.0.2\x1b[K\r\nab\bc\r\n", "show ip")
+	if got != "10.0.0.1\n10.0.0.2\nac" {
+		t.Errorf("unexpected output %q", got)
+	}
+}
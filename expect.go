@@ -0,0 +1,332 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ExpectStep 交互脚本的一步：等待输出匹配 Expect 后发送 Send
+type ExpectStep struct {
+	Expect  string        `json:"expect"`
+	Send    string        `json:"send"`
+	Timeout time.Duration `json:"-"`
+
+	re *regexp.Regexp
+}
+
+// ExpectOptions 交互执行选项
+type ExpectOptions struct {
+	Steps    []*ExpectStep
+	Timeout  time.Duration // 未单独指定时每一步的超时，以及最后等待命令退出的超时
+	Wait     bool          // 所有步骤完成后等待命令退出
+	Rows     int
+	Cols     int
+	Term     string
+	Preserve bool // 记录中保留 ANSI 控制序列
+}
+
+// ExpectStepResult 每一步的执行情况
+type ExpectStepResult struct {
+	Index    int     `json:"index"`
+	Expect   string  `json:"expect"`
+	Matched  bool    `json:"matched"`
+	Match    string  `json:"match,omitempty"`
+	Sent     bool    `json:"sent"`
+	Duration float64 `json:"duration_ms"`
+}
+
+// ExpectResult 交互执行结果，失败的步骤通过 failed_step 指出，从 0 开始
+type ExpectResult struct {
+	Ok         bool                `json:"ok"`
+	FailedStep *int                `json:"failed_step,omitempty"`
+	Error      string              `json:"error,omitempty"`
+	ExitCode   int                 `json:"exit_code"`
+	Steps      []*ExpectStepResult `json:"steps"`
+	Transcript string              `json:"transcript"`
+}
+
+// parseExpectOptions 读取步骤列表与选项，timeout 单位为秒，默认 10，步骤中的 timeout 覆盖默认值
+func parseExpectOptions(steps interface{}, opts map[string]interface{}) (*ExpectOptions, error) {
+	eopts := &ExpectOptions{
+		Timeout:  time.Duration(optInt(opts, "timeout", 10)) * time.Second,
+		Wait:     optBool(opts, "wait", true),
+		Rows:     optInt(opts, "rows", 24),
+		Cols:     optInt(opts, "cols", 80),
+		Term:     optString(opts, "term", "xterm"),
+		Preserve: optString(opts, "ansi", "strip") == "preserve",
+	}
+	if eopts.Timeout <= 0 {
+		eopts.Timeout = 10 * time.Second
+	}
+	items, ok := steps.([]interface{})
+	if !ok {
+		items = parseJSONList(steps)
+	}
+	for i, item := range items {
+		data, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("Invalid expect step " + strconv.Itoa(i))
+		}
+		step := &ExpectStep{
+			Expect:  optString(data, "expect", ""),
+			Send:    optString(data, "send", ""),
+			Timeout: time.Duration(optInt(data, "timeout", 0)) * time.Second,
+		}
+		if step.Timeout <= 0 {
+			step.Timeout = eopts.Timeout
+		}
+		if step.Expect != "" {
+			re, err := regexp.Compile(step.Expect)
+			if err != nil {
+				return nil, errors.New("Invalid expect pattern " + strconv.Itoa(i) + ": " + err.Error())
+			}
+			step.re = re
+		}
+		eopts.Steps = append(eopts.Steps, step)
+	}
+	if len(eopts.Steps) == 0 {
+		return nil, errors.New("missing expect steps")
+	}
+	return eopts, nil
+}
+
+// parseJSONList 兼容以 JSON 字符串传入的数组
+func parseJSONList(val interface{}) []interface{} {
+	var list []interface{}
+	if text, ok := val.(string); ok {
+		json.Unmarshal([]byte(text), &list)
+	}
+	return list
+}
+
+// expectProc 在终端中运行的进程
+type expectProc struct {
+	stdin io.Writer
+	out   *shellStream
+	wait  func() (int, error) // 等待进程退出并返回退出码
+	close func()
+}
+
+// Expect 在本地伪终端中执行命令并按步骤交互
+func Expect(command string, opts *ExpectOptions) (*ExpectResult, error) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = append(cmd.Environ(), "TERM="+opts.Term)
+	master, err := startPTY(cmd, opts.Rows, opts.Cols)
+	if err != nil {
+		return nil, err
+	}
+	// 进程只回收一次，run 中等待退出码与结束时的清理共用同一次 Wait。
+	// 回收前先结束整个进程组：首进程被回收之前进程组ID不会被复用，已退出的首进程保留退出码；
+	// run 只在输出结束后才等待退出码，此时首进程已经退出
+	var reap sync.Once
+	var waitErr error
+	wait := func() {
+		reap.Do(func() {
+			killProcessGroup(cmd)
+			waitErr = cmd.Wait()
+		})
+	}
+	proc := &expectProc{
+		stdin: master,
+		out:   newShellStream(master),
+		wait: func() (int, error) {
+			wait()
+			if exitErr, ok := waitErr.(*exec.ExitError); ok {
+				return exitErr.ExitCode(), nil
+			}
+			return 0, waitErr
+		},
+		close: func() {
+			// 步骤超时时进程可能仍在运行，结束后回收，避免留下僵尸进程
+			wait()
+			master.Close()
+		},
+	}
+	return proc.run(opts), nil
+}
+
+// SSHExpect 在远程主机上申请终端执行命令并按步骤交互，command 为空时启动登录 shell
+func SSHExpect(target *SSHTarget, command string, opts *ExpectOptions) (*ExpectResult, error) {
+	conn, err := target.Dial()
+	if err != nil {
+		return nil, err
+	}
+	session, err := conn.NewSession()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	closer := func() {
+		session.Close()
+		conn.Close()
+	}
+	proc, err := startSessionPTY(session, command, opts.Term, opts.Rows, opts.Cols)
+	if err != nil {
+		closer()
+		return nil, err
+	}
+	proc.close = closer
+	return proc.run(opts), nil
+}
+
+// startSessionPTY 在 SSH 会话上申请终端并启动命令，终端中标准错误与标准输出合并
+func startSessionPTY(session *ssh.Session, command, term string, rows, cols int) (*expectProc, error) {
+	modes := ssh.TerminalModes{ssh.ECHO: 1, ssh.TTY_OP_ISPEED: 38400, ssh.TTY_OP_OSPEED: 38400}
+	if err := session.RequestPty(term, rows, cols, modes); err != nil {
+		return nil, errors.New("Failed to request pty: " + err.Error())
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if command == "" {
+		err = session.Shell()
+	} else {
+		err = session.Start(command)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &expectProc{
+		stdin: stdin,
+		out:   newShellStream(stdout),
+		wait: func() (int, error) {
+			err := session.Wait()
+			if exitErr, ok := err.(*ssh.ExitError); ok {
+				return exitErr.ExitStatus(), nil
+			}
+			return 0, err
+		},
+	}, nil
+}
+
+// run 依次执行各步骤，任一步超时或进程提前退出时停止并记录失败的步骤
+func (p *expectProc) run(opts *ExpectOptions) *ExpectResult {
+	defer p.close()
+	result := &ExpectResult{Ok: true, ExitCode: -1, Steps: make([]*ExpectStepResult, 0, len(opts.Steps))}
+	var transcript strings.Builder
+
+	fail := func(index int, err error) {
+		result.Ok = false
+		result.FailedStep = &index
+		result.Error = err.Error()
+	}
+	for i, step := range opts.Steps {
+		sr := &ExpectStepResult{Index: i, Expect: step.Expect}
+		result.Steps = append(result.Steps, sr)
+		start := time.Now()
+		if step.re != nil {
+			consumed, match, err := p.out.expect(step.re, time.After(step.Timeout))
+			transcript.WriteString(consumed)
+			if err != nil {
+				sr.Duration = float64(time.Since(start).Microseconds()) / 1000
+				fail(i, errors.New("step "+strconv.Itoa(i)+" ("+step.Expect+"): "+err.Error()))
+				break
+			}
+			sr.Matched, sr.Match = true, match
+		}
+		if step.Send != "" {
+			if _, err := io.WriteString(p.stdin, step.Send); err != nil {
+				fail(i, errors.New("step "+strconv.Itoa(i)+": "+err.Error()))
+				break
+			}
+			sr.Sent = true
+		}
+		sr.Duration = float64(time.Since(start).Microseconds()) / 1000
+	}
+
+	if result.Ok && opts.Wait {
+		if err := p.out.waitEOF(time.After(opts.Timeout)); err != nil {
+			result.Ok = false
+			result.Error = "timeout reached waiting for the command to exit"
+		}
+	}
+	if p.out.closed() {
+		// 远程的退出状态在输出结束后到达
+		done := make(chan int, 1)
+		go func() {
+			code, _ := p.wait()
+			done <- code
+		}()
+		select {
+		case result.ExitCode = <-done:
+		case <-time.After(time.Second):
+		}
+	}
+	transcript.WriteString(p.out.drain())
+	result.Transcript = transcript.String()
+	if !opts.Preserve {
+		result.Transcript = stripANSI(result.Transcript)
+	}
+	return result
+}
+
+// expect 等待未读取的输出匹配 re，返回到匹配结束为止的内容与匹配的文本
+func (s *shellStream) expect(re *regexp.Regexp, deadline <-chan time.Time) (string, string, error) {
+	consumed, match, _, err := s.expectAny([]*regexp.Regexp{re}, deadline)
+	return consumed, match, err
+}
+
+// expectAny 等待未读取的输出匹配任一表达式，多个匹配时取位置最靠前的，返回匹配的表达式序号
+func (s *shellStream) expectAny(res []*regexp.Regexp, deadline <-chan time.Time) (string, string, int, error) {
+	for {
+		s.mu.Lock()
+		data := s.buf.Bytes()
+		var loc []int
+		index := -1
+		for i, re := range res {
+			if l := re.FindIndex(data); l != nil && (loc == nil || l[0] < loc[0]) {
+				loc, index = l, i
+			}
+		}
+		if loc != nil {
+			consumed := string(data[:loc[1]])
+			match := string(data[loc[0]:loc[1]])
+			s.buf.Next(loc[1])
+			s.mu.Unlock()
+			return consumed, match, index, nil
+		}
+		err := s.err
+		s.mu.Unlock()
+		if err != nil {
+			return "", "", -1, errors.New("command exited before the pattern matched")
+		}
+		select {
+		case <-s.notify:
+		case <-deadline:
+			return "", "", -1, errors.New("timeout reached")
+		}
+	}
+}
+
+// waitEOF 等待输出结束
+func (s *shellStream) waitEOF(deadline <-chan time.Time) error {
+	for !s.closed() {
+		select {
+		case <-s.notify:
+		case <-deadline:
+			return errors.New("timeout reached")
+		}
+	}
+	return nil
+}
+
+// closed 判断输出是否已经结束
+func (s *shellStream) closed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err != nil
+}
@@ -0,0 +1,55 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const expectTestScript = `printf 'Name: '; read name; stty -echo; printf 'Password: '; read pass; stty echo; echo; echo "hello $name ($pass)"; exit 3`
+
+var expectTestSteps = []interface{}{
+	map[string]interface{}{"expect": "Name: $", "send": "bob\n"},
+	map[string]interface{}{"expect": "(?i)password:", "send": "secret\n"},
+	map[string]interface{}{"expect": `hello \w+`},
+}
+
+func TestExpect(t *testing.T) {
+	result := &ExpectResult{}
+	json.Unmarshal([]byte(execOutput(t, "expect", expectTestScript, expectTestSteps)), result)
+	if !result.Ok || result.FailedStep != nil || result.ExitCode != 3 || len(result.Steps) != 3 || result.Steps[2].Match != "hello bob" {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if !strings.Contains(result.Transcript, "Name: bob\n") || !strings.Contains(result.Transcript, "hello bob (secret)") || strings.Contains(result.Transcript, "Password: secret") {
+		t.Errorf("unexpected transcript %q", result.Transcript)
+	}
+
+	// 超时与提前退出时指出失败的步骤，超时后仍在运行的进程被结束并回收
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	result = &ExpectResult{}
+	json.Unmarshal([]byte(execOutput(t, "expect", "echo $$ > "+pidFile+"; echo started; exec sleep 5", `[{"expect":"started"},{"expect":"never","timeout":1}]`)), result)
+	if result.Ok || result.FailedStep == nil || *result.FailedStep != 1 || !strings.Contains(result.Error, "timeout") || result.Transcript != "started\n" {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if _, err := os.Stat("/proc/" + strings.TrimSpace(readTestFile(t, pidFile))); !os.IsNotExist(err) {
+		t.Errorf("process not reaped: %v", err)
+	}
+	result = &ExpectResult{}
+	json.Unmarshal([]byte(execOutput(t, "expect", "echo bye", []interface{}{map[string]interface{}{"expect": "never"}})), result)
+	if result.Ok || result.FailedStep == nil || *result.FailedStep != 0 || !strings.Contains(result.Error, "exited") || result.ExitCode != 0 {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
+
+func TestRemoteExpect(t *testing.T) {
+	server := startTestSSHServer(t)
+	result := &ExpectResult{}
+	json.Unmarshal([]byte(execOutput(t, "remote_expect", server.Host, server.Port, testSSHUser, testSSHPassword, expectTestScript, expectTestSteps, map[string]interface{}{"term": "vt100"})), result)
+	if !result.Ok || result.ExitCode != 3 || !strings.Contains(result.Transcript, "hello bob (secret)") {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// factsScript 收集主机信息的探测命令，每段输出以 @@名称 开始。
+// 每个探测都有后备命令并忽略错误，BusyBox、macOS 与 BSD 上缺少的命令只会让对应的段为空
+var factsScript = strings.Join([]string{
+	"echo @@uname; uname -s; uname -r; uname -m",
+	"echo @@hostname; hostname 2>/dev/null || uname -n",
+	"echo @@os_release; cat /etc/os-release 2>/dev/null || cat /usr/lib/os-release 2>/dev/null",
+	"echo @@sw_vers; sw_vers 2>/dev/null",
+	"echo @@cpus; nproc 2>/dev/null || getconf _NPROCESSORS_ONLN 2>/dev/null || sysctl -n hw.ncpu 2>/dev/null",
+	"echo @@cpu_model; grep -m 1 -i '^model name' /proc/cpuinfo 2>/dev/null || sysctl -n machdep.cpu.brand_string 2>/dev/null || sysctl -n hw.model 2>/dev/null",
+	"echo @@meminfo; cat /proc/meminfo 2>/dev/null",
+	"echo @@memsize; sysctl -n hw.memsize 2>/dev/null || sysctl -n hw.physmem 2>/dev/null",
+	"echo @@df; df -kP 2>/dev/null || df -k 2>/dev/null",
+	"echo @@uptime; cat /proc/uptime 2>/dev/null",
+	"echo @@boottime; sysctl -n kern.boottime 2>/dev/null",
+	"echo @@now; date +%s",
+	"echo @@addr; ip -o addr show 2>/dev/null || ifconfig -a 2>/dev/null",
+	"echo @@route; ip route get 1.1.1.1 2>/dev/null",
+	`echo @@busybox; readlink -f "$(command -v ls)" 2>/dev/null`,
+	"exit 0",
+}, "\n")
+
+// HostFacts 主机信息，无法取得的项记录在 missing 中
+type HostFacts struct {
+	Hostname      string        `json:"hostname"`
+	OS            *OSFacts      `json:"os"`
+	Kernel        string        `json:"kernel"`
+	Arch          string        `json:"arch"`
+	CPUs          int           `json:"cpus"`
+	CPUModel      string        `json:"cpu_model,omitempty"`
+	Memory        *MemoryFacts  `json:"memory,omitempty"`
+	Mounts        []*MountFacts `json:"mounts"`
+	UptimeSeconds float64       `json:"uptime_seconds"`
+	IPs           []string      `json:"ips"` // 除回环与链路本地地址以外的地址
+	PrimaryIP     string        `json:"primary_ip,omitempty"`
+	BusyBox       bool          `json:"busybox"`
+	Missing       []string      `json:"missing,omitempty"`
+}
+
+// OSFacts 操作系统，family 为 uname -s 的小写形式，例如 linux、darwin、freebsd
+type OSFacts struct {
+	Family  string `json:"family"`
+	Name    string `json:"name"`
+	ID      string `json:"id,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+// MemoryFacts 内存与交换分区，单位为字节
+type MemoryFacts struct {
+	TotalBytes     uint64 `json:"total_bytes"`
+	AvailableBytes uint64 `json:"available_bytes,omitempty"`
+	SwapTotalBytes uint64 `json:"swap_total_bytes"`
+	SwapFreeBytes  uint64 `json:"swap_free_bytes"`
+}
+
+// MountFacts 一个挂载点的磁盘空间，单位为字节
+type MountFacts struct {
+	Filesystem     string  `json:"filesystem"`
+	Mount          string  `json:"mount"`
+	TotalBytes     uint64  `json:"total_bytes"`
+	UsedBytes      uint64  `json:"used_bytes"`
+	AvailableBytes uint64  `json:"available_bytes"`
+	UsedPercent    float64 `json:"used_percent"`
+}
+
+// SSHGatherFacts 在目标主机上执行探测命令并解析主机信息，不依赖主机的登录 shell
+func SSHGatherFacts(target *SSHTarget, timeout time.Duration) (*HostFacts, error) {
+	stdout, stderr, _, err := target.Run("sh -c "+shellQuote(factsScript), timeout)
+	if _, ok := err.(*ssh.ExitError); err != nil && !ok {
+		return nil, err
+	}
+	if !strings.Contains(stdout, "@@uname") {
+		return nil, errors.New("Failed to gather facts: " + strings.TrimSpace(stderr))
+	}
+	return parseFacts(splitFactSections(stdout)), nil
+}
+
+// SSHGatherFactsMulti 在多台主机上并行收集主机信息，结果在每台主机的 data 中
+func SSHGatherFactsMulti(targets []*SSHTarget, opts *MultiOptions) *MultiResult {
+	return runOnHosts(targets, opts, func(target *SSHTarget) *HostResult {
+		target.Timeout = opts.Timeout
+		facts, err := SSHGatherFacts(target, opts.Timeout)
+		if err != nil {
+			return &HostResult{ExitCode: -1, Error: err.Error()}
+		}
+		return &HostResult{Ok: true, Data: facts}
+	})
+}
+
+// splitFactSections 按 @@名称 行拆分探测输出
+func splitFactSections(output string) map[string]string {
+	sections := map[string]string{}
+	name := ""
+	var lines []string
+	flush := func() {
+		if name != "" {
+			sections[name] = strings.TrimSpace(strings.Join(lines, "\n"))
+		}
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(output, "\r\n", "\n"), "\n") {
+		if strings.HasPrefix(line, "@@") {
+			flush()
+			name, lines = strings.TrimSpace(line[2:]), nil
+			continue
+		}
+		lines = append(lines, line)
+	}
+	flush()
+	return sections
+}
+
+// parseFacts 解析各段探测输出
+func parseFacts(sections map[string]string) *HostFacts {
+	facts := &HostFacts{Mounts: []*MountFacts{}, IPs: []string{}}
+	missing := func(name string) {
+		facts.Missing = append(facts.Missing, name)
+	}
+
+	uname := strings.Split(sections["uname"], "\n")
+	if len(uname) >= 3 {
+		facts.Kernel = strings.TrimSpace(uname[1])
+		facts.Arch = strings.TrimSpace(uname[2])
+	}
+	facts.Hostname = firstLine(sections["hostname"])
+	facts.OS = parseOSFacts(strings.TrimSpace(uname[0]), sections["os_release"], sections["sw_vers"])
+	if facts.OS.Family == "" {
+		missing("os")
+	}
+
+	facts.CPUs, _ = strconv.Atoi(firstLine(sections["cpus"]))
+	if facts.CPUs <= 0 {
+		missing("cpus")
+	}
+	facts.CPUModel = firstLine(sections["cpu_model"])
+	if i := strings.Index(facts.CPUModel, ":"); i >= 0 && strings.HasPrefix(strings.ToLower(facts.CPUModel), "model name") {
+		facts.CPUModel = strings.TrimSpace(facts.CPUModel[i+1:])
+	}
+
+	facts.Memory = parseMeminfo(sections["meminfo"])
+	if facts.Memory == nil {
+		if total, err := strconv.ParseUint(firstLine(sections["memsize"]), 10, 64); err == nil && total > 0 {
+			facts.Memory = &MemoryFacts{TotalBytes: total}
+		} else {
+			missing("memory")
+		}
+	}
+
+	facts.Mounts = parseDF(sections["df"])
+	if len(facts.Mounts) == 0 {
+		missing("mounts")
+	}
+
+	if fields := strings.Fields(sections["uptime"]); len(fields) > 0 {
+		facts.UptimeSeconds, _ = strconv.ParseFloat(fields[0], 64)
+	} else if m := bootTimeRe.FindStringSubmatch(sections["boottime"]); m != nil {
+		boot, _ := strconv.ParseFloat(m[1], 64)
+		now, err := strconv.ParseFloat(firstLine(sections["now"]), 64)
+		if err == nil && now > boot {
+			facts.UptimeSeconds = now - boot
+		}
+	}
+	if facts.UptimeSeconds <= 0 {
+		missing("uptime")
+	}
+
+	facts.IPs = parseAddresses(sections["addr"])
+	if m := routeSrcRe.FindStringSubmatch(sections["route"]); m != nil {
+		facts.PrimaryIP = m[1]
+	} else {
+		for _, ip := range facts.IPs {
+			if strings.Contains(ip, ".") {
+				facts.PrimaryIP = ip
+				break
+			}
+		}
+	}
+	if len(facts.IPs) == 0 {
+		missing("ips")
+	}
+
+	facts.BusyBox = strings.HasSuffix(firstLine(sections["busybox"]), "/busybox")
+	return facts
+}
+
+// bootTimeRe 匹配 BSD 与 macOS 的 kern.boottime，例如 { sec = 1700000000, usec = 0 } ...
+var bootTimeRe = regexp.MustCompile(`sec = (\d+)`)
+
+// routeSrcRe 匹配 ip route get 输出中的源地址
+var routeSrcRe = regexp.MustCompile(`\bsrc (\S+)`)
+
+// parseOSFacts 读取 /etc/os-release，macOS 读取 sw_vers，都没有时使用 uname
+func parseOSFacts(kernelName, osRelease, swVers string) *OSFacts {
+	info := &OSFacts{Family: strings.ToLower(kernelName)}
+	values := parseKeyValues(osRelease, "=")
+	if len(values) > 0 {
+		info.Name = firstNonEmpty(values["PRETTY_NAME"], values["NAME"])
+		info.ID = values["ID"]
+		info.Version = values["VERSION_ID"]
+	} else if values = parseKeyValues(swVers, ":"); len(values) > 0 {
+		info.Name = strings.TrimSpace(values["ProductName"] + " " + values["ProductVersion"])
+		info.ID = strings.ToLower(values["ProductName"])
+		info.Version = values["ProductVersion"]
+	}
+	if info.Name == "" {
+		info.Name = kernelName
+	}
+	return info
+}
+
+// parseKeyValues 解析 KEY=VALUE 或 Key: Value 格式的行，去掉值两端的引号
+func parseKeyValues(text, sep string) map[string]string {
+	values := map[string]string{}
+	for _, line := range strings.Split(text, "\n") {
+		i := strings.Index(line, sep)
+		if i <= 0 || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		values[strings.TrimSpace(line[:i])] = strings.Trim(strings.TrimSpace(line[i+len(sep):]), `"'`)
+	}
+	return values
+}
+
+// parseMeminfo 解析 /proc/meminfo，旧内核没有 MemAvailable 时用 MemFree + Buffers + Cached 估算
+func parseMeminfo(text string) *MemoryFacts {
+	kb := map[string]uint64{}
+	for _, line := range strings.Split(text, "\n") {
+		fields := strings.Fields(strings.Replace(line, ":", " ", 1))
+		if len(fields) >= 2 {
+			if v, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+				kb[fields[0]] = v * 1024
+			}
+		}
+	}
+	if kb["MemTotal"] == 0 {
+		return nil
+	}
+	mem := &MemoryFacts{TotalBytes: kb["MemTotal"], SwapTotalBytes: kb["SwapTotal"], SwapFreeBytes: kb["SwapFree"]}
+	if avail, ok := kb["MemAvailable"]; ok {
+		mem.AvailableBytes = avail
+	} else {
+		mem.AvailableBytes = kb["MemFree"] + kb["Buffers"] + kb["Cached"]
+	}
+	return mem
+}
+
+// pseudoFilesystems 不计入磁盘的内存与虚拟文件系统
+var pseudoFilesystems = map[string]bool{"tmpfs": true, "devtmpfs": true, "udev": true, "shm": true, "none": true, "devfs": true, "map": true, "proc": true, "sysfs": true, "cgroup": true}
+
+// parseDF 解析 df -kP 的输出，以容量百分比列定位，文件系统名与挂载点可以包含空格
+func parseDF(text string) []*MountFacts {
+	mounts := make([]*MountFacts, 0)
+	for _, line := range strings.Split(text, "\n") {
+		fields := strings.Fields(line)
+		for i := 4; i < len(fields)-1; i++ {
+			if !strings.HasSuffix(fields[i], "%") {
+				continue
+			}
+			total, err1 := strconv.ParseUint(fields[i-3], 10, 64)
+			used, err2 := strconv.ParseUint(fields[i-2], 10, 64)
+			avail, err3 := strconv.ParseUint(fields[i-1], 10, 64)
+			if err1 != nil || err2 != nil || err3 != nil {
+				break
+			}
+			mount := &MountFacts{
+				Filesystem:     strings.Join(fields[:i-3], " "),
+				Mount:          strings.Join(fields[i+1:], " "),
+				TotalBytes:     total * 1024,
+				UsedBytes:      used * 1024,
+				AvailableBytes: avail * 1024,
+			}
+			if pseudoFilesystems[strings.Fields(mount.Filesystem)[0]] || total == 0 || strings.HasPrefix(mount.Mount, "/dev/") || mount.Mount == "/dev" ||
+				strings.HasPrefix(mount.Mount, "/proc") || strings.HasPrefix(mount.Mount, "/sys") || strings.HasPrefix(mount.Mount, "/run") {
+				break
+			}
+			if used+avail > 0 {
+				mount.UsedPercent = float64(int(float64(used)/float64(used+avail)*10000)) / 100
+			}
+			mounts = append(mounts, mount)
+			break
+		}
+	}
+	return mounts
+}
+
+// parseAddresses 解析 ip -o addr show 或 ifconfig 的输出，去掉回环与链路本地地址
+func parseAddresses(text string) []string {
+	ips := make([]string, 0)
+	for _, line := range strings.Split(text, "\n") {
+		fields := strings.Fields(line)
+		for i := 0; i+1 < len(fields); i++ {
+			if fields[i] != "inet" && fields[i] != "inet6" {
+				continue
+			}
+			addr := strings.TrimPrefix(fields[i+1], "addr:")
+			if addr == "" && i+2 < len(fields) {
+				// ifconfig 的 inet6 addr: fe80::1/64
+				addr = fields[i+2]
+			}
+			if j := strings.IndexAny(addr, "/%"); j >= 0 {
+				addr = addr[:j]
+			}
+			ip := net.ParseIP(addr)
+			if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+				break
+			}
+			ips = appendUnique(ips, ip.String())
+			break
+		}
+	}
+	return ips
+}
+
+// firstLine 返回去掉空白后的第一行
+func firstLine(text string) string {
+	text = strings.TrimSpace(text)
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		text = text[:i]
+	}
+	return strings.TrimSpace(text)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestRemoteFacts(t *testing.T) {
+	server := startTestSSHServer(t)
+
+	result := &MultiResult{}
+	json.Unmarshal([]byte(execOutput(t, "remote_facts", server.Host+":"+server.Port, "", testSSHUser, testSSHPassword)), result)
+	if len(result.Results) != 1 || !result.Results[0].Ok {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	data, _ := json.Marshal(result.Results[0].Data)
+	facts := &HostFacts{}
+	json.Unmarshal(data, facts)
+	if facts.Hostname == "" || facts.OS == nil || facts.OS.Family != "linux" || facts.Kernel == "" || facts.Arch == "" || facts.CPUs <= 0 {
+		t.Errorf("unexpected facts %s", data)
+	}
+	if facts.Memory == nil || facts.Memory.TotalBytes == 0 || facts.UptimeSeconds <= 0 || len(facts.Mounts) == 0 {
+		t.Errorf("unexpected facts %s", data)
+	}
+}
+
+func TestParseFactsBusyBox(t *testing.T) {
+	facts := parseFacts(map[string]string{
+		"uname":    "Linux\n4.14.180\narmv7l",
+		"hostname": "router",
+		"meminfo":  "MemTotal:         246052 kB\nMemFree:           51236 kB\nBuffers:            4096 kB\nCached:            40960 kB\nSwapTotal:             0 kB\nSwapFree:              0 kB",
+		"df": `Filesystem           1K-blocks      Used Available Use% Mounted on
+/dev/root                 12288     12288         0 100% /rom
+tmpfs                    123024      1180    121844   1% /tmp
+/dev/ubi0_1               90432       672     85156   1% /overlay`,
+		"uptime": "3600.52 3500.10",
+		"addr": `br-lan    Link encap:Ethernet  HWaddr 00:11:22:33:44:55
+          inet addr:192.168.1.1  Bcast:192.168.1.255  Mask:255.255.255.0
+          inet6 addr: fe80::211:22ff:fe33:4455/64 Scope:Link
+          inet6 addr: fd00::1/60 Scope:Global
+lo        Link encap:Local Loopback
+          inet addr:127.0.0.1  Mask:255.0.0.0`,
+		"busybox": "/bin/busybox",
+	})
+	if facts.OS.Family != "linux" || facts.OS.Name != "Linux" || facts.Kernel != "4.14.180" || facts.Arch != "armv7l" || !facts.BusyBox {
+		t.Errorf("unexpected facts %+v %+v", facts, facts.OS)
+	}
+	if facts.Memory == nil || facts.Memory.TotalBytes != 246052*1024 || facts.Memory.AvailableBytes != (51236+4096+40960)*1024 {
+		t.Errorf("unexpected memory %+v", facts.Memory)
+	}
+	if len(facts.Mounts) != 2 || facts.Mounts[0].Mount != "/rom" || facts.Mounts[0].UsedPercent != 100 || facts.Mounts[1].AvailableBytes != 85156*1024 {
+		t.Errorf("unexpected mounts %+v", facts.Mounts)
+	}
+	if !reflect.DeepEqual(facts.IPs, []string{"192.168.1.1", "fd00::1"}) || facts.PrimaryIP != "192.168.1.1" || facts.UptimeSeconds != 3600.52 {
+		t.Errorf("unexpected facts %+v", facts)
+	}
+	// 缺少 nproc 与 getconf 时记录缺失的项
+	if !reflect.DeepEqual(facts.Missing, []string{"cpus"}) {
+		t.Errorf("unexpected missing %v", facts.Missing)
+	}
+}
+
+func TestParseFactsDarwin(t *testing.T) {
+	facts := parseFacts(map[string]string{
+		"uname":     "Darwin\n23.1.0\narm64",
+		"hostname":  "mac.local",
+		"sw_vers":   "ProductName:\t\tmacOS\nProductVersion:\t\t14.1\nBuildVersion:\t\t23B74",
+		"cpus":      "8",
+		"cpu_model": "Apple M1",
+		"memsize":   "17179869184",
+		"df": `Filesystem     1024-blocks      Used Available Capacity  Mounted on
+/dev/disk3s1s1   482797652   9534608 298484868     4%    /
+devfs                  201       201         0   100%    /dev
+map auto_home            0         0         0   100%    /System/Volumes/Data/home
+/dev/disk3s5     482797652 171338892 298484868    37%    /System/Volumes/Data`,
+		"boottime": "{ sec = 1700000000, usec = 123456 } Tue Nov 14 22:13:20 2023",
+		"now":      "1700086400",
+		"addr": `lo0: flags=8049<UP,LOOPBACK,RUNNING,MULTICAST> mtu 16384
+	inet 127.0.0.1 netmask 0xff000000
+	inet6 fe80::1%lo0 prefixlen 64 scopeid 0x1
+en0: flags=8863<UP,BROADCAST,SMART,RUNNING,SIMPLEX,MULTICAST> mtu 1500
+	inet 10.0.0.12 netmask 0xffffff00 broadcast 10.0.0.255`,
+	})
+	if facts.OS.Family != "darwin" || facts.OS.Name != "macOS 14.1" || facts.OS.Version != "14.1" || facts.CPUs != 8 || facts.CPUModel != "Apple M1" {
+		t.Errorf("unexpected facts %+v %+v", facts, facts.OS)
+	}
+	if facts.Memory == nil || facts.Memory.TotalBytes != 17179869184 || facts.UptimeSeconds != 86400 {
+		t.Errorf("unexpected facts %+v", facts)
+	}
+	if len(facts.Mounts) != 2 || facts.Mounts[1].Mount != "/System/Volumes/Data" || facts.Mounts[1].Filesystem != "/dev/disk3s5" {
+		t.Errorf("unexpected mounts %+v", facts.Mounts)
+	}
+	if !reflect.DeepEqual(facts.IPs, []string{"10.0.0.12"}) || facts.PrimaryIP != "10.0.0.12" || facts.BusyBox || len(facts.Missing) != 0 {
+		t.Errorf("unexpected facts %+v", facts)
+	}
+}
@@ -9,7 +9,10 @@ require (
 	github.com/pkg/sftp v1.13.6
 	github.com/yaoapp/kun v0.9.0
 	golang.org/x/crypto v0.1.0
+	golang.org/x/net v0.2.0
+	golang.org/x/sys v0.2.0
 	golang.org/x/text v0.4.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -26,8 +29,6 @@ require (
 	github.com/modern-go/reflect2 v1.0.1 // indirect
 	github.com/oklog/run v1.1.0 // indirect
 	golang.org/x/mod v0.7.0 // indirect
-	golang.org/x/net v0.2.0 // indirect
-	golang.org/x/sys v0.2.0 // indirect
 	golang.org/x/tools v0.3.0 // indirect
 	google.golang.org/genproto v0.0.0-20210821163610-241b8fcbd6c8 // indirect
 	google.golang.org/grpc v1.40.0 // indirect
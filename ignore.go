@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// defaultIgnoreFile 源目录中默认读取的忽略规则文件
+const defaultIgnoreFile = ".ignore"
+
+// ignoreRule 一条 gitignore 风格的匹配规则
+type ignoreRule struct {
+	base    string // 规则所在目录（相对源目录），规则只作用于该目录下的路径
+	negate  bool   // 以 ! 开头，重新包含已被排除的路径
+	dirOnly bool   // 以 / 结尾，只匹配目录
+	re      *regexp.Regexp
+}
+
+// pathMatcher 按 gitignore 语义判断路径是否匹配，后出现的规则优先
+type pathMatcher struct {
+	rules []*ignoreRule
+}
+
+// newPathMatcher 根据规则列表创建匹配器，base 为规则所在的相对目录
+func newPathMatcher(patterns []string, base string) *pathMatcher {
+	m := &pathMatcher{}
+	m.add(patterns, base)
+	return m
+}
+
+// add 追加规则，忽略空行与 # 注释
+func (m *pathMatcher) add(patterns []string, base string) {
+	for _, line := range patterns {
+		if rule := parseIgnoreRule(line, base); rule != nil {
+			m.rules = append(m.rules, rule)
+		}
+	}
+}
+
+// addFile 读取忽略规则文件，文件不存在时忽略
+func (m *pathMatcher) addFile(file string, base string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	m.add(lines, base)
+	return scanner.Err()
+}
+
+// match 返回路径是否被规则命中，matched 为 false 表示没有任何规则涉及该路径
+func (m *pathMatcher) match(relPath string, isDir bool) (hit bool, matched bool) {
+	if m == nil {
+		return false, false
+	}
+	for _, rule := range m.rules {
+		p := relPath
+		if rule.base != "" {
+			if !strings.HasPrefix(relPath, rule.base+"/") {
+				continue
+			}
+			p = strings.TrimPrefix(relPath, rule.base+"/")
+		}
+		if rule.matchPath(p, isDir) {
+			hit = !rule.negate
+			matched = true
+		}
+	}
+	return hit, matched
+}
+
+// matchPath 路径本身或其任意上级目录命中规则即视为匹配
+func (rule *ignoreRule) matchPath(p string, isDir bool) bool {
+	if (isDir || !rule.dirOnly) && rule.re.MatchString(p) {
+		return true
+	}
+	for i := 0; i < len(p); i++ {
+		if p[i] == '/' && rule.re.MatchString(p[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseIgnoreRule 将一行 gitignore 规则转换为正则表达式
+func parseIgnoreRule(line string, base string) *ignoreRule {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+	rule := &ignoreRule{base: strings.Trim(base, "/")}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return nil
+	}
+
+	// 包含 / 的规则相对规则所在目录锚定，否则匹配任意层级的名称
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	var sb strings.Builder
+	sb.WriteString("^")
+	if !anchored {
+		sb.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch c {
+		case '*':
+			if i+1 < len(line) && line[i+1] == '*' {
+				i++
+				if i+1 < len(line) && line[i+1] == '/' {
+					// **/ 匹配零个或多个目录
+					i++
+					sb.WriteString("(?:.*/)?")
+				} else {
+					sb.WriteString(".*")
+				}
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(line[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(`\[`)
+				continue
+			}
+			class := line[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + class + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(line) {
+				i++
+				sb.WriteString(regexp.QuoteMeta(string(line[i])))
+			}
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+
+	re, err := regexp.Compile(sb.String())
+	if err != nil {
+		return nil
+	}
+	rule.re = re
+	return rule
+}
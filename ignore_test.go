@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPathMatcher(t *testing.T) {
+	m := newPathMatcher([]string{
+		"# comment",
+		"node_modules/",
+		"*.tmp",
+		"/build",
+		"docs/**/*.pdf",
+		"!keep.tmp",
+	}, "")
+	cases := []struct {
+		path  string
+		isDir bool
+		hit   bool
+	}{
+		{"node_modules", true, true},
+		{"web/node_modules", true, true},
+		{"web/node_modules/a.js", false, true},
+		{"node_modules", false, false},
+		{"a.tmp", false, true},
+		{"sub/b.tmp", false, true},
+		{"keep.tmp", false, false},
+		{"build", true, true},
+		{"src/build", true, false},
+		{"docs/a.pdf", false, true},
+		{"docs/x/y/a.pdf", false, true},
+		{"main.go", false, false},
+	}
+	for _, c := range cases {
+		if hit, _ := m.match(c.path, c.isDir); hit != c.hit {
+			t.Errorf("match(%q, %v) = %v, want %v", c.path, c.isDir, hit, c.hit)
+		}
+	}
+}
+
+func TestPlanFolderCopy(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"main.go", "a.tmp", ".git/HEAD", "web/app.go", "web/node_modules/x.js", "web/cache/c.go", "web/.ignore"} {
+		writeTestFile(t, filepath.Join(dir, name), "x")
+	}
+	os.WriteFile(filepath.Join(dir, ".ignore"), []byte("*.tmp\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "web", ".ignore"), []byte("/cache\n"), 0644)
+
+	opts := &TransferOptions{
+		Include:    []string{"*.go"},
+		Exclude:    []string{".git/", "node_modules/"},
+		IgnoreFile: defaultIgnoreFile,
+	}
+	entries, skipped, err := planFolderCopy(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := []string{}
+	for _, entry := range entries {
+		if !entry.isDir {
+			files = append(files, entry.rel)
+		}
+	}
+	if want := []string{"main.go", "web/app.go"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("files = %v, want %v", files, want)
+	}
+	if want := []string{".git/", ".ignore", "a.tmp", "web/.ignore", "web/cache/", "web/node_modules/"}; !reflect.DeepEqual(skipped, want) {
+		t.Errorf("skipped = %v, want %v", skipped, want)
+	}
+}
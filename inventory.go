@@ -0,0 +1,548 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 清单文件查找顺序：CMDT_INVENTORY 指定的文件，否则在 Yao 应用目录（YAO_ROOT 或当前目录）下查找
+var inventoryFiles = []string{"inventory.yml", "inventory.yaml", "inventory.json"}
+
+// Credential 凭据，主机与分组通过名称引用，避免在调用参数中传递密码
+type Credential struct {
+	User           string `json:"user,omitempty" yaml:"user"`
+	Password       string `json:"password,omitempty" yaml:"password"`
+	PasswordEnv    string `json:"password_env,omitempty" yaml:"password_env"`         // 从环境变量读取密码
+	PrivateKey     string `json:"private_key,omitempty" yaml:"private_key"`           // 私钥内容
+	PrivateKeyFile string `json:"private_key_file,omitempty" yaml:"private_key_file"` // 私钥文件路径，相对路径基于清单文件所在目录
+}
+
+// InventoryHost 清单中的主机
+type InventoryHost struct {
+	Name       string                 `json:"name" yaml:"-"`
+	Host       string                 `json:"host" yaml:"host"`
+	Port       string                 `json:"port,omitempty" yaml:"port"`
+	User       string                 `json:"user,omitempty" yaml:"user"`
+	Credential string                 `json:"credential,omitempty" yaml:"credential"`
+	Proxy      string                 `json:"proxy,omitempty" yaml:"proxy"`
+	Algorithms *SSHAlgorithms         `json:"algorithms,omitempty" yaml:"algorithms"`
+	Groups     []string               `json:"groups" yaml:"-"`
+	Vars       map[string]interface{} `json:"vars" yaml:"vars"`
+}
+
+// InventoryGroup 清单中的主机分组，children 引用其他分组
+type InventoryGroup struct {
+	Hosts      []string               `json:"hosts" yaml:"hosts"`
+	Children   []string               `json:"children,omitempty" yaml:"children"`
+	Port       string                 `json:"port,omitempty" yaml:"port"`
+	User       string                 `json:"user,omitempty" yaml:"user"`
+	Credential string                 `json:"credential,omitempty" yaml:"credential"`
+	Proxy      string                 `json:"proxy,omitempty" yaml:"proxy"`
+	Algorithms *SSHAlgorithms         `json:"algorithms,omitempty" yaml:"algorithms"`
+	Vars       map[string]interface{} `json:"vars,omitempty" yaml:"vars"`
+}
+
+// InventoryDefaults 所有主机的默认值
+type InventoryDefaults struct {
+	Port       string                 `json:"port,omitempty" yaml:"port"`
+	User       string                 `json:"user,omitempty" yaml:"user"`
+	Credential string                 `json:"credential,omitempty" yaml:"credential"`
+	Proxy      string                 `json:"proxy,omitempty" yaml:"proxy"`
+	Algorithms *SSHAlgorithms         `json:"algorithms,omitempty" yaml:"algorithms"`
+	Vars       map[string]interface{} `json:"vars,omitempty" yaml:"vars"`
+}
+
+// Inventory 主机清单
+type Inventory struct {
+	Defaults    InventoryDefaults          `json:"defaults" yaml:"defaults"`
+	Credentials map[string]*Credential     `json:"credentials" yaml:"credentials"`
+	Hosts       map[string]*InventoryHost  `json:"hosts" yaml:"hosts"`
+	Groups      map[string]*InventoryGroup `json:"groups" yaml:"groups"`
+
+	file string
+}
+
+// inventoryCache 按文件修改时间缓存已加载的清单
+var inventoryCache struct {
+	sync.Mutex
+	file    string
+	modTime int64
+	inv     *Inventory
+}
+
+// inventoryPath 返回清单文件路径，不存在时返回空字符串
+func inventoryPath() string {
+	if file := os.Getenv("CMDT_INVENTORY"); file != "" {
+		return file
+	}
+	root := inventoryRoot()
+	for _, name := range inventoryFiles {
+		file := filepath.Join(root, name)
+		if _, err := os.Stat(file); err == nil {
+			return file
+		}
+	}
+	return ""
+}
+
+// inventoryRoot 返回 Yao 应用目录，未设置 YAO_ROOT 时为当前目录
+func inventoryRoot() string {
+	root := os.Getenv("YAO_ROOT")
+	if root == "" {
+		root, _ = os.Getwd()
+	}
+	return root
+}
+
+// inventoryWritePath 返回写入清单使用的文件，没有清单文件时为应用目录下的 inventory.yml
+func inventoryWritePath() string {
+	if file := inventoryPath(); file != "" {
+		return file
+	}
+	return filepath.Join(inventoryRoot(), inventoryFiles[0])
+}
+
+// loadInventory 加载主机清单，没有清单文件时返回空清单
+func loadInventory() (*Inventory, error) {
+	file := inventoryPath()
+	if file == "" {
+		return &Inventory{}, nil
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, errors.New("Failed to load inventory: " + err.Error())
+	}
+
+	inventoryCache.Lock()
+	defer inventoryCache.Unlock()
+	if inventoryCache.inv != nil && inventoryCache.file == file && inventoryCache.modTime == info.ModTime().UnixNano() {
+		return inventoryCache.inv, nil
+	}
+	inv, err := parseInventoryFile(file)
+	if err != nil {
+		return nil, err
+	}
+	inventoryCache.file = file
+	inventoryCache.modTime = info.ModTime().UnixNano()
+	inventoryCache.inv = inv
+	return inv, nil
+}
+
+// parseInventoryFile 解析 YAML 或 JSON 格式的清单文件
+func parseInventoryFile(file string) (*Inventory, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.New("Failed to load inventory: " + err.Error())
+	}
+	// JSON 是 YAML 的子集，两种格式都按 YAML 解析，端口可以写成数字
+	inv := &Inventory{}
+	if err := yaml.Unmarshal(data, inv); err != nil {
+		return nil, errors.New("Failed to parse inventory " + file + ": " + err.Error())
+	}
+	inv.file = file
+	if err := inv.validate(); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// validate 检查分组与凭据引用是否存在
+func (inv *Inventory) validate() error {
+	for name, host := range inv.Hosts {
+		if host == nil {
+			inv.Hosts[name] = &InventoryHost{}
+		}
+		if err := inv.checkCredential(inv.Hosts[name].Credential, "host "+name); err != nil {
+			return err
+		}
+	}
+	for name, group := range inv.Groups {
+		if group == nil {
+			group = &InventoryGroup{}
+			inv.Groups[name] = group
+		}
+		if _, ok := inv.Hosts[name]; ok {
+			return errors.New("Inventory group name conflicts with host: " + name)
+		}
+		for _, host := range group.Hosts {
+			if _, ok := inv.Hosts[host]; !ok {
+				return errors.New("Inventory group " + name + " references unknown host: " + host)
+			}
+		}
+		for _, child := range group.Children {
+			if _, ok := inv.Groups[child]; !ok {
+				return errors.New("Inventory group " + name + " references unknown group: " + child)
+			}
+		}
+		if err := inv.checkCredential(group.Credential, "group "+name); err != nil {
+			return err
+		}
+	}
+	for name := range inv.Groups {
+		if _, err := inv.GroupMembers(name); err != nil {
+			return err
+		}
+	}
+	return inv.checkCredential(inv.Defaults.Credential, "defaults")
+}
+
+func (inv *Inventory) checkCredential(name string, owner string) error {
+	if name == "" {
+		return nil
+	}
+	if _, ok := inv.Credentials[name]; !ok {
+		return errors.New("Inventory " + owner + " references unknown credential: " + name)
+	}
+	return nil
+}
+
+// HostNames 返回排序后的主机名列表
+func (inv *Inventory) HostNames() []string {
+	names := make([]string, 0, len(inv.Hosts))
+	for name := range inv.Hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// hostGroups 返回直接或间接包含主机的分组，按名称排序
+func (inv *Inventory) hostGroups(name string) []string {
+	groups := make([]string, 0)
+	for group := range inv.Groups {
+		members, _ := inv.GroupMembers(group)
+		for _, member := range members {
+			if member == name {
+				groups = append(groups, group)
+				break
+			}
+		}
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// GroupMembers 返回分组（包括子分组）内的主机名，保持定义顺序并去重
+func (inv *Inventory) GroupMembers(name string) ([]string, error) {
+	if _, ok := inv.Groups[name]; !ok {
+		return nil, errors.New("Inventory group not found: " + name)
+	}
+	members := make([]string, 0)
+	seen := map[string]bool{}
+	visiting := map[string]bool{}
+	var walk func(group string) error
+	walk = func(group string) error {
+		if visiting[group] {
+			return errors.New("Inventory group cycle detected: " + group)
+		}
+		visiting[group] = true
+		defer delete(visiting, group)
+		for _, host := range inv.Groups[group].Hosts {
+			if !seen[host] {
+				seen[host] = true
+				members = append(members, host)
+			}
+		}
+		for _, child := range inv.Groups[group].Children {
+			if err := walk(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(name); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// Host 返回合并了默认值与分组设置的主机信息，优先级：主机 > 分组 > 默认值
+func (inv *Inventory) Host(name string) (*InventoryHost, bool) {
+	host, ok := inv.Hosts[name]
+	if !ok {
+		return nil, false
+	}
+	result := &InventoryHost{
+		Name:       name,
+		Port:       inv.Defaults.Port,
+		User:       inv.Defaults.User,
+		Credential: inv.Defaults.Credential,
+		Proxy:      inv.Defaults.Proxy,
+		Algorithms: inv.Defaults.Algorithms,
+		Groups:     inv.hostGroups(name),
+		Vars:       map[string]interface{}{},
+	}
+	for key, val := range inv.Defaults.Vars {
+		result.Vars[key] = val
+	}
+	for _, groupName := range result.Groups {
+		group := inv.Groups[groupName]
+		result.Port = firstNonEmpty(group.Port, result.Port)
+		result.User = firstNonEmpty(group.User, result.User)
+		result.Credential = firstNonEmpty(group.Credential, result.Credential)
+		result.Proxy = firstNonEmpty(group.Proxy, result.Proxy)
+		result.Algorithms = group.Algorithms.merge(result.Algorithms)
+		for key, val := range group.Vars {
+			result.Vars[key] = val
+		}
+	}
+	result.Host = firstNonEmpty(host.Host, name)
+	result.Port = firstNonEmpty(host.Port, result.Port, "22")
+	result.User = firstNonEmpty(host.User, result.User)
+	result.Credential = firstNonEmpty(host.Credential, result.Credential)
+	result.Proxy = firstNonEmpty(host.Proxy, result.Proxy)
+	result.Algorithms = host.Algorithms.merge(result.Algorithms)
+	for key, val := range host.Vars {
+		result.Vars[key] = val
+	}
+	if cred, ok := inv.Credentials[result.Credential]; ok {
+		result.User = firstNonEmpty(host.User, cred.User, result.User)
+	}
+	return result, true
+}
+
+// saveCredential 在清单文件的 credentials 中登记凭据，文件不存在时创建。
+// YAML 文件按节点修改，保留原有的注释与顺序；JSON 文件重新格式化。check 为 true 时只检查不写入
+func saveCredential(file string, name string, cred map[string]string, overwrite bool, check bool) error {
+	data, err := os.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.New("Failed to load inventory: " + err.Error())
+	}
+	if strings.HasSuffix(file, ".json") {
+		data, err = setJSONCredential(data, name, cred, overwrite)
+	} else {
+		data, err = setYAMLCredential(data, name, cred, overwrite)
+	}
+	if err != nil {
+		return errors.New("Failed to update inventory " + file + ": " + err.Error())
+	}
+	if check {
+		return nil
+	}
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		return errors.New("Failed to write inventory: " + err.Error())
+	}
+	return nil
+}
+
+func setYAMLCredential(data []byte, name string, cred map[string]string, overwrite bool) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, errors.New("inventory is not a mapping")
+	}
+	creds := yamlMapValue(root, "credentials")
+	if creds == nil {
+		creds = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "credentials"}, creds)
+	} else if creds.Kind == yaml.ScalarNode && creds.Tag == "!!null" {
+		*creds = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	} else if creds.Kind != yaml.MappingNode {
+		return nil, errors.New("credentials is not a mapping")
+	}
+	value := &yaml.Node{}
+	if err := value.Encode(cred); err != nil {
+		return nil, err
+	}
+	if existing := yamlMapValue(creds, name); existing != nil {
+		if !overwrite {
+			return nil, errors.New("credential already exists: " + name)
+		}
+		*existing = *value
+	} else {
+		creds.Content = append(creds.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: name}, value)
+	}
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return nil, err
+	}
+	encoder.Close()
+	return buf.Bytes(), nil
+}
+
+// yamlMapValue 返回映射节点中 key 对应的值节点
+func yamlMapValue(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func setJSONCredential(data []byte, name string, cred map[string]string, overwrite bool) ([]byte, error) {
+	inv := map[string]interface{}{}
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, &inv); err != nil {
+			return nil, err
+		}
+	}
+	creds, ok := inv["credentials"].(map[string]interface{})
+	if !ok {
+		if inv["credentials"] != nil {
+			return nil, errors.New("credentials is not an object")
+		}
+		creds = map[string]interface{}{}
+		inv["credentials"] = creds
+	}
+	if _, exists := creds[name]; exists && !overwrite {
+		return nil, errors.New("credential already exists: " + name)
+	}
+	creds[name] = cred
+	return json.MarshalIndent(inv, "", "  ")
+}
+
+// resolveCredential 读取凭据中的密码和私钥
+func (inv *Inventory) resolveCredential(name string) (password string, privateKey string, err error) {
+	cred, ok := inv.Credentials[name]
+	if !ok {
+		return "", "", nil
+	}
+	password = cred.Password
+	if cred.PasswordEnv != "" {
+		password = os.Getenv(cred.PasswordEnv)
+	}
+	privateKey = cred.PrivateKey
+	if file := inv.privateKeyFile(cred); file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", "", errors.New("Failed to read private key of credential " + name + ": " + err.Error())
+		}
+		privateKey = string(data)
+	}
+	return password, privateKey, nil
+}
+
+// privateKeyFile 返回凭据中私钥文件的路径，相对路径基于清单文件所在目录
+func (inv *Inventory) privateKeyFile(cred *Credential) string {
+	file := cred.PrivateKeyFile
+	if file != "" && !filepath.IsAbs(file) && inv.file != "" {
+		file = filepath.Join(filepath.Dir(inv.file), file)
+	}
+	return file
+}
+
+// Query 按分组、名称通配符和变量筛选主机
+func (inv *Inventory) Query(group string, pattern string, vars map[string]interface{}) ([]*InventoryHost, error) {
+	names := inv.HostNames()
+	if group != "" {
+		members, err := inv.GroupMembers(group)
+		if err != nil {
+			return nil, err
+		}
+		names = members
+	}
+	hosts := make([]*InventoryHost, 0)
+	for _, name := range names {
+		if pattern != "" {
+			if ok, err := path.Match(pattern, name); err != nil {
+				return nil, errors.New("Invalid host pattern: " + pattern)
+			} else if !ok {
+				continue
+			}
+		}
+		host, _ := inv.Host(name)
+		if !matchVars(host.Vars, vars) {
+			continue
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+// matchVars 判断主机变量是否包含所有筛选条件，按字符串比较
+func matchVars(hostVars map[string]interface{}, vars map[string]interface{}) bool {
+	for key := range vars {
+		if _, ok := hostVars[key]; !ok || optString(hostVars, key, "") != optString(vars, key, "") {
+			return false
+		}
+	}
+	return true
+}
+
+// firstNonEmpty 返回第一个非空字符串
+func firstNonEmpty(values ...string) string {
+	for _, val := range values {
+		if val != "" {
+			return val
+		}
+	}
+	return ""
+}
+
+// resolveInventory 主机地址是清单中的主机名时，用清单补全未指定的端口、用户与凭据
+func (t *SSHTarget) resolveInventory() error {
+	inv, err := loadInventory()
+	if err != nil {
+		// 清单无法加载时，IP 与域名这样的普通地址照常连接，只有可能是清单名称的主机才报错
+		if isPlainAddress(t.Host) {
+			return nil
+		}
+		return err
+	}
+	host, ok := inv.Host(t.Host)
+	if !ok {
+		if _, isGroup := inv.Groups[t.Host]; isGroup {
+			return errors.New("Inventory group can not be used as a single host: " + t.Host)
+		}
+		return nil
+	}
+	t.Host = host.Host
+	t.Port = firstNonEmpty(t.Port, host.Port)
+	t.User = firstNonEmpty(t.User, host.User)
+	t.Proxy = firstNonEmpty(t.Proxy, host.Proxy)
+	t.Algorithms = t.Algorithms.merge(host.Algorithms)
+	if t.Password == "" && t.PrivateKey == "" {
+		t.Password, t.PrivateKey, err = inv.resolveCredential(host.Credential)
+	}
+	return err
+}
+
+// isPlainAddress 判断主机是否是 IP 或带域名的地址，这样的主机不需要清单就能连接
+func isPlainAddress(host string) bool {
+	return net.ParseIP(strings.Trim(host, "[]")) != nil || strings.Contains(host, ".") || host == "localhost"
+}
+
+// expandHostList 将主机列表中的清单分组展开为分组内的主机名
+func expandHostList(hosts []string) ([]string, error) {
+	inv, err := loadInventory()
+	if err != nil {
+		for _, host := range hosts {
+			if h, _ := splitHostPort(host, ""); !isPlainAddress(h) {
+				return nil, err
+			}
+		}
+		return hosts, nil
+	}
+	list := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		if _, ok := inv.Groups[host]; !ok {
+			list = append(list, host)
+			continue
+		}
+		members, err := inv.GroupMembers(host)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, members...)
+	}
+	return list, nil
+}
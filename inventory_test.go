@@ -0,0 +1,117 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestInventory(t *testing.T, first, second *testSSHServer) {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "inventory.yml")
+	writeTestFile(t, file, `
+defaults:
+  user: `+testSSHUser+`
+  credential: test
+credentials:
+  test:
+    password_env: CMDT_TEST_PASSWORD
+hosts:
+  web1:
+    host: `+first.Host+`
+    port: `+first.Port+`
+    vars:
+      role: web
+  db1:
+    host: `+second.Host+`
+    port: `+second.Port+`
+groups:
+  web:
+    hosts: [web1]
+    vars:
+      env: prod
+  all:
+    children: [web]
+    hosts: [db1]
+`)
+	t.Setenv("CMDT_INVENTORY", file)
+	t.Setenv("CMDT_TEST_PASSWORD", testSSHPassword)
+}
+
+func execOutput(t *testing.T, name string, args ...interface{}) string {
+	t.Helper()
+	plugin := &CmdPlugin{}
+	plugin.setLogFile()
+	res, err := plugin.Exec(name, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := res.MustMap()
+	if m.Get("status") != float64(0) {
+		t.Fatalf("%s: unexpected status %v: %v", name, m.Get("status"), m.Get("msg"))
+	}
+	return m.Get("data").(map[string]interface{})["output"].(string)
+}
+
+func TestInventoryHostAndGroup(t *testing.T) {
+	writeTestInventory(t, startTestSSHServer(t), startTestSSHServer(t))
+
+	if out := execOutput(t, "remote", "web1", "", "", "", "echo hello"); strings.TrimSpace(out) != "hello" {
+		t.Errorf("unexpected output %q", out)
+	}
+
+	out := execOutput(t, "remote", "all", "", "", "", "echo hello")
+	if !strings.Contains(out, `"host":"web1"`) || !strings.Contains(out, `"host":"db1"`) || !strings.Contains(out, `"succeeded":2`) {
+		t.Errorf("unexpected output %s", out)
+	}
+
+	out = execOutput(t, "remote_multi", "all", "", "", "", "echo hello")
+	if !strings.Contains(out, `"succeeded":2`) {
+		t.Errorf("unexpected output %s", out)
+	}
+}
+
+func TestBrokenInventory(t *testing.T) {
+	server := startTestSSHServer(t)
+	t.Setenv("CMDT_INVENTORY", filepath.Join(t.TempDir(), "missing.yml"))
+
+	// 清单无法加载时普通地址照常连接
+	if out := execOutput(t, "remote", server.Host, server.Port, testSSHUser, testSSHPassword, "echo hello"); strings.TrimSpace(out) != "hello" {
+		t.Errorf("unexpected output %q", out)
+	}
+	if out := execOutput(t, "remote_multi", server.Host+":"+server.Port, "", testSSHUser, testSSHPassword, "echo hello"); !strings.Contains(out, `"succeeded":1`) {
+		t.Errorf("unexpected output %s", out)
+	}
+
+	plugin := &CmdPlugin{}
+	plugin.setLogFile()
+	res, err := plugin.Exec("remote", "web1", "", "", "", "echo hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, _ := res.MustMap().Get("msg").(string); res.MustMap().Get("status") == float64(0) || !strings.Contains(msg, "Failed to load inventory") {
+		t.Errorf("expected inventory error, got %v", res.MustMap())
+	}
+}
+
+func TestInventoryQuery(t *testing.T) {
+	writeTestInventory(t, startTestSSHServer(t), startTestSSHServer(t))
+
+	out := execOutput(t, "inventory_host", "web1")
+	if !strings.Contains(out, `"groups":["all","web"]`) || !strings.Contains(out, `"env":"prod"`) || !strings.Contains(out, `"user":"yao"`) {
+		t.Errorf("unexpected output %s", out)
+	}
+	if strings.Contains(out, testSSHPassword) {
+		t.Error("credential leaked in output")
+	}
+
+	out = execOutput(t, "inventory_query", map[string]interface{}{"group": "all", "vars": map[string]interface{}{"role": "web"}})
+	if !strings.Contains(out, `"name":"web1"`) || strings.Contains(out, `"name":"db1"`) {
+		t.Errorf("unexpected output %s", out)
+	}
+
+	out = execOutput(t, "inventory_list")
+	if !strings.Contains(out, `"all":["db1","web1"]`) {
+		t.Errorf("unexpected output %s", out)
+	}
+}
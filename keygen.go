@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// 支持生成的密钥类型
+const (
+	KeyTypeEd25519 = "ed25519"
+	KeyTypeRSA     = "rsa"
+)
+
+// KeygenOptions 生成密钥对的选项
+type KeygenOptions struct {
+	Type       string // ed25519 或 rsa
+	Bits       int    // RSA 密钥长度，默认 3072
+	Comment    string // 公钥注释，默认 cmdt@主机名
+	Credential string // 不为空时把私钥保存到文件，并在清单中登记同名凭据
+	User       string // 登记凭据时的用户名
+	Dir        string // 私钥文件保存目录，默认为清单文件所在目录下的 keys
+	Overwrite  bool   // 覆盖已存在的凭据与密钥文件
+}
+
+// SSHKeyPair 生成的密钥对，保存到凭据时不返回私钥内容
+type SSHKeyPair struct {
+	Type           string `json:"type"`
+	Bits           int    `json:"bits"`
+	Comment        string `json:"comment"`
+	PublicKey      string `json:"public_key"` // authorized_keys 格式
+	Fingerprint    string `json:"fingerprint"`
+	PrivateKey     string `json:"private_key,omitempty"` // OpenSSH 格式，未加密
+	Credential     string `json:"credential,omitempty"`
+	PrivateKeyFile string `json:"private_key_file,omitempty"`
+	PublicKeyFile  string `json:"public_key_file,omitempty"`
+	Inventory      string `json:"inventory,omitempty"`
+}
+
+// credentialNameRe 凭据名称同时用作密钥文件名
+var credentialNameRe = regexp.MustCompile(`^[\w.\-]+$`)
+
+// parseKeygenOptions 读取选项 {type, bits, comment, credential, user, dir, overwrite}
+func parseKeygenOptions(opts map[string]interface{}) (*KeygenOptions, error) {
+	kopts := &KeygenOptions{
+		Type:       strings.ToLower(optString(opts, "type", KeyTypeEd25519)),
+		Bits:       optInt(opts, "bits", 3072),
+		Comment:    optString(opts, "comment", ""),
+		Credential: optString(opts, "credential", ""),
+		User:       optString(opts, "user", ""),
+		Dir:        optString(opts, "dir", ""),
+		Overwrite:  optBool(opts, "overwrite", false),
+	}
+	switch kopts.Type {
+	case KeyTypeEd25519:
+		kopts.Bits = 256
+	case KeyTypeRSA:
+		if kopts.Bits < 2048 || kopts.Bits > 16384 {
+			return nil, errors.New("Invalid RSA key size: " + strconv.Itoa(kopts.Bits) + ", must be between 2048 and 16384")
+		}
+	default:
+		return nil, errors.New("Unsupported key type: " + kopts.Type)
+	}
+	if kopts.Credential != "" && !credentialNameRe.MatchString(kopts.Credential) {
+		return nil, errors.New("Invalid credential name: " + kopts.Credential)
+	}
+	if kopts.Comment == "" {
+		host, _ := os.Hostname()
+		kopts.Comment = "cmdt@" + host
+	}
+	return kopts, nil
+}
+
+// GenerateKeyPair 生成 Ed25519 或 RSA 密钥对，私钥使用 ssh-keygen 默认的 OpenSSH 格式
+func GenerateKeyPair(opts *KeygenOptions) (*SSHKeyPair, error) {
+	var private interface{}
+	switch opts.Type {
+	case KeyTypeEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		private = key
+	case KeyTypeRSA:
+		key, err := rsa.GenerateKey(rand.Reader, opts.Bits)
+		if err != nil {
+			return nil, err
+		}
+		private = key
+	default:
+		return nil, errors.New("Unsupported key type: " + opts.Type)
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		return nil, err
+	}
+	block, err := marshalOpenSSHPrivateKey(private, opts.Comment)
+	if err != nil {
+		return nil, err
+	}
+	pair := &SSHKeyPair{
+		Type:        opts.Type,
+		Bits:        opts.Bits,
+		Comment:     opts.Comment,
+		PublicKey:   authorizedKeyLine(signer.PublicKey(), opts.Comment),
+		Fingerprint: ssh.FingerprintSHA256(signer.PublicKey()),
+		PrivateKey:  string(pem.EncodeToMemory(block)),
+	}
+	if opts.Credential != "" {
+		if err := pair.store(opts); err != nil {
+			return nil, err
+		}
+	}
+	return pair, nil
+}
+
+// store 把密钥写入文件并在清单中登记凭据，凭据中的私钥路径相对于清单文件所在目录
+func (p *SSHKeyPair) store(opts *KeygenOptions) error {
+	file := inventoryWritePath()
+	dir := opts.Dir
+	if dir == "" {
+		dir = filepath.Join(filepath.Dir(file), "keys")
+	}
+	keyFile := filepath.Join(dir, opts.Credential)
+	if _, err := os.Stat(keyFile); err == nil && !opts.Overwrite {
+		return errors.New("Private key file already exists: " + keyFile)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errors.New("Failed to create key directory: " + err.Error())
+	}
+	cred := map[string]string{"private_key_file": keyFile}
+	if rel, err := filepath.Rel(filepath.Dir(file), keyFile); err == nil && !strings.HasPrefix(rel, "..") {
+		cred["private_key_file"] = rel
+	}
+	if opts.User != "" {
+		cred["user"] = opts.User
+	}
+	// 先检查凭据能否登记，避免留下没有登记的密钥文件
+	if err := saveCredential(file, opts.Credential, cred, opts.Overwrite, true); err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyFile, []byte(p.PrivateKey), 0600); err != nil {
+		return errors.New("Failed to write private key: " + err.Error())
+	}
+	// 覆盖已有文件时 WriteFile 不修改权限
+	os.Chmod(keyFile, 0600)
+	if err := os.WriteFile(keyFile+".pub", []byte(p.PublicKey+"\n"), 0644); err != nil {
+		return errors.New("Failed to write public key: " + err.Error())
+	}
+	if err := saveCredential(file, opts.Credential, cred, opts.Overwrite, false); err != nil {
+		return err
+	}
+	p.PrivateKey = ""
+	p.Credential = opts.Credential
+	p.PrivateKeyFile = keyFile
+	p.PublicKeyFile = keyFile + ".pub"
+	p.Inventory = file
+	return nil
+}
+
+// authorizedKeyLine 返回 authorized_keys 格式的公钥行
+func authorizedKeyLine(key ssh.PublicKey, comment string) string {
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+	if comment != "" {
+		line += " " + comment
+	}
+	return line
+}
+
+// marshalOpenSSHPrivateKey 按 openssh-key-v1 格式编码未加密的私钥，见 OpenSSH 的 PROTOCOL.key
+func marshalOpenSSHPrivateKey(key interface{}, comment string) (*pem.Block, error) {
+	var check [4]byte
+	if _, err := rand.Read(check[:]); err != nil {
+		return nil, err
+	}
+	checkInt := binary.BigEndian.Uint32(check[:])
+
+	var keyType string
+	var fields []byte
+	var pub ssh.PublicKey
+	var err error
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		keyType = ssh.KeyAlgoED25519
+		pubKey := k.Public().(ed25519.PublicKey)
+		fields = ssh.Marshal(struct {
+			Pub  []byte
+			Priv []byte
+		}{[]byte(pubKey), []byte(k)})
+		pub, err = ssh.NewPublicKey(pubKey)
+	case *rsa.PrivateKey:
+		keyType = ssh.KeyAlgoRSA
+		k.Precompute()
+		fields = ssh.Marshal(struct {
+			N    *big.Int
+			E    *big.Int
+			D    *big.Int
+			Iqmp *big.Int
+			P    *big.Int
+			Q    *big.Int
+		}{k.N, big.NewInt(int64(k.E)), k.D, k.Precomputed.Qinv, k.Primes[0], k.Primes[1]})
+		pub, err = ssh.NewPublicKey(&k.PublicKey)
+	default:
+		return nil, errors.New("Unsupported key type")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block := ssh.Marshal(struct {
+		Check1  uint32
+		Check2  uint32
+		Keytype string
+		Rest    []byte `ssh:"rest"`
+	}{checkInt, checkInt, keyType, fields})
+	block = append(block, ssh.Marshal(struct{ Comment string }{comment})...)
+	// 未加密时按 8 字节对齐，填充 1, 2, 3, ...
+	for i := byte(1); len(block)%8 != 0; i++ {
+		block = append(block, i)
+	}
+
+	data := ssh.Marshal(struct {
+		CipherName   string
+		KdfName      string
+		KdfOpts      string
+		NumKeys      uint32
+		PubKey       []byte
+		PrivKeyBlock []byte
+	}{"none", "none", "", 1, pub.Marshal(), block})
+	return &pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: append([]byte("openssh-key-v1\x00"), data...)}, nil
+}
+
+// DeployKeyOptions 部署公钥的选项
+type DeployKeyOptions struct {
+	Path       string        // authorized_keys 路径，~/ 表示远程用户的主目录
+	PrivateKey string        // 用于验证登录的私钥内容，为空时从 key 参数推断
+	Verify     bool          // 部署后用私钥重新登录验证
+	Timeout    time.Duration // 部署与验证各自的超时
+}
+
+// DeployKeyResult ssh_copy_id 的执行结果
+type DeployKeyResult struct {
+	Host        string `json:"host"`
+	Path        string `json:"path"`
+	Fingerprint string `json:"fingerprint"`
+	Added       bool   `json:"added"` // 公钥已经存在时为 false
+	Verified    bool   `json:"verified"`
+	VerifyError string `json:"verify_error,omitempty"`
+}
+
+// parseDeployKeyOptions 读取选项 {path, private_key, verify, timeout}，timeout 单位为秒，默认 30
+func parseDeployKeyOptions(opts map[string]interface{}) *DeployKeyOptions {
+	dopts := &DeployKeyOptions{
+		Path:       optString(opts, "path", "~/.ssh/authorized_keys"),
+		PrivateKey: optString(opts, "private_key", ""),
+		Verify:     optBool(opts, "verify", true),
+		Timeout:    time.Duration(optInt(opts, "timeout", 30)) * time.Second,
+	}
+	if dopts.Timeout <= 0 {
+		dopts.Timeout = 30 * time.Second
+	}
+	return dopts
+}
+
+// loadDeployKey 读取要部署的公钥，key 可以是清单中的凭据名称、公钥或私钥文件路径、公钥行或私钥内容；
+// 能取得私钥时一并返回，用于验证登录
+func loadDeployKey(key string) (pub ssh.PublicKey, comment string, privateKey string, err error) {
+	inv, err := loadInventory()
+	if err != nil {
+		return nil, "", "", err
+	}
+	content := key
+	if cred, ok := inv.Credentials[key]; ok {
+		_, privateKey, err = inv.resolveCredential(key)
+		if err != nil {
+			return nil, "", "", err
+		}
+		if privateKey == "" {
+			return nil, "", "", errors.New("Inventory credential has no private key: " + key)
+		}
+		content = privateKey
+		// 有对应的 .pub 文件时使用其中的公钥行，保留注释
+		if file := inv.privateKeyFile(cred); file != "" {
+			if data, err := os.ReadFile(file + ".pub"); err == nil {
+				content = string(data)
+			}
+		}
+	} else if data, err := os.ReadFile(expandHome(key)); err == nil {
+		content = string(data)
+		if strings.HasSuffix(key, ".pub") {
+			if data, err := os.ReadFile(expandHome(strings.TrimSuffix(key, ".pub"))); err == nil {
+				privateKey = string(data)
+			}
+		}
+	}
+	if signer, err := ssh.ParsePrivateKey([]byte(content)); err == nil {
+		return signer.PublicKey(), "", content, nil
+	}
+	pub, comment, _, _, err = ssh.ParseAuthorizedKey([]byte(content))
+	if err != nil {
+		return nil, "", "", errors.New("Invalid public key: " + err.Error())
+	}
+	if privateKey != "" {
+		if signer, err := ssh.ParsePrivateKey([]byte(privateKey)); err != nil || !bytes.Equal(signer.PublicKey().Marshal(), pub.Marshal()) {
+			privateKey = ""
+		}
+	}
+	return pub, comment, privateKey, nil
+}
+
+// expandHome 把本地路径开头的 ~/ 换成当前用户的主目录
+func expandHome(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[2:])
+		}
+	}
+	return path
+}
+
+// SSHDeployKey 用密码登录远程主机，把公钥追加到 authorized_keys 并修正目录与文件权限，
+// 公钥已存在时不重复添加；能取得私钥时部署后用私钥重新登录验证
+func SSHDeployKey(target *SSHTarget, key string, opts *DeployKeyOptions) (*DeployKeyResult, error) {
+	pub, comment, privateKey, err := loadDeployKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if opts.PrivateKey != "" {
+		privateKey = opts.PrivateKey
+	}
+	line := authorizedKeyLine(pub, comment)
+	// 按未注释行中相邻的类型与 base64 字段判断是否已经存在，前面可以有选项，不比较注释
+	fields := strings.Fields(line)
+	file := remotePath(opts.Path)
+	script := strings.Join([]string{
+		"set -e",
+		"umask 077",
+		"f=" + file,
+		`d="$(dirname "$f")"`,
+		// 只修改新建的目录或用户的 .ssh 目录，自定义位置可能是共用的系统目录
+		`if [ ! -d "$d" ]; then mkdir -p "$d"; chmod 700 "$d"; elif [ "$(basename "$d")" = .ssh ]; then chmod 700 "$d"; fi`,
+		`touch "$f"`,
+		`chmod 600 "$f"`,
+		"if awk -v t=" + shellQuote(fields[0]) + " -v k=" + shellQuote(fields[1]) + ` '!/^[ \t]*#/ { for (i = 1; i < NF; i++) if ($i == t && $(i + 1) == k) found = 1 } END { exit !found }' "$f"; then echo present; exit 0; fi`,
+		`if [ -s "$f" ] && [ -n "$(tail -c 1 "$f")" ]; then echo >> "$f"; fi`,
+		"printf '%s\\n' " + shellQuote(line) + ` >> "$f"`,
+		`if command -v restorecon >/dev/null 2>&1; then restorecon -R "$d" >/dev/null 2>&1 || true; fi`,
+		"echo added",
+	}, "\n")
+	stdout, stderr, _, err := target.Run(script, opts.Timeout)
+	if err != nil {
+		if msg := strings.TrimSpace(stderr); msg != "" {
+			return nil, errors.New("Failed to deploy public key: " + msg)
+		}
+		return nil, errors.New("Failed to deploy public key: " + err.Error())
+	}
+	result := &DeployKeyResult{
+		Host:        target.Address(),
+		Path:        opts.Path,
+		Fingerprint: ssh.FingerprintSHA256(pub),
+		Added:       strings.TrimSpace(stdout) == "added",
+	}
+	if !opts.Verify {
+		return result, nil
+	}
+	if privateKey == "" {
+		result.VerifyError = "private key not available"
+		return result, nil
+	}
+	verify := &SSHTarget{Host: target.Host, Port: target.Port, User: target.User, PrivateKey: privateKey, Proxy: target.Proxy, Algorithms: target.Algorithms, Timeout: opts.Timeout}
+	conn, err := verify.Dial()
+	if err != nil {
+		result.VerifyError = err.Error()
+		return result, nil
+	}
+	conn.Close()
+	result.Verified = true
+	return result, nil
+}
+
+// remotePath 返回远程 shell 中使用的路径，~/ 开头时相对于远程用户的主目录
+func remotePath(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		return `"$HOME"/` + shellQuote(path[2:])
+	}
+	return shellQuote(path)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// OpenLocalShell 启动长期运行的本地 shell 进程，只支持 POSIX shell
+func OpenLocalShell(opts *ShellOptions) (*ShellSession, error) {
+	shell := opts.Shell
+	if shell == "" {
+		shell = "sh"
+	}
+	switch strings.TrimSuffix(filepath.Base(shell), ".exe") {
+	case "sh", "bash", "dash", "ash", "ksh", "zsh":
+	default:
+		return nil, errors.New("Unsupported shell: " + shell)
+	}
+
+	cmd := exec.Command(shell)
+	cmd.Dir = opts.Cwd
+	cmd.Env = os.Environ()
+	for key, val := range opts.Env {
+		cmd.Env = append(cmd.Env, key+"="+val)
+	}
+	setProcessGroup(cmd)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, errors.New("Failed to start shell: " + err.Error())
+	}
+
+	outStream, errStream := newShellStream(stdout), newShellStream(stderr)
+	s := &ShellSession{
+		Type:   "local",
+		Shell:  shell,
+		stdin:  stdin,
+		stdout: outStream,
+		stderr: errStream,
+		closer: func() {
+			stdin.Close()
+			killProcessGroup(cmd)
+			// Wait 会关闭管道，等两个读取协程都读到 EOF 后再回收进程；
+			// 脱离进程组的后台进程可能一直占用管道，超时后不再等待
+			deadline := time.After(5 * time.Second)
+			if outStream.waitEOF(deadline) == nil {
+				errStream.waitEOF(deadline)
+			}
+			cmd.Wait()
+		},
+	}
+	return registerShell(s, opts)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MultiOptions 多主机并行执行选项
+type MultiOptions struct {
+	Concurrency   int           // 同时执行的主机数
+	Timeout       time.Duration // 每台主机的超时时间
+	StopOnFailure bool          // 任意主机失败后不再启动新的主机
+}
+
+// parseMultiOptions 从调用选项中读取并行执行选项，timeout 单位为秒
+func parseMultiOptions(opts map[string]interface{}) *MultiOptions {
+	mopts := &MultiOptions{
+		Concurrency:   optInt(opts, "concurrency", 10),
+		Timeout:       time.Duration(optInt(opts, "timeout", 10)) * time.Second,
+		StopOnFailure: optBool(opts, "stop_on_failure", false),
+	}
+	if mopts.Concurrency <= 0 {
+		mopts.Concurrency = 1
+	}
+	if mopts.Timeout <= 0 {
+		mopts.Timeout = 10 * time.Second
+	}
+	return mopts
+}
+
+// HostResult 单台主机的执行结果
+type HostResult struct {
+	Host     string      `json:"host"`
+	Ok       bool        `json:"ok"`
+	Skipped  bool        `json:"skipped,omitempty"`
+	ExitCode int         `json:"exit_code"`
+	Stdout   string      `json:"stdout"`
+	Stderr   string      `json:"stderr"`
+	Error    string      `json:"error,omitempty"`
+	Duration float64     `json:"duration_ms"`
+	Data     interface{} `json:"data,omitempty"`
+}
+
+// MultiSummary 多主机执行汇总
+type MultiSummary struct {
+	Total     int      `json:"total"`
+	Succeeded int      `json:"succeeded"`
+	Failed    int      `json:"failed"`
+	Skipped   int      `json:"skipped"`
+	FailedOn  []string `json:"failed_hosts"`
+}
+
+// MultiResult 多主机执行结果，结果顺序与主机列表一致
+type MultiResult struct {
+	Results []*HostResult `json:"results"`
+	Summary MultiSummary  `json:"summary"`
+}
+
+// parseHostList 解析主机列表，支持数组与逗号分隔的字符串，主机可写成 host:port
+func parseHostList(val interface{}) []string {
+	hosts := optStrings(map[string]interface{}{"hosts": val}, "hosts")
+	list := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		for _, item := range strings.Fields(host) {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// splitHostPort 拆分 host:port，没有端口时使用默认端口
+func splitHostPort(host string, defPort string) (string, string) {
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		return h, p
+	}
+	return host, defPort
+}
+
+// runOnHosts 按并发上限在多台主机上执行 fn，每台主机受 Timeout 限制
+func runOnHosts(targets []*SSHTarget, opts *MultiOptions, fn func(target *SSHTarget) *HostResult) *MultiResult {
+	result := &MultiResult{Results: make([]*HostResult, len(targets))}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sem := make(chan struct{}, opts.Concurrency)
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			result.Results[i] = &HostResult{Host: target.Host, Skipped: true, ExitCode: -1, Error: "skipped after failure"}
+			continue
+		}
+		wg.Add(1)
+		go func(i int, target *SSHTarget) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			name := target.Host
+			start := time.Now()
+			hostResult := fn(target)
+			hostResult.Host = name
+			hostResult.Duration = float64(time.Since(start).Microseconds()) / 1000
+			result.Results[i] = hostResult
+			if !hostResult.Ok && opts.StopOnFailure {
+				cancel()
+			}
+		}(i, target)
+	}
+	wg.Wait()
+
+	result.Summary.Total = len(targets)
+	result.Summary.FailedOn = []string{}
+	for _, item := range result.Results {
+		switch {
+		case item.Skipped:
+			result.Summary.Skipped++
+		case item.Ok:
+			result.Summary.Succeeded++
+		default:
+			result.Summary.Failed++
+			result.Summary.FailedOn = append(result.Summary.FailedOn, item.Host)
+		}
+	}
+	return result
+}
+
+// SSHRunMulti 在多台主机上并行执行同一条命令
+func SSHRunMulti(targets []*SSHTarget, cmd string, opts *MultiOptions) *MultiResult {
+	return runOnHosts(targets, opts, func(target *SSHTarget) *HostResult {
+		target.Timeout = opts.Timeout
+		stdout, stderr, exitCode, err := target.Run(cmd, opts.Timeout)
+		hostResult := &HostResult{Ok: err == nil, ExitCode: exitCode, Stdout: stdout, Stderr: stderr}
+		if err != nil {
+			hostResult.Error = err.Error()
+		}
+		return hostResult
+	})
+}
+
+// multiTargets 根据主机列表与公共的连接参数生成目标列表
+func multiTargets(hosts []string, port, user, password, privateKey string) []*SSHTarget {
+	targets := make([]*SSHTarget, 0, len(hosts))
+	for _, host := range hosts {
+		h, p := splitHostPort(host, port)
+		targets = append(targets, &SSHTarget{Host: h, Port: p, User: user, Password: password, PrivateKey: privateKey})
+	}
+	return targets
+}
+
+// String 返回汇总的简短描述
+func (s MultiSummary) String() string {
+	return fmt.Sprintf("%d hosts, %d succeeded, %d failed, %d skipped", s.Total, s.Succeeded, s.Failed, s.Skipped)
+}
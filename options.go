@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseOptions 将调用参数转换成选项表，支持 map 对象或 JSON 字符串
+func parseOptions(val interface{}) map[string]interface{} {
+	switch data := val.(type) {
+	case map[string]interface{}:
+		return data
+	case string:
+		opts := map[string]interface{}{}
+		if strings.HasPrefix(strings.TrimSpace(data), "{") {
+			if err := json.Unmarshal([]byte(data), &opts); err == nil {
+				return opts
+			}
+		}
+	}
+	return map[string]interface{}{}
+}
+
+// optionsAt 读取第 index 个原始参数作为选项表，不存在时返回空表
+func (args *CommandArgs) optionsAt(index int) map[string]interface{} {
+	if index < 0 || index >= len(args.rawArgs) {
+		return map[string]interface{}{}
+	}
+	return parseOptions(args.rawArgs[index])
+}
+
+// trailingOptions 最后一个原始参数是选项表时取出并从参数中移除，用于参数个数不固定的方法，
+// min 为选项表之前至少需要的参数个数
+func (args *CommandArgs) trailingOptions(min int) map[string]interface{} {
+	n := len(args.rawArgs)
+	if n <= min || n != len(args.cmdArgs) {
+		return map[string]interface{}{}
+	}
+	opts, ok := args.rawArgs[n-1].(map[string]interface{})
+	if !ok {
+		return map[string]interface{}{}
+	}
+	args.rawArgs = args.rawArgs[:n-1]
+	args.cmdArgs = args.cmdArgs[:n-1]
+	return opts
+}
+
+// optString 读取字符串选项
+func optString(opts map[string]interface{}, key string, def string) string {
+	val, ok := opts[key]
+	if !ok || val == nil {
+		return def
+	}
+	switch data := val.(type) {
+	case string:
+		return data
+	case float64:
+		return strconv.FormatFloat(data, 'f', -1, 64)
+	default:
+		return fmt.Sprintf("%v", data)
+	}
+}
+
+// optBool 读取布尔选项，兼容 "true"/"1" 等字符串写法
+func optBool(opts map[string]interface{}, key string, def bool) bool {
+	val, ok := opts[key]
+	if !ok || val == nil {
+		return def
+	}
+	switch data := val.(type) {
+	case bool:
+		return data
+	case float64:
+		return data != 0
+	case int:
+		return data != 0
+	case string:
+		b, err := strconv.ParseBool(data)
+		if err != nil {
+			return def
+		}
+		return b
+	}
+	return def
+}
+
+// optInt 读取整数选项
+func optInt(opts map[string]interface{}, key string, def int) int {
+	val, ok := opts[key]
+	if !ok || val == nil {
+		return def
+	}
+	switch data := val.(type) {
+	case float64:
+		return int(data)
+	case int:
+		return data
+	case int64:
+		return int(data)
+	case string:
+		n, err := strconv.Atoi(strings.TrimSpace(data))
+		if err != nil {
+			return def
+		}
+		return n
+	}
+	return def
+}
+
+// optStrings 读取字符串列表选项，兼容数组和逗号分隔的字符串
+func optStrings(opts map[string]interface{}, key string) []string {
+	val, ok := opts[key]
+	if !ok || val == nil {
+		return nil
+	}
+	list := make([]string, 0)
+	switch data := val.(type) {
+	case []interface{}:
+		for _, item := range data {
+			list = append(list, fmt.Sprintf("%v", item))
+		}
+	case []string:
+		list = append(list, data...)
+	case string:
+		for _, item := range strings.Split(data, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+// formatJSON 将结果序列化为 JSON 字符串，作为 output 返回
+func formatJSON(v interface{}) (string, error) {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes), nil
+}
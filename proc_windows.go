@@ -0,0 +1,15 @@
+//go:build windows
+
+package main
+
+import "os/exec"
+
+// setProcessGroup Windows 下不需要设置
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup 结束子进程
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process != nil {
+		cmd.Process.Kill()
+	}
+}
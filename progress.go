@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// progressRetention 传输结束后进度信息的保留时间
+const progressRetention = 30 * time.Minute
+
+// TransferProgress 单个传输任务的进度
+type TransferProgress struct {
+	ID           string    `json:"id"`
+	Status       string    `json:"status"`
+	TotalBytes   int64     `json:"total_bytes"`
+	Bytes        int64     `json:"bytes"`
+	ResumedBytes int64     `json:"resumed_bytes"` // 续传跳过的字节数，计入 bytes 与百分比，不计入速率
+	TotalFiles   int       `json:"total_files"`
+	Files        int       `json:"files"`
+	CurrentFile  string    `json:"current_file"`
+	Speed        float64   `json:"bytes_per_second"`
+	Percent      float64   `json:"percent"`
+	Error        string    `json:"error,omitempty"`
+	StartedAt    time.Time `json:"started_at"`
+	FinishedAt   time.Time `json:"finished_at"`
+
+	mu sync.Mutex
+}
+
+// progressRegistry 按任务ID保存传输进度，供 transfer_progress 查询
+type progressRegistry struct {
+	mu    sync.Mutex
+	items map[string]*TransferProgress
+}
+
+var transferProgress = &progressRegistry{items: map[string]*TransferProgress{}}
+
+// newTransferID 生成随机的传输任务ID
+func newTransferID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// start 登记一个新的传输任务，id 为空时自动生成
+func (r *progressRegistry) start(id string) *TransferProgress {
+	if id == "" {
+		id = newTransferID()
+	}
+	p := &TransferProgress{ID: id, Status: "running", StartedAt: time.Now()}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, item := range r.items {
+		item.mu.Lock()
+		expired := item.Status != "running" && time.Since(item.FinishedAt) > progressRetention
+		item.mu.Unlock()
+		if expired {
+			delete(r.items, key)
+		}
+	}
+	r.items[id] = p
+	return p
+}
+
+// get 查询任务进度快照
+func (r *progressRegistry) get(id string) (*TransferProgress, bool) {
+	r.mu.Lock()
+	p, ok := r.items[id]
+	r.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	return p.snapshot(), true
+}
+
+// list 返回全部任务进度快照，按开始时间排序
+func (r *progressRegistry) list() []*TransferProgress {
+	r.mu.Lock()
+	items := make([]*TransferProgress, 0, len(r.items))
+	for _, p := range r.items {
+		items = append(items, p.snapshot())
+	}
+	r.mu.Unlock()
+	sort.Slice(items, func(i, j int) bool { return items[i].StartedAt.Before(items[j].StartedAt) })
+	return items
+}
+
+// snapshot 复制当前进度并计算速率与百分比
+func (p *TransferProgress) snapshot() *TransferProgress {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := &TransferProgress{
+		ID:           p.ID,
+		Status:       p.Status,
+		TotalBytes:   p.TotalBytes,
+		Bytes:        p.Bytes,
+		ResumedBytes: p.ResumedBytes,
+		TotalFiles:   p.TotalFiles,
+		Files:        p.Files,
+		CurrentFile:  p.CurrentFile,
+		Error:        p.Error,
+		StartedAt:    p.StartedAt,
+		FinishedAt:   p.FinishedAt,
+	}
+	end := time.Now()
+	if !p.FinishedAt.IsZero() {
+		end = p.FinishedAt
+	}
+	if elapsed := end.Sub(p.StartedAt).Seconds(); elapsed > 0 {
+		s.Speed = float64(p.Bytes-p.ResumedBytes) / elapsed
+	}
+	if p.TotalBytes > 0 {
+		s.Percent = float64(p.Bytes) * 100 / float64(p.TotalBytes)
+	} else if p.Status == "done" {
+		s.Percent = 100
+	}
+	return s
+}
+
+// addTotal 增加任务的总字节数与文件数
+func (p *TransferProgress) addTotal(bytes int64, files int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.TotalBytes += bytes
+	p.TotalFiles += files
+	p.mu.Unlock()
+}
+
+// beginFile 标记当前正在传输的文件，offset 为续传跳过的字节数
+func (p *TransferProgress) beginFile(name string, offset int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.CurrentFile = name
+	p.Bytes += offset
+	p.ResumedBytes += offset
+	p.mu.Unlock()
+}
+
+// endFile 标记一个文件传输完成
+func (p *TransferProgress) endFile() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.Files++
+	p.mu.Unlock()
+}
+
+func (p *TransferProgress) addBytes(n int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.Bytes += n
+	p.mu.Unlock()
+}
+
+// finish 结束任务，err 不为空时标记为失败
+func (p *TransferProgress) finish(err error) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.FinishedAt = time.Now()
+	p.CurrentFile = ""
+	if err != nil {
+		p.Status = "failed"
+		p.Error = err.Error()
+	} else {
+		p.Status = "done"
+	}
+}
+
+// progressWriter 在写入时累计传输字节数
+type progressWriter struct {
+	w        io.Writer
+	progress *TransferProgress
+}
+
+func (pw *progressWriter) Write(b []byte) (int, error) {
+	n, err := pw.w.Write(b)
+	pw.progress.addBytes(int64(n))
+	return n, err
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// ProxyDirect 显式指定不使用代理，忽略环境变量
+const ProxyDirect = "direct"
+
+// proxyEnvs 未指定代理时依次读取的环境变量
+var proxyEnvs = []string{"CMDT_SSH_PROXY", "ALL_PROXY", "all_proxy", "HTTPS_PROXY", "https_proxy"}
+
+// sshProxyURL 返回连接 host 时使用的代理，explicit 为空时读取环境变量，
+// 主机在 NO_PROXY 中或指定 direct/none 时返回 nil
+func sshProxyURL(explicit string, host string) (*url.URL, error) {
+	raw := strings.TrimSpace(explicit)
+	if raw == "" {
+		for _, env := range proxyEnvs {
+			if raw = strings.TrimSpace(os.Getenv(env)); raw != "" {
+				break
+			}
+		}
+		if raw == "" || noProxy(host) {
+			return nil, nil
+		}
+	}
+	if raw == ProxyDirect || raw == "none" {
+		return nil, nil
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, errors.New("Invalid proxy: " + err.Error())
+	}
+	switch u.Scheme {
+	case "socks5", "socks5h", "http", "https":
+	default:
+		return nil, errors.New("Unsupported proxy scheme: " + u.Scheme)
+	}
+	return u, nil
+}
+
+// noProxy 判断主机是否匹配 NO_PROXY，支持 *、域名后缀、IP 与 CIDR
+func noProxy(host string) bool {
+	value := os.Getenv("NO_PROXY")
+	if value == "" {
+		value = os.Getenv("no_proxy")
+	}
+	ip := net.ParseIP(host)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if item == "*" {
+			return true
+		}
+		if _, cidr, err := net.ParseCIDR(item); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if h, _, err := net.SplitHostPort(item); err == nil {
+			item = h
+		}
+		if host == item || strings.HasSuffix(host, "."+strings.TrimPrefix(item, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyAddr 返回代理服务地址，没有端口时按协议补全默认端口
+func proxyAddr(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		return net.JoinHostPort(u.Hostname(), "1080")
+	case "https":
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
+
+// dialThroughProxy 经 SOCKS5 或 HTTP CONNECT 代理建立到 addr 的 TCP 连接
+func dialThroughProxy(u *url.URL, addr string, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	if u.Scheme == "socks5" || u.Scheme == "socks5h" {
+		var auth *proxy.Auth
+		if u.User != nil {
+			password, _ := u.User.Password()
+			auth = &proxy.Auth{User: u.User.Username(), Password: password}
+		}
+		socks, err := proxy.SOCKS5("tcp", proxyAddr(u), auth, dialer)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := socks.Dial("tcp", addr)
+		if err != nil {
+			return nil, errors.New("proxy " + u.Redacted() + ": " + err.Error())
+		}
+		return conn, nil
+	}
+
+	conn, err := dialer.Dial("tcp", proxyAddr(u))
+	if err != nil {
+		return nil, errors.New("proxy " + u.Redacted() + ": " + err.Error())
+	}
+	if u.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, errors.New("proxy " + u.Redacted() + ": " + err.Error())
+		}
+		conn = tlsConn
+	}
+	if err := httpConnect(conn, u, addr, timeout); err != nil {
+		conn.Close()
+		return nil, errors.New("proxy " + u.Redacted() + ": " + err.Error())
+	}
+	return conn, nil
+}
+
+// httpConnect 发送 CONNECT 请求并等待代理返回 2xx
+func httpConnect(conn net.Conn, u *url.URL, addr string, timeout time.Duration) error {
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+		defer conn.SetDeadline(time.Time{})
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		token := base64.StdEncoding.EncodeToString([]byte(u.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+token)
+	}
+	if err := req.Write(conn); err != nil {
+		return err
+	}
+	// 代理在应答之前不会发送隧道数据，逐字节读取避免缓冲吞掉 SSH 握手数据
+	resp, err := http.ReadResponse(bufio.NewReaderSize(&byteReader{conn}, 1), req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("CONNECT " + addr + ": " + resp.Status)
+	}
+	return nil
+}
+
+// byteReader 每次只读一个字节
+type byteReader struct {
+	conn net.Conn
+}
+
+func (r *byteReader) Read(p []byte) (int, error) {
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return r.conn.Read(p)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startHTTPProxy 启动只支持 CONNECT 的 HTTP 代理，要求 Basic 认证 user:pass，返回地址与已建立的隧道数
+func startHTTPProxy(t *testing.T) (string, *int64) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	var count int64
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				req, err := http.ReadRequest(reader)
+				if err != nil || req.Method != http.MethodConnect {
+					return
+				}
+				if user, pass, ok := (&http.Request{Header: http.Header{"Authorization": req.Header["Proxy-Authorization"]}}).BasicAuth(); !ok || user != "user" || pass != "pass" {
+					conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+					return
+				}
+				target, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					conn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+					return
+				}
+				defer target.Close()
+				atomic.AddInt64(&count, 1)
+				conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+				go io.Copy(target, reader)
+				io.Copy(conn, target)
+			}()
+		}
+	}()
+	return listener.Addr().String(), &count
+}
+
+func TestSSHThroughHTTPProxy(t *testing.T) {
+	server := startTestSSHServer(t)
+	proxyAddr, count := startHTTPProxy(t)
+	proxyURL := "http://user:pass@" + proxyAddr
+
+	out := execOutput(t, "remote", server.Host, server.Port, testSSHUser, testSSHPassword, "echo", "hello", map[string]interface{}{"proxy": proxyURL})
+	if strings.TrimSpace(out) != "hello" || atomic.LoadInt64(count) != 1 {
+		t.Errorf("unexpected output %q, proxy connections %d", out, atomic.LoadInt64(count))
+	}
+
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "proxy")
+	execOutput(t, "remote_copy_file", server.Host, server.Port, testSSHUser, testSSHPassword, filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt"), map[string]interface{}{"proxy": proxyURL})
+	if readTestFile(t, filepath.Join(dir, "b.txt")) != "proxy" || atomic.LoadInt64(count) != 2 {
+		t.Errorf("copy did not go through proxy, connections %d", atomic.LoadInt64(count))
+	}
+
+	// 环境变量中的代理，NO_PROXY 命中时直连
+	t.Setenv("CMDT_SSH_PROXY", proxyURL)
+	execOutput(t, "remote", server.Host, server.Port, testSSHUser, testSSHPassword, "true")
+	t.Setenv("NO_PROXY", "127.0.0.0/8")
+	execOutput(t, "remote", server.Host, server.Port, testSSHUser, testSSHPassword, "true")
+	if atomic.LoadInt64(count) != 3 {
+		t.Errorf("unexpected proxy connections %d", atomic.LoadInt64(count))
+	}
+
+	target := &SSHTarget{Host: server.Host, Port: server.Port, User: testSSHUser, Password: testSSHPassword, Proxy: "http://user:wrong@" + proxyAddr}
+	if _, err := target.Dial(); err == nil || !strings.Contains(err.Error(), "407") || strings.Contains(err.Error(), "wrong") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestSSHThroughSOCKS5Proxy(t *testing.T) {
+	bastion := startTestSSHServer(t)
+	server := startTestSSHServer(t)
+	tunnel := openTestTunnel(t, bastion, TunnelDynamic, "")
+
+	target := &SSHTarget{Host: server.Host, Port: server.Port, User: testSSHUser, Password: testSSHPassword, Proxy: "socks5://" + tunnel.Bind}
+	stdout, _, _, err := target.Run("echo socks", 5*time.Second)
+	if err != nil || strings.TrimSpace(stdout) != "socks" {
+		t.Fatalf("unexpected result %q %v", stdout, err)
+	}
+	item, _ := tunnels.get(tunnel.ID)
+	if item.snapshot().TotalConnections != 1 {
+		t.Error("connection did not go through the socks proxy")
+	}
+}
+
+func TestNoProxy(t *testing.T) {
+	t.Setenv("NO_PROXY", "example.com, 10.0.0.0/8,.internal")
+	cases := map[string]bool{"example.com": true, "a.example.com": true, "10.1.2.3": true, "db.internal": true, "11.0.0.1": false, "example.org": false}
+	for host, want := range cases {
+		if got := noProxy(host); got != want {
+			t.Errorf("%s: got %v, want %v", host, got, want)
+		}
+	}
+}
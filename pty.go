@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// PTY 输出的捕获方式
+const (
+	PTYCaptureScreen = "screen" // 程序结束时屏幕上的画面
+	PTYCaptureStream = "stream" // 完整的输出流
+)
+
+// PTYOptions 在伪终端中执行本地命令的选项
+type PTYOptions struct {
+	Rows     int           // 终端行数
+	Cols     int           // 终端列数
+	Term     string        // TERM 环境变量
+	Capture  string        // screen 或 stream
+	Preserve bool          // 保留 ANSI 控制序列，screen 模式下保留颜色等显示属性
+	Input    string        // 启动后写入终端的输入，例如让 top 退出的 q
+	Timeout  time.Duration // 超时后结束命令并返回当时的输出
+}
+
+// parsePTYOptions 读取 pty 选项，pty 为 false 时返回 nil；timeout 单位为秒，默认 10
+func parsePTYOptions(opts map[string]interface{}) *PTYOptions {
+	if !optBool(opts, "pty", false) {
+		return nil
+	}
+	popts := &PTYOptions{
+		Rows:     optInt(opts, "rows", 24),
+		Cols:     optInt(opts, "cols", 80),
+		Term:     optString(opts, "term", "xterm-256color"),
+		Capture:  optString(opts, "capture", PTYCaptureScreen),
+		Preserve: optString(opts, "ansi", "strip") == "preserve",
+		Input:    optString(opts, "input", ""),
+		Timeout:  time.Duration(optInt(opts, "timeout", 10)) * time.Second,
+	}
+	if popts.Rows <= 0 {
+		popts.Rows = 24
+	}
+	if popts.Cols <= 0 {
+		popts.Cols = 80
+	}
+	if popts.Timeout <= 0 {
+		popts.Timeout = 10 * time.Second
+	}
+	return popts
+}
+
+// runPTY 在伪终端中执行命令，超时后结束命令所在的会话并返回已有输出，
+// 命令以非零状态退出时同时返回输出与错误
+func runPTY(argv []string, opts *PTYOptions) (string, error) {
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Env = append(os.Environ(), "TERM="+opts.Term, "LINES="+strconv.Itoa(opts.Rows), "COLUMNS="+strconv.Itoa(opts.Cols))
+	master, err := startPTY(cmd, opts.Rows, opts.Cols)
+	if err != nil {
+		return "", err
+	}
+	defer master.Close()
+	if opts.Input != "" {
+		master.Write([]byte(opts.Input))
+	}
+
+	var out bytes.Buffer
+	readDone := make(chan struct{})
+	go func() {
+		// 终端的另一端全部关闭后读取返回 EIO
+		io.Copy(&out, master)
+		close(readDone)
+	}()
+	waitDone := make(chan error, 1)
+	go func() {
+		waitDone <- cmd.Wait()
+	}()
+
+	select {
+	case err = <-waitDone:
+	case <-time.After(opts.Timeout):
+		killProcessGroup(cmd)
+		<-waitDone
+		err = nil
+	}
+	// 后台进程仍占用终端时不再等待
+	select {
+	case <-readDone:
+	case <-time.After(500 * time.Millisecond):
+		master.Close()
+		<-readDone
+	}
+
+	if opts.Capture == PTYCaptureStream {
+		if opts.Preserve {
+			return out.String(), err
+		}
+		return stripANSI(out.String()), err
+	}
+	screen := newTermScreen(opts.Rows, opts.Cols, opts.Preserve)
+	screen.Write(out.Bytes())
+	return screen.Screen(), err
+}
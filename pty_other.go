@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// startPTY 目前只支持 Linux
+func startPTY(cmd *exec.Cmd, rows, cols int) (*os.File, error) {
+	return nil, errors.New("PTY is not supported on this platform")
+}
@@ -0,0 +1,49 @@
+//go:build linux
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLocalPTY(t *testing.T) {
+	output := execOutput(t, "sh", "[ -t 1 ] && echo tty; stty size; echo $TERM", map[string]interface{}{"pty": true, "rows": 30, "cols": 100, "term": "vt100"})
+	if output != "tty\n30 100\nvt100" {
+		t.Errorf("unexpected output %q", output)
+	}
+
+	// 超时后返回当时的画面
+	output = execOutput(t, "sh", "printf '\\033[?1049h\\033[H\\033[31mframe\\033[0m'; sleep 30", map[string]interface{}{"pty": true, "timeout": 1, "ansi": "preserve"})
+	if output != "\x1b[0m\x1b[31mframe\x1b[0m" {
+		t.Errorf("unexpected output %q", output)
+	}
+
+	output = execOutput(t, "sh", "read line; printf '\\033[1mbold\\033[0m %s\\n' \"$line\"", map[string]interface{}{"pty": true, "capture": "stream", "input": "typed\n"})
+	if !strings.HasSuffix(output, "bold typed\n") || strings.Contains(output, "\x1b") {
+		t.Errorf("unexpected output %q", output)
+	}
+}
+
+func TestTermScreen(t *testing.T) {
+	cases := []struct {
+		input    string
+		preserve bool
+		want     string
+	}{
+		{"hello\x1b[2J\x1b[Hworld\r\nab\x1b[31mred\x1b[0m", false, "world\nabred"},
+		{"ab\x1b[31mred\x1b[0m", true, "ab\x1b[0m\x1b[31mred\x1b[0m"},
+		{"main\x1b[?1049h\x1b[Halt view\x1b[?1049l", false, "alt view"},
+		{"main\x1b[?1049h\x1b[Halt view\x1b[?1049l\r\ndone", false, "main\ndone"},
+		{"1\r\n2\r\n3\r\n4", false, "2\n3\n4"},
+		{"abcdef\x1b[3D\x1b[K\x1b[1;2HX", false, "aXc"},
+		{"\x1b]0;title\x07a\tb", false, "a       b"},
+	}
+	for _, c := range cases {
+		screen := newTermScreen(3, 10, c.preserve)
+		screen.Write([]byte(c.input))
+		if got := screen.Screen(); got != c.want {
+			t.Errorf("%q: got %q, want %q", c.input, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// relayEndpoint 中转传输的一端：SSH 连接与 SFTP 会话
+type relayEndpoint struct {
+	conn   *ssh.Client
+	client *sftp.Client
+}
+
+func openRelayEndpoint(target *SSHTarget) (*relayEndpoint, error) {
+	conn, err := target.Dial()
+	if err != nil {
+		return nil, errors.New(target.Host + ": " + err.Error())
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, errors.New(target.Host + ": " + err.Error())
+	}
+	return &relayEndpoint{conn: conn, client: client}, nil
+}
+
+func (e *relayEndpoint) close() {
+	e.client.Close()
+	e.conn.Close()
+}
+
+// SSHRemoteTransfer 在两台远程主机之间直接中转文件或目录，数据只经过内存缓冲，不落本地磁盘
+func SSHRemoteTransfer(source, target *SSHTarget, srcPath, dstPath string, opts *TransferOptions) (*TransferResult, error) {
+	source.Proxy = firstNonEmpty(source.Proxy, opts.Proxy)
+	target.Proxy = firstNonEmpty(target.Proxy, opts.Proxy)
+	source.Algorithms = source.Algorithms.merge(opts.Algorithms)
+	target.Algorithms = target.Algorithms.merge(opts.Algorithms)
+	src, err := openRelayEndpoint(source)
+	if err != nil {
+		return nil, err
+	}
+	defer src.close()
+	dst, err := openRelayEndpoint(target)
+	if err != nil {
+		return nil, err
+	}
+	defer dst.close()
+
+	info, err := src.client.Stat(srcPath)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &TransferResult{Protocol: ProtocolSFTP, Files: []*TransferFile{}, Skipped: []string{}}
+	if !info.IsDir() {
+		opts.Progress.addTotal(info.Size(), 1)
+		file, err := relayFile(src.client, dst.client, srcPath, dstPath, opts)
+		if file != nil {
+			result.add(file)
+		}
+		if err != nil {
+			return result, err
+		}
+	} else if err := relayFolder(src.client, dst.client, srcPath, dstPath, opts, result); err != nil {
+		return result, err
+	}
+
+	if opts.Verify {
+		verifyRelayResult(newRemoteHasher(src.conn, src.client, opts.VerifyMethod), newRemoteHasher(dst.conn, dst.client, opts.VerifyMethod), result)
+	}
+	return result, nil
+}
+
+// relayFolder 遍历源目录，按 include/exclude 规则逐个中转文件
+func relayFolder(src, dst *sftp.Client, srcFolder, dstFolder string, opts *TransferOptions, result *TransferResult) error {
+	include := newPathMatcher(opts.Include, "")
+	exclude := newPathMatcher(opts.Exclude, "")
+
+	dirs := []string{dstFolder}
+	files := make([]string, 0)
+	walker := src.Walk(srcFolder)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return errors.New("Failed to walk source folder: " + err.Error())
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), srcFolder), "/")
+		if rel == "" {
+			continue
+		}
+		isDir := walker.Stat().IsDir()
+		if hit, _ := exclude.match(rel, isDir); hit {
+			if isDir {
+				result.Skipped = append(result.Skipped, rel+"/")
+				walker.SkipDir()
+			} else {
+				result.Skipped = append(result.Skipped, rel)
+			}
+			continue
+		}
+		if isDir {
+			if len(opts.Include) == 0 {
+				dirs = append(dirs, path.Join(dstFolder, rel))
+			}
+			continue
+		}
+		if len(opts.Include) > 0 {
+			if hit, _ := include.match(rel, false); !hit {
+				result.Skipped = append(result.Skipped, rel)
+				continue
+			}
+		}
+		files = append(files, rel)
+		opts.Progress.addTotal(walker.Stat().Size(), 1)
+	}
+
+	for _, dir := range dirs {
+		if err := dst.MkdirAll(dir); err != nil {
+			return errors.New("Failed to create target folder: " + dir + " " + err.Error())
+		}
+	}
+	for _, rel := range files {
+		srcPath := path.Join(srcFolder, rel)
+		dstPath := path.Join(dstFolder, rel)
+		if len(opts.Include) > 0 {
+			if err := dst.MkdirAll(path.Dir(dstPath)); err != nil {
+				return errors.New("Failed to create target folder: " + path.Dir(dstPath) + " " + err.Error())
+			}
+		}
+		file, err := relayFile(src, dst, srcPath, dstPath, opts)
+		if file != nil {
+			result.add(file)
+		}
+		if err != nil {
+			return errors.New("Failed to transfer file: " + srcPath + " " + err.Error())
+		}
+	}
+	return nil
+}
+
+// relayFile 从源主机读取文件并流式写入目标主机，支持断点续传
+func relayFile(src, dst *sftp.Client, srcPath, dstPath string, opts *TransferOptions) (*TransferFile, error) {
+	srcFile, err := src.Open(srcPath)
+	if err != nil {
+		return nil, err
+	}
+	defer srcFile.Close()
+
+	info, err := srcFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+	result := &TransferFile{Source: srcPath, Target: dstPath, Size: info.Size()}
+
+	var targetSize int64
+	targetInfo, statErr := dst.Stat(dstPath)
+	if statErr == nil {
+		targetSize = targetInfo.Size()
+	}
+	result.Offset = resumeOffset(opts, targetSize, statErr, info.Size())
+	opts.Progress.beginFile(srcPath, result.Offset)
+
+	flags := os.O_WRONLY | os.O_CREATE
+	if result.Offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	dstFile, err := dst.OpenFile(dstPath, flags)
+	if err != nil {
+		return nil, err
+	}
+	defer dstFile.Close()
+
+	if result.Offset < info.Size() {
+		result.Bytes, err = copyFrom(dstFile, srcFile, result.Offset, opts.Progress)
+		if err != nil {
+			return result, err
+		}
+	}
+	dst.Chmod(dstPath, info.Mode().Perm())
+	opts.Progress.endFile()
+	return result, nil
+}
@@ -0,0 +1,651 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 运行手册步骤状态
+const (
+	StepOk      = "ok"
+	StepFailed  = "failed"
+	StepIgnored = "ignored" // 失败但设置了 ignore_errors
+	StepSkipped = "skipped" // 条件不满足或在 start_at 之前
+	StepDryRun  = "dry_run"
+	StepNotRun  = "not_run" // 前面的步骤失败，没有执行
+)
+
+// stringList 兼容单个字符串（可逗号分隔）与字符串数组的写法
+type stringList []string
+
+func (l *stringList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*l = optStrings(map[string]interface{}{"v": value.Value}, "v")
+		return nil
+	}
+	var list []string
+	if err := value.Decode(&list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// Runbook 运行手册，按顺序在目标主机上执行步骤
+type Runbook struct {
+	Name       string                 `yaml:"name"`
+	Targets    stringList             `yaml:"targets"`    // 默认目标，清单中的主机名、分组名或主机地址
+	Connection map[string]interface{} `yaml:"connection"` // 默认连接参数 {port, user, password, private_key, proxy, kex, ciphers, macs, host_key_algorithms}
+	Vars       map[string]interface{} `yaml:"vars"`
+	Steps      []*RunbookStep         `yaml:"steps"`
+}
+
+// RunbookStep 运行手册的单个步骤，exec/upload/write/download/wait_for_port/assert_output 只能设置一个
+type RunbookStep struct {
+	Name         string                 `yaml:"name"`
+	Targets      stringList             `yaml:"targets"`
+	When         string                 `yaml:"when"`
+	Retries      int                    `yaml:"retries"`
+	Delay        float64                `yaml:"delay"`   // 重试间隔，单位秒
+	Timeout      int                    `yaml:"timeout"` // 单位秒，默认 60
+	Register     string                 `yaml:"register"`
+	IgnoreErrors bool                   `yaml:"ignore_errors"`
+	Vars         map[string]interface{} `yaml:"vars"`
+
+	Exec         string           `yaml:"exec"`
+	Upload       *RunbookTransfer `yaml:"upload"`
+	Download     *RunbookTransfer `yaml:"download"`
+	Write        *RunbookWrite    `yaml:"write"`
+	WaitForPort  *RunbookWaitPort `yaml:"wait_for_port"`
+	AssertOutput *RunbookAssert   `yaml:"assert_output"`
+}
+
+// RunbookTransfer 上传或下载文件，源路径是目录时传输整个目录
+type RunbookTransfer struct {
+	Src       string                 `yaml:"src"`
+	Dest      string                 `yaml:"dest"`
+	Recursive bool                   `yaml:"recursive"` // 下载目录
+	Options   map[string]interface{} `yaml:"options"`
+}
+
+// RunbookWrite 写入远程文件
+type RunbookWrite struct {
+	Path    string                 `yaml:"path"`
+	Content string                 `yaml:"content"`
+	Options map[string]interface{} `yaml:"options"`
+}
+
+// RunbookWaitPort 从插件所在主机等待端口可连接，host 为空时使用目标主机地址
+type RunbookWaitPort struct {
+	Host    string `yaml:"host"`
+	Port    string `yaml:"port"`
+	Timeout int    `yaml:"timeout"` // 单位秒，默认 60
+}
+
+// RunbookAssert 断言命令输出或已登记步骤的输出
+type RunbookAssert struct {
+	Command     string `yaml:"command"`
+	From        string `yaml:"from"` // 已登记的输出名称
+	Contains    string `yaml:"contains"`
+	NotContains string `yaml:"not_contains"`
+	Equals      string `yaml:"equals"`
+	Regex       string `yaml:"regex"`
+	ExitCode    *int   `yaml:"exit_code"`
+}
+
+// RunbookOptions runbook_run 的调用选项
+type RunbookOptions struct {
+	Vars        map[string]interface{}
+	DryRun      bool
+	StartAt     string // 步骤名称或从 1 开始的序号
+	Concurrency int
+}
+
+// StepReport 单个步骤的执行报告
+type StepReport struct {
+	Index    int           `json:"index"`
+	Name     string        `json:"name"`
+	Action   string        `json:"action"`
+	Status   string        `json:"status"`
+	Reason   string        `json:"reason,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Hosts    []*HostResult `json:"hosts"`
+	Duration float64       `json:"duration_ms"`
+}
+
+// RunbookReport 运行手册执行报告
+type RunbookReport struct {
+	Name       string        `json:"name"`
+	Ok         bool          `json:"ok"`
+	DryRun     bool          `json:"dry_run"`
+	StartAt    int           `json:"start_at"`
+	FailedStep int           `json:"failed_step,omitempty"`
+	Steps      []*StepReport `json:"steps"`
+}
+
+// parseRunbookOptions 读取 runbook_run 的调用选项
+func parseRunbookOptions(opts map[string]interface{}) *RunbookOptions {
+	vars, _ := opts["vars"].(map[string]interface{})
+	return &RunbookOptions{
+		Vars:        vars,
+		DryRun:      optBool(opts, "dry_run", false),
+		StartAt:     optString(opts, "start_at", ""),
+		Concurrency: optInt(opts, "concurrency", 10),
+	}
+}
+
+// loadRunbook 读取运行手册，参数包含换行时作为 YAML 内容，否则作为文件路径；
+// 相对路径依次在当前目录、Yao 应用目录和应用目录下的 runbooks 目录中查找
+func loadRunbook(source string) (*Runbook, error) {
+	data := []byte(source)
+	if !strings.Contains(source, "\n") {
+		file, err := findRunbookFile(source)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = os.ReadFile(file); err != nil {
+			return nil, errors.New("Failed to load runbook: " + err.Error())
+		}
+	}
+	book := &Runbook{}
+	if err := yaml.Unmarshal(data, book); err != nil {
+		return nil, errors.New("Failed to parse runbook: " + err.Error())
+	}
+	if len(book.Steps) == 0 {
+		return nil, errors.New("Runbook has no steps")
+	}
+	for i, step := range book.Steps {
+		if step == nil {
+			return nil, errors.New("Runbook step " + strconv.Itoa(i+1) + " is empty")
+		}
+		if step.action() == "" {
+			return nil, errors.New("Runbook step " + step.label(i) + " must define exactly one action: exec, upload, write, download, wait_for_port or assert_output")
+		}
+	}
+	return book, nil
+}
+
+func findRunbookFile(name string) (string, error) {
+	candidates := []string{name}
+	if !filepath.IsAbs(name) {
+		root := os.Getenv("YAO_ROOT")
+		if root == "" {
+			root, _ = os.Getwd()
+		}
+		candidates = append(candidates, filepath.Join(root, name), filepath.Join(root, "runbooks", name))
+	}
+	for _, file := range candidates {
+		if info, err := os.Stat(file); err == nil && !info.IsDir() {
+			return file, nil
+		}
+	}
+	return "", errors.New("Runbook not found: " + name)
+}
+
+// action 返回步骤的动作类型，未设置或设置了多个时返回空字符串
+func (s *RunbookStep) action() string {
+	actions := make([]string, 0, 1)
+	if s.Exec != "" {
+		actions = append(actions, "exec")
+	}
+	if s.Upload != nil {
+		actions = append(actions, "upload")
+	}
+	if s.Download != nil {
+		actions = append(actions, "download")
+	}
+	if s.Write != nil {
+		actions = append(actions, "write")
+	}
+	if s.WaitForPort != nil {
+		actions = append(actions, "wait_for_port")
+	}
+	if s.AssertOutput != nil {
+		actions = append(actions, "assert_output")
+	}
+	if len(actions) != 1 {
+		return ""
+	}
+	return actions[0]
+}
+
+// label 返回用于报告与错误信息的步骤名称
+func (s *RunbookStep) label(index int) string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return "#" + strconv.Itoa(index+1)
+}
+
+// runbookRunner 保存一次执行过程中的状态
+type runbookRunner struct {
+	book       *Runbook
+	opts       *RunbookOptions
+	inv        *Inventory
+	registered map[string]map[string]interface{} // 主机 -> 登记名称 -> 输出
+	lock       sync.Mutex
+}
+
+// RunRunbook 按顺序执行运行手册中的步骤，任一步骤失败后停止（设置了 ignore_errors 的除外）
+func RunRunbook(book *Runbook, opts *RunbookOptions) (*RunbookReport, error) {
+	inv, err := loadInventory()
+	if err != nil {
+		return nil, err
+	}
+	start, err := book.stepIndex(opts.StartAt)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	runner := &runbookRunner{book: book, opts: opts, inv: inv, registered: map[string]map[string]interface{}{}}
+	report := &RunbookReport{Name: book.Name, Ok: true, DryRun: opts.DryRun, StartAt: start + 1, Steps: []*StepReport{}}
+
+	for i, step := range book.Steps {
+		stepReport := &StepReport{Index: i + 1, Name: step.label(i), Action: step.action(), Hosts: []*HostResult{}}
+		report.Steps = append(report.Steps, stepReport)
+		switch {
+		case !report.Ok:
+			stepReport.Status = StepNotRun
+		case i < start:
+			stepReport.Status = StepSkipped
+			stepReport.Reason = "before start_at"
+		default:
+			begin := time.Now()
+			runner.runStep(step, stepReport)
+			stepReport.Duration = float64(time.Since(begin).Microseconds()) / 1000
+			if stepReport.Status == StepFailed {
+				report.Ok = false
+				report.FailedStep = i + 1
+			}
+		}
+	}
+	return report, nil
+}
+
+// stepIndex 将 start_at 解析为步骤下标，支持步骤名称与从 1 开始的序号
+func (book *Runbook) stepIndex(startAt string) (int, error) {
+	if startAt == "" {
+		return 0, nil
+	}
+	for i, step := range book.Steps {
+		if step.Name == startAt {
+			return i, nil
+		}
+	}
+	if n, err := strconv.Atoi(startAt); err == nil && n >= 1 && n <= len(book.Steps) {
+		return n - 1, nil
+	}
+	return 0, errors.New("Runbook step not found: " + startAt)
+}
+
+// runStep 在步骤的所有目标主机上执行
+func (r *runbookRunner) runStep(step *RunbookStep, report *StepReport) {
+	hosts := []string(step.Targets)
+	if len(hosts) == 0 {
+		hosts = r.book.Targets
+	}
+	hosts, err := expandHostList(hosts)
+	if err != nil {
+		report.Status, report.Error = StepFailed, err.Error()
+		return
+	}
+	if len(hosts) == 0 {
+		if step.WaitForPort == nil || step.WaitForPort.Host == "" {
+			report.Status, report.Error = StepFailed, "no targets"
+			return
+		}
+		// 只等待指定地址的端口，不需要目标主机
+		hosts = []string{""}
+	}
+
+	targets := make([]*SSHTarget, 0, len(hosts))
+	for _, host := range hosts {
+		target := parseSSHTarget(r.book.Connection)
+		target.Host, target.Port = splitHostPort(host, target.Port)
+		targets = append(targets, target)
+	}
+
+	skipped := 0
+	result := runOnHosts(targets, &MultiOptions{Concurrency: r.opts.Concurrency}, func(target *SSHTarget) *HostResult {
+		vars := r.hostVars(target.Host, step)
+		if step.When != "" {
+			ok, err := evalCondition(step.When, vars)
+			if err != nil {
+				return &HostResult{Error: err.Error(), ExitCode: -1}
+			}
+			if !ok {
+				r.lock.Lock()
+				skipped++
+				r.lock.Unlock()
+				return &HostResult{Ok: true, Skipped: true, Stdout: "condition not met: " + step.When}
+			}
+		}
+		var hostResult *HostResult
+		for attempt := 0; attempt <= step.Retries; attempt++ {
+			if attempt > 0 && step.Delay > 0 {
+				time.Sleep(time.Duration(step.Delay * float64(time.Second)))
+			}
+			hostResult = r.runAction(step, target, vars)
+			if hostResult.Ok {
+				break
+			}
+		}
+		if step.Register != "" {
+			if r.opts.DryRun {
+				// 演练时登记空输出，后续步骤的变量仍然可以渲染
+				r.register(target.Host, step.Register, &HostResult{Ok: true})
+			} else {
+				r.register(target.Host, step.Register, hostResult)
+			}
+		}
+		return hostResult
+	})
+	report.Hosts = result.Results
+
+	switch {
+	case result.Summary.Failed > 0 && step.IgnoreErrors:
+		report.Status = StepIgnored
+	case result.Summary.Failed > 0:
+		report.Status = StepFailed
+		report.Error = "failed on " + strings.Join(result.Summary.FailedOn, ", ")
+	case skipped == len(targets):
+		report.Status = StepSkipped
+		report.Reason = "condition not met"
+	case r.opts.DryRun:
+		report.Status = StepDryRun
+	default:
+		report.Status = StepOk
+	}
+}
+
+// hostVars 合并变量，优先级：运行手册 < 清单主机变量 < 调用参数 < 步骤变量，再加上已登记的输出
+func (r *runbookRunner) hostVars(host string, step *RunbookStep) map[string]interface{} {
+	var inventoryVars map[string]interface{}
+	if item, ok := r.inv.Host(host); ok {
+		inventoryVars = item.Vars
+	}
+	r.lock.Lock()
+	registered := mergeVars(r.registered[host])
+	r.lock.Unlock()
+	return mergeVars(r.book.Vars, inventoryVars, r.opts.Vars, step.Vars, registered, map[string]interface{}{"host": host})
+}
+
+func (r *runbookRunner) register(host, name string, result *HostResult) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.registered[host] == nil {
+		r.registered[host] = map[string]interface{}{}
+	}
+	r.registered[host][name] = map[string]interface{}{
+		"stdout":    strings.TrimRight(result.Stdout, "\r\n"),
+		"stderr":    strings.TrimRight(result.Stderr, "\r\n"),
+		"exit_code": float64(result.ExitCode),
+		"ok":        result.Ok,
+	}
+}
+
+// runAction 在一台主机上执行一次步骤动作
+func (r *runbookRunner) runAction(step *RunbookStep, target *SSHTarget, vars map[string]interface{}) *HostResult {
+	timeout := time.Duration(step.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 60 * time.Second
+	}
+	render := func(texts ...*string) error {
+		for _, text := range texts {
+			val, err := renderTemplate(*text, vars)
+			if err != nil {
+				return err
+			}
+			*text = val
+		}
+		return nil
+	}
+
+	switch step.action() {
+	case "exec":
+		cmd := step.Exec
+		if err := render(&cmd); err != nil {
+			return failedHost(err)
+		}
+		if r.opts.DryRun {
+			return &HostResult{Ok: true, Stdout: "exec: " + cmd}
+		}
+		return runTargetCommand(target, cmd, timeout)
+
+	case "upload", "download":
+		ref := step.Upload
+		if step.action() == "download" {
+			ref = step.Download
+		}
+		// 复制一份再渲染模板，各主机共用同一个步骤
+		spec := *ref
+		if err := render(&spec.Src, &spec.Dest); err != nil {
+			return failedHost(err)
+		}
+		if r.opts.DryRun {
+			return &HostResult{Ok: true, Stdout: step.action() + ": " + spec.Src + " -> " + spec.Dest}
+		}
+		var result *TransferResult
+		var err error
+		opts := parseTransferOptions(spec.Options)
+		opts.Proxy = firstNonEmpty(opts.Proxy, target.Proxy)
+		opts.Algorithms = opts.Algorithms.merge(target.Algorithms)
+		if step.action() == "upload" {
+			if info, statErr := os.Stat(spec.Src); statErr == nil && info.IsDir() {
+				result, err = SSHCopyFolder(target.Host, target.Port, target.User, target.Password, target.PrivateKey, spec.Src, spec.Dest, opts)
+			} else {
+				result, err = SSHCopyFile(target.Host, target.Port, target.User, target.Password, target.PrivateKey, spec.Src, spec.Dest, opts)
+			}
+		} else if spec.Recursive {
+			result, err = SSHDownloadFolder(target.Host, target.Port, target.User, target.Password, target.PrivateKey, spec.Src, spec.Dest, opts)
+		} else {
+			result, err = SSHDownloadFile(target.Host, target.Port, target.User, target.Password, target.PrivateKey, spec.Src, spec.Dest, opts)
+		}
+		if err == nil && opts.Verify {
+			// 校验未通过时步骤失败，结果中保留逐个文件的校验信息
+			err = result.verifyError()
+		}
+		if err != nil {
+			return &HostResult{Error: err.Error(), ExitCode: -1, Data: result}
+		}
+		return &HostResult{Ok: true, Data: result}
+
+	case "write":
+		spec := *step.Write
+		if err := render(&spec.Path, &spec.Content); err != nil {
+			return failedHost(err)
+		}
+		if r.opts.DryRun {
+			return &HostResult{Ok: true, Stdout: "write: " + spec.Path + " (" + strconv.Itoa(len(spec.Content)) + " bytes)"}
+		}
+		opts, err := parseWriteOptions(spec.Options)
+		if err != nil {
+			return failedHost(err)
+		}
+		opts.Proxy = firstNonEmpty(opts.Proxy, target.Proxy)
+		opts.Algorithms = opts.Algorithms.merge(target.Algorithms)
+		result, err := SSHWriteFile(target.Host, target.Port, target.User, target.Password, target.PrivateKey, spec.Content, spec.Path, opts)
+		if err != nil {
+			return failedHost(err)
+		}
+		return &HostResult{Ok: true, Data: result}
+
+	case "wait_for_port":
+		spec := *step.WaitForPort
+		if err := render(&spec.Host, &spec.Port); err != nil {
+			return failedHost(err)
+		}
+		if spec.Host == "" {
+			// 清单中的主机名替换为实际地址
+			resolved := *target
+			if err := resolved.resolveInventory(); err != nil {
+				return failedHost(err)
+			}
+			spec.Host = resolved.Host
+		}
+		address := net.JoinHostPort(spec.Host, spec.Port)
+		if r.opts.DryRun {
+			return &HostResult{Ok: true, Stdout: "wait_for_port: " + address}
+		}
+		wait := time.Duration(spec.Timeout) * time.Second
+		if wait <= 0 {
+			wait = 60 * time.Second
+		}
+		if err := waitForPort(address, wait); err != nil {
+			return failedHost(err)
+		}
+		return &HostResult{Ok: true, Stdout: address + " is reachable"}
+
+	case "assert_output":
+		spec := *step.AssertOutput
+		if err := render(&spec.Command, &spec.Contains, &spec.NotContains, &spec.Equals, &spec.Regex); err != nil {
+			return failedHost(err)
+		}
+		if r.opts.DryRun {
+			return &HostResult{Ok: true, Stdout: "assert_output: " + firstNonEmpty(spec.Command, spec.From)}
+		}
+		var hostResult *HostResult
+		if spec.Command != "" {
+			hostResult = runTargetCommand(target, spec.Command, timeout)
+			if hostResult.ExitCode < 0 {
+				return hostResult
+			}
+		} else {
+			reg, ok := vars[spec.From].(map[string]interface{})
+			if !ok {
+				return failedHost(errors.New("Registered output not found: " + spec.From))
+			}
+			hostResult = &HostResult{
+				ExitCode: optInt(reg, "exit_code", 0),
+				Stdout:   optString(reg, "stdout", ""),
+				Stderr:   optString(reg, "stderr", ""),
+			}
+		}
+		if err := spec.check(hostResult); err != nil {
+			hostResult.Ok = false
+			hostResult.Error = err.Error()
+		} else {
+			hostResult.Ok = true
+			hostResult.Error = ""
+		}
+		return hostResult
+	}
+	return failedHost(errors.New("unknown action"))
+}
+
+// check 检查输出是否满足所有断言，未设置 exit_code 时只有命令断言要求退出码为 0
+func (a *RunbookAssert) check(result *HostResult) error {
+	output := strings.TrimRight(result.Stdout, "\r\n")
+	if a.ExitCode != nil && result.ExitCode != *a.ExitCode {
+		return errors.New("assertion failed: exit code " + strconv.Itoa(result.ExitCode) + ", expected " + strconv.Itoa(*a.ExitCode))
+	}
+	if a.ExitCode == nil && a.Command != "" && result.ExitCode != 0 {
+		return errors.New("assertion failed: exit code " + strconv.Itoa(result.ExitCode))
+	}
+	if a.Contains != "" && !strings.Contains(output, a.Contains) {
+		return errors.New("assertion failed: output does not contain " + strconv.Quote(a.Contains))
+	}
+	if a.NotContains != "" && strings.Contains(output, a.NotContains) {
+		return errors.New("assertion failed: output contains " + strconv.Quote(a.NotContains))
+	}
+	if a.Equals != "" && strings.TrimSpace(output) != a.Equals {
+		return errors.New("assertion failed: output is not " + strconv.Quote(a.Equals))
+	}
+	if a.Regex != "" {
+		re, err := regexp.Compile(a.Regex)
+		if err != nil {
+			return errors.New("Invalid regex: " + err.Error())
+		}
+		if !re.MatchString(output) {
+			return errors.New("assertion failed: output does not match " + strconv.Quote(a.Regex))
+		}
+	}
+	return nil
+}
+
+// runTargetCommand 在目标主机上执行命令，非 0 退出码视为失败
+func runTargetCommand(target *SSHTarget, cmd string, timeout time.Duration) *HostResult {
+	t := *target
+	t.Timeout = timeout
+	stdout, stderr, exitCode, err := t.Run(cmd, timeout)
+	hostResult := &HostResult{Ok: err == nil, ExitCode: exitCode, Stdout: stdout, Stderr: stderr}
+	if err != nil {
+		hostResult.Error = err.Error()
+	}
+	return hostResult
+}
+
+func failedHost(err error) *HostResult {
+	return &HostResult{Error: err.Error(), ExitCode: -1}
+}
+
+// waitForPort 轮询直到端口可以建立 TCP 连接或超时
+func waitForPort(address string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		conn, err := net.DialTimeout("tcp", address, time.Second)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New("Timeout waiting for " + address + ": " + err.Error())
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// conditionRe 匹配 "左值 运算符 右值" 形式的条件
+var conditionRe = regexp.MustCompile(`^(.*?)\s+(==|!=|=~|contains)\s+(.*)$`)
+
+// evalCondition 计算 when 条件：支持 ==、!=、=~（正则）、contains，
+// 没有运算符时按真值判断，空字符串、false、0、no 为假
+func evalCondition(expr string, vars map[string]interface{}) (bool, error) {
+	text, err := renderTemplate(expr, vars)
+	if err != nil {
+		return false, err
+	}
+	text = strings.TrimSpace(text)
+	if m := conditionRe.FindStringSubmatch(text); m != nil {
+		left, right := unquote(m[1]), unquote(m[3])
+		switch m[2] {
+		case "==":
+			return left == right, nil
+		case "!=":
+			return left != right, nil
+		case "contains":
+			return strings.Contains(left, right), nil
+		case "=~":
+			re, err := regexp.Compile(right)
+			if err != nil {
+				return false, errors.New("Invalid regex in condition: " + err.Error())
+			}
+			return re.MatchString(left), nil
+		}
+	}
+	switch strings.ToLower(unquote(text)) {
+	case "", "false", "0", "no":
+		return false, nil
+	}
+	return true, nil
+}
+
+// unquote 去掉两侧的空白和引号
+func unquote(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && (s[0] == '"' && s[len(s)-1] == '"' || s[0] == '\'' && s[len(s)-1] == '\'') {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func testRunbook(server *testSSHServer, dir string) string {
+	return `
+name: deploy
+targets: ` + net.JoinHostPort(server.Host, server.Port) + `
+connection:
+  user: ` + testSSHUser + `
+  password: ` + testSSHPassword + `
+vars:
+  version: "1.2"
+steps:
+  - name: wait
+    wait_for_port: { port: "` + server.Port + `", timeout: 5 }
+  - name: build
+    exec: echo build-{{ version }}
+    register: build
+  - name: check
+    assert_output: { from: build, equals: build-1.2 }
+  - name: write
+    write: { path: ` + filepath.Join(dir, "app.conf") + `, content: "version={{ build.stdout }}" }
+  - name: download
+    download: { src: ` + filepath.Join(dir, "app.conf") + `, dest: "` + filepath.Join(dir, "app-{{ version }}.back") + `", options: { verify: true } }
+  - name: skipped
+    when: "{{ version }} == 2.0"
+    exec: exit 1
+  - name: fail
+    exec: exit 3
+    retries: 1
+  - name: after
+    exec: echo after
+`
+}
+
+func runTestRunbook(t *testing.T, source string, opts map[string]interface{}) *RunbookReport {
+	t.Helper()
+	output := execOutput(t, "runbook_run", source, opts)
+	report := &RunbookReport{}
+	if err := json.Unmarshal([]byte(output), report); err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestRunbookRun(t *testing.T) {
+	server := startTestSSHServer(t)
+	dir := t.TempDir()
+	source := testRunbook(server, dir)
+
+	report := runTestRunbook(t, source, nil)
+	want := []string{StepOk, StepOk, StepOk, StepOk, StepOk, StepSkipped, StepFailed, StepNotRun}
+	for i, step := range report.Steps {
+		if step.Status != want[i] {
+			t.Errorf("step %s: status %s, want %s (%s)", step.Name, step.Status, want[i], step.Error)
+		}
+	}
+	if report.Ok || report.FailedStep != 7 || report.Steps[6].Hosts[0].ExitCode != 3 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if readTestFile(t, filepath.Join(dir, "app.conf")) != "version=build-1.2" {
+		t.Error("unexpected written content")
+	}
+	if readTestFile(t, filepath.Join(dir, "app-1.2.back")) != "version=build-1.2" {
+		t.Error("unexpected downloaded content")
+	}
+
+	// 从失败之后的步骤继续执行
+	report = runTestRunbook(t, source, map[string]interface{}{"start_at": "after"})
+	if !report.Ok || report.Steps[0].Status != StepSkipped || report.Steps[7].Status != StepOk {
+		t.Errorf("unexpected resumed report %+v", report)
+	}
+
+	report = runTestRunbook(t, source, map[string]interface{}{"dry_run": true, "vars": map[string]interface{}{"version": "2.0"}})
+	if report.Steps[1].Status != StepDryRun || report.Steps[1].Hosts[0].Stdout != "exec: echo build-2.0" || report.Steps[5].Status != StepDryRun {
+		t.Errorf("unexpected dry run report %+v", report.Steps[1])
+	}
+	if download := report.Steps[4].Hosts[0]; download.Stdout != "download: "+filepath.Join(dir, "app.conf")+" -> "+filepath.Join(dir, "app-2.0.back") {
+		t.Errorf("unexpected dry run download %+v", download)
+	}
+}
+
+func TestEvalCondition(t *testing.T) {
+	vars := map[string]interface{}{"env": "prod", "build": map[string]interface{}{"stdout": "ok 1"}}
+	cases := map[string]bool{
+		"{{ env }} == prod":             true,
+		"'{{ env }}' != 'prod'":         false,
+		"{{ build.stdout }} =~ ^ok":     true,
+		"{{ build.stdout }} contains 1": true,
+		"false":                         false,
+		"{{ env }}":                     true,
+	}
+	for expr, want := range cases {
+		got, err := evalCondition(expr, vars)
+		if err != nil || got != want {
+			t.Errorf("%s: got %v %v, want %v", expr, got, err, want)
+		}
+	}
+	if _, err := evalCondition("{{ missing }}", vars); err == nil {
+		t.Error("expected undefined variable error")
+	}
+}
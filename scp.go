@@ -0,0 +1,478 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// 传输协议，SFTP 子系统不可用时自动改用 SCP
+const (
+	ProtocolSFTP = "sftp"
+	ProtocolSCP  = "scp"
+)
+
+// scpSession 一次 SCP 协议会话，远程执行 scp -t（接收）或 scp -f（发送）
+type scpSession struct {
+	session *ssh.Session
+	stdin   io.WriteCloser
+	stdout  *bufio.Reader
+	stderr  strings.Builder
+}
+
+// startSCP 在远程启动 scp 进程，mode 为 -t 或 -f
+func startSCP(conn *ssh.Client, mode string, recursive bool, target string) (*scpSession, error) {
+	session, err := conn.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	s := &scpSession{session: session}
+	s.session.Stderr = &s.stderr
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	s.stdin = stdin
+	s.stdout = bufio.NewReader(stdout)
+
+	cmd := "scp " + mode
+	if recursive {
+		cmd += " -r"
+	}
+	if err := session.Start(cmd + " " + shellQuote(target)); err != nil {
+		session.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// close 结束会话并等待远程 scp 退出
+func (s *scpSession) close() error {
+	s.stdin.Close()
+	err := s.session.Wait()
+	s.session.Close()
+	if err != nil && s.stderr.Len() > 0 {
+		return errors.New(strings.TrimSpace(s.stderr.String()))
+	}
+	return err
+}
+
+// readAck 读取远程的应答，0 表示成功，1/2 后面跟随错误信息
+func (s *scpSession) readAck() error {
+	code, err := s.stdout.ReadByte()
+	if err != nil {
+		if s.stderr.Len() > 0 {
+			return errors.New(strings.TrimSpace(s.stderr.String()))
+		}
+		return err
+	}
+	if code == 0 {
+		return nil
+	}
+	msg, _ := s.stdout.ReadString('\n')
+	return errors.New("scp: " + strings.TrimSpace(msg))
+}
+
+func (s *scpSession) ack() error {
+	_, err := s.stdin.Write([]byte{0})
+	return err
+}
+
+// sendLine 发送一条控制指令并等待应答
+func (s *scpSession) sendLine(line string) error {
+	if _, err := io.WriteString(s.stdin, line+"\n"); err != nil {
+		return err
+	}
+	return s.readAck()
+}
+
+// sendFile 发送单个文件的内容
+func (s *scpSession) sendFile(name string, mode os.FileMode, size int64, src io.Reader, progress *TransferProgress) (int64, error) {
+	if err := s.sendLine(fmt.Sprintf("C%04o %d %s", mode.Perm(), size, name)); err != nil {
+		return 0, err
+	}
+	var w io.Writer = s.stdin
+	if progress != nil {
+		w = &progressWriter{w: s.stdin, progress: progress}
+	}
+	n, err := io.CopyBuffer(w, io.LimitReader(src, size), make([]byte, transferBufferSize))
+	if err != nil {
+		return n, err
+	}
+	if n != size {
+		return n, errors.New("scp: file size changed during transfer: " + name)
+	}
+	if err := s.ack(); err != nil {
+		return n, err
+	}
+	return n, s.readAck()
+}
+
+// scpUploadFile 通过 SCP 协议上传单个文件
+func scpUploadFile(conn *ssh.Client, localPath, remotePath string, opts *TransferOptions) (*TransferFile, error) {
+	src, err := os.Open(localPath)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return nil, err
+	}
+	result := &TransferFile{Source: localPath, Target: remotePath, Size: info.Size(), localPath: localPath, remotePath: remotePath}
+
+	s, err := startSCP(conn, "-t", false, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.readAck(); err != nil {
+		s.close()
+		return nil, err
+	}
+	opts.Progress.beginFile(localPath, 0)
+	result.Bytes, err = s.sendFile(path.Base(remotePath), info.Mode(), info.Size(), src, opts.Progress)
+	closeErr := s.close()
+	if err != nil {
+		return result, err
+	}
+	if closeErr != nil {
+		return result, closeErr
+	}
+	opts.Progress.endFile()
+	return result, nil
+}
+
+// scpUploadFolder 通过 SCP 协议递归上传目录复制计划中的内容
+func scpUploadFolder(conn *ssh.Client, entries []*folderEntry, remoteFolder string, opts *TransferOptions) (*TransferResult, error) {
+	result := &TransferResult{Files: []*TransferFile{}}
+	if _, _, err := runSession(conn, "mkdir -p "+shellQuote(remoteFolder)); err != nil {
+		return result, errors.New("Failed to create remote folder: " + err.Error())
+	}
+
+	s, err := startSCP(conn, "-t", true, remoteFolder)
+	if err != nil {
+		return result, err
+	}
+	if err := s.readAck(); err != nil {
+		s.close()
+		return result, err
+	}
+
+	// stack 为当前所在的远程目录层级，按需发送 D/E 指令进出目录
+	stack := make([]string, 0)
+	enter := func(dir string) error {
+		parts := []string{}
+		if dir != "." && dir != "" {
+			parts = strings.Split(dir, "/")
+		}
+		common := 0
+		for common < len(stack) && common < len(parts) && stack[common] == parts[common] {
+			common++
+		}
+		for len(stack) > common {
+			if err := s.sendLine("E"); err != nil {
+				return err
+			}
+			stack = stack[:len(stack)-1]
+		}
+		for _, part := range parts[common:] {
+			if err := s.sendLine("D0755 0 " + part); err != nil {
+				return err
+			}
+			stack = append(stack, part)
+		}
+		return nil
+	}
+
+	for _, entry := range entries {
+		if entry.isDir {
+			if len(opts.Include) == 0 {
+				if err = enter(entry.rel); err != nil {
+					break
+				}
+			}
+			continue
+		}
+		if err = enter(path.Dir(entry.rel)); err != nil {
+			break
+		}
+		remotePath := path.Join(remoteFolder, entry.rel)
+		file := &TransferFile{Source: entry.path, Target: remotePath, Size: entry.size, localPath: entry.path, remotePath: remotePath}
+		src, openErr := os.Open(entry.path)
+		if openErr != nil {
+			err = openErr
+			break
+		}
+		opts.Progress.beginFile(entry.path, 0)
+		file.Bytes, err = s.sendFile(path.Base(entry.rel), fileMode(entry.path), entry.size, src, opts.Progress)
+		src.Close()
+		result.add(file)
+		if err != nil {
+			err = errors.New("Failed to Write Remote File: " + remotePath + " " + err.Error())
+			break
+		}
+		opts.Progress.endFile()
+	}
+	if err == nil {
+		err = enter(".")
+	}
+	closeErr := s.close()
+	if err != nil {
+		return result, err
+	}
+	return result, closeErr
+}
+
+func fileMode(localPath string) os.FileMode {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return 0644
+	}
+	return info.Mode()
+}
+
+// scpRecord 远程 scp -f 发送的一条记录
+type scpRecord struct {
+	kind byte // C 文件，D 进入目录，E 退出目录
+	mode os.FileMode
+	size int64
+	name string
+}
+
+// readRecord 读取下一条记录，跳过时间戳（T）记录
+func (s *scpSession) readRecord() (*scpRecord, error) {
+	for {
+		line, err := s.stdout.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && line == "" {
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\n")
+		if line == "" {
+			continue
+		}
+		switch line[0] {
+		case 1, 2:
+			return nil, errors.New("scp: " + strings.TrimSpace(line[1:]))
+		case 'T':
+			if err := s.ack(); err != nil {
+				return nil, err
+			}
+			continue
+		case 'E':
+			return &scpRecord{kind: 'E'}, s.ack()
+		case 'C', 'D':
+			fields := strings.SplitN(line[1:], " ", 3)
+			if len(fields) != 3 {
+				return nil, errors.New("scp: invalid record " + line)
+			}
+			mode, err1 := strconv.ParseUint(fields[0], 8, 32)
+			size, err2 := strconv.ParseInt(fields[1], 10, 64)
+			if err1 != nil || err2 != nil || strings.Contains(fields[2], "/") || fields[2] == ".." {
+				return nil, errors.New("scp: invalid record " + line)
+			}
+			return &scpRecord{kind: line[0], mode: os.FileMode(mode), size: size, name: fields[2]}, s.ack()
+		default:
+			return nil, errors.New("scp: unexpected record " + line)
+		}
+	}
+}
+
+// receiveFile 接收文件内容写入本地
+func (s *scpSession) receiveFile(record *scpRecord, localPath string, progress *TransferProgress) (int64, error) {
+	dst, err := os.OpenFile(localPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, record.mode.Perm()|0200)
+	if err != nil {
+		return 0, err
+	}
+	var w io.Writer = dst
+	if progress != nil {
+		w = &progressWriter{w: dst, progress: progress}
+	}
+	n, err := io.CopyBuffer(w, io.LimitReader(s.stdout, record.size), make([]byte, transferBufferSize))
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return n, err
+	}
+	if n != record.size {
+		return n, io.ErrUnexpectedEOF
+	}
+	if err := s.readAck(); err != nil {
+		return n, err
+	}
+	return n, s.ack()
+}
+
+// scpDownload 通过 SCP 协议下载文件或目录，recursive 为 true 时远程路径为目录
+func scpDownload(conn *ssh.Client, remotePath, localPath string, recursive bool, opts *TransferOptions) (*TransferResult, error) {
+	result := &TransferResult{Files: []*TransferFile{}}
+	s, err := startSCP(conn, "-f", recursive, remotePath)
+	if err != nil {
+		return result, err
+	}
+	if err := s.ack(); err != nil {
+		s.close()
+		return result, err
+	}
+
+	// dirs 为当前所在的本地与远程目录层级，第一层 D 记录对应 localPath 本身，
+	// 只请求了一个路径，顶层的文件或目录接收完成后即结束会话
+	localDirs := make([]string, 0)
+	remoteDirs := make([]string, 0)
+	for {
+		record, err := s.readRecord()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			s.close()
+			return result, err
+		}
+		switch record.kind {
+		case 'D':
+			local, remote := localPath, remotePath
+			if len(localDirs) > 0 {
+				local = filepath.Join(localDirs[len(localDirs)-1], record.name)
+				remote = path.Join(remoteDirs[len(remoteDirs)-1], record.name)
+			}
+			if err := os.MkdirAll(local, record.mode.Perm()|0700); err != nil {
+				s.close()
+				return result, err
+			}
+			localDirs = append(localDirs, local)
+			remoteDirs = append(remoteDirs, remote)
+		case 'E':
+			if len(localDirs) > 0 {
+				localDirs = localDirs[:len(localDirs)-1]
+				remoteDirs = remoteDirs[:len(remoteDirs)-1]
+			}
+			if len(localDirs) == 0 {
+				return result, s.close()
+			}
+		case 'C':
+			local, remote := localPath, remotePath
+			if len(localDirs) > 0 {
+				local = filepath.Join(localDirs[len(localDirs)-1], record.name)
+				remote = path.Join(remoteDirs[len(remoteDirs)-1], record.name)
+			}
+			file := &TransferFile{Source: remote, Target: local, Size: record.size, localPath: local, remotePath: remote}
+			opts.Progress.addTotal(record.size, 1)
+			opts.Progress.beginFile(remote, 0)
+			file.Bytes, err = s.receiveFile(record, local, opts.Progress)
+			result.add(file)
+			if err != nil {
+				s.close()
+				return result, errors.New("Failed to Write Local File: " + local + " " + err.Error())
+			}
+			opts.Progress.endFile()
+			if len(localDirs) == 0 {
+				return result, s.close()
+			}
+		}
+	}
+	return result, s.close()
+}
+
+// scpWriteFile SFTP 不可用时通过 SCP 上传临时文件，再用 shell 命令完成追加、备份、权限与重命名
+func scpWriteFile(conn *ssh.Client, data, dstPath string, opts *WriteOptions) (*WriteResult, error) {
+	content, err := opts.decodeContent(data)
+	if err != nil {
+		return nil, err
+	}
+	result := &WriteResult{Path: dstPath, Bytes: int64(len(content))}
+	_, _, statErr := runSession(conn, "test -e "+shellQuote(dstPath))
+	exists := statErr == nil
+
+	quoted := shellQuote(dstPath)
+	if opts.Backup != "" && exists {
+		result.Backup = dstPath + opts.Backup
+		if _, _, err := runSession(conn, "cp -p "+quoted+" "+shellQuote(result.Backup)); err != nil {
+			return nil, errors.New("Failed to backup remote file: " + err.Error())
+		}
+	}
+
+	mode := opts.Mode
+	if mode == 0 {
+		mode = 0644
+		if exists {
+			if out, _, err := runSession(conn, "stat -c %a "+quoted); err == nil {
+				if m, err := strconv.ParseUint(strings.TrimSpace(out), 8, 32); err == nil {
+					mode = os.FileMode(m)
+				}
+			}
+		}
+	}
+
+	temp := tempWritePath(dstPath)
+	s, err := startSCP(conn, "-t", false, temp)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.readAck(); err != nil {
+		s.close()
+		return nil, err
+	}
+	_, err = s.sendFile(path.Base(temp), mode, int64(len(content)), strings.NewReader(string(content)), nil)
+	if closeErr := s.close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		runSession(conn, "rm -f "+shellQuote(temp))
+		return nil, err
+	}
+
+	// 原子写入直接重命名，目标不存在时追加等同于原子写入；非原子写入通过 cat 写回，保留原文件的权限与属主
+	script := []string{}
+	quotedTemp := shellQuote(temp)
+	switch {
+	case opts.Atomic && opts.Append && exists:
+		script = append(script, "cat "+quoted+" "+quotedTemp+" > "+quotedTemp+".new", "mv -f "+quotedTemp+".new "+quotedTemp)
+	case opts.Atomic:
+	case opts.Append:
+		script = append(script, "cat "+quotedTemp+" >> "+quoted, "rm -f "+quotedTemp)
+	default:
+		script = append(script, "cat "+quotedTemp+" > "+quoted, "rm -f "+quotedTemp)
+	}
+	target := quoted
+	if opts.Atomic {
+		target = quotedTemp
+	}
+	if opts.Mode != 0 || opts.Atomic {
+		script = append(script, fmt.Sprintf("chmod %04o %s", mode.Perm(), target))
+	}
+	if opts.Owner != "" || opts.Group != "" {
+		owner := opts.Owner
+		if opts.Group != "" {
+			owner += ":" + opts.Group
+		}
+		script = append(script, "chown "+shellQuote(owner)+" "+target)
+	}
+	if opts.Atomic {
+		script = append(script, "mv -f "+quotedTemp+" "+quoted)
+	}
+	if _, _, err := runSession(conn, strings.Join(script, " && ")); err != nil {
+		runSession(conn, "rm -f "+quotedTemp+" "+quotedTemp+".new")
+		return nil, err
+	}
+	if opts.Mode != 0 {
+		result.Mode = fmt.Sprintf("0%o", opts.Mode.Perm())
+	}
+	return result, nil
+}
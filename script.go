@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ScriptOptions 远程脚本执行选项
+type ScriptOptions struct {
+	Interpreter string                 // 解释器，为空时使用脚本的 #! 行，没有时使用 sh
+	Args        []string               // 脚本参数
+	Vars        map[string]interface{} // 上传前替换的模板变量
+	Template    bool                   // 是否替换模板变量，默认只在指定了 vars 时替换
+	Timeout     time.Duration          // 执行超时
+	TempDir     string                 // 远程临时目录
+	Keep        bool                   // 执行后保留远程脚本文件
+}
+
+// ScriptResult 远程脚本执行结果
+type ScriptResult struct {
+	Path        string  `json:"path"`
+	Interpreter string  `json:"interpreter"`
+	ExitCode    int     `json:"exit_code"`
+	Stdout      string  `json:"stdout"`
+	Stderr      string  `json:"stderr"`
+	Duration    float64 `json:"duration_ms"`
+}
+
+// parseScriptOptions 读取脚本选项，timeout 单位为秒，默认 60
+func parseScriptOptions(opts map[string]interface{}) *ScriptOptions {
+	vars, _ := opts["vars"].(map[string]interface{})
+	sopts := &ScriptOptions{
+		Interpreter: optString(opts, "interpreter", ""),
+		Vars:        vars,
+		Template:    optBool(opts, "template", len(vars) > 0),
+		Timeout:     time.Duration(optInt(opts, "timeout", 60)) * time.Second,
+		TempDir:     optString(opts, "tmp_dir", "/tmp"),
+		Keep:        optBool(opts, "keep", false),
+	}
+	// 参数保持原样，不按逗号拆分
+	switch val := opts["args"].(type) {
+	case []interface{}:
+		for _, item := range val {
+			sopts.Args = append(sopts.Args, optString(map[string]interface{}{"v": item}, "v", ""))
+		}
+	case string:
+		if val != "" {
+			sopts.Args = []string{val}
+		}
+	}
+	if sopts.Timeout <= 0 {
+		sopts.Timeout = 60 * time.Second
+	}
+	return sopts
+}
+
+// loadScript 参数是存在的本地文件路径时读取文件内容，否则作为脚本内容
+func loadScript(script string) (string, error) {
+	if script == "" {
+		return "", errors.New("empty script")
+	}
+	if !strings.ContainsAny(script, "\n\r") {
+		if info, err := os.Stat(script); err == nil && !info.IsDir() {
+			data, err := os.ReadFile(script)
+			if err != nil {
+				return "", errors.New("Failed to read script: " + err.Error())
+			}
+			return string(data), nil
+		}
+	}
+	return script, nil
+}
+
+// scriptInterpreter 返回执行脚本的命令前缀
+func scriptInterpreter(content string, interpreter string) string {
+	if interpreter != "" {
+		return interpreter
+	}
+	if strings.HasPrefix(content, "#!") {
+		line := strings.TrimSpace(strings.SplitN(content[2:], "\n", 2)[0])
+		if line != "" {
+			return line
+		}
+	}
+	return "sh"
+}
+
+// SSHRunScript 上传脚本到远程临时目录，设置可执行权限后运行，结束后删除脚本
+func SSHRunScript(target *SSHTarget, script string, opts *ScriptOptions) (*ScriptResult, error) {
+	content, err := loadScript(script)
+	if err != nil {
+		return nil, err
+	}
+	// 脚本中可能有 Jinja、Helm 等同样使用 {{ }} 的文本，没有指定 vars 时原样上传
+	if opts.Template {
+		vars := opts.Vars
+		if inv, err := loadInventory(); err == nil {
+			if host, ok := inv.Host(target.Host); ok {
+				vars = mergeVars(host.Vars, opts.Vars)
+			}
+		}
+		content, err = renderTemplate(content, mergeVars(vars, map[string]interface{}{"host": target.Host}))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := target.Dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	result := &ScriptResult{
+		Path:        path.Join(opts.TempDir, ".cmdt-script-"+newTransferID()),
+		Interpreter: scriptInterpreter(content, opts.Interpreter),
+	}
+	writeOpts := &WriteOptions{Mode: 0700}
+	client := newSFTPClient(conn)
+	if client != nil {
+		defer client.Close()
+		_, err = writeRemoteFile(conn, client, content, result.Path, writeOpts)
+	} else {
+		_, err = scpWriteFile(conn, content, result.Path, writeOpts)
+	}
+	if err != nil {
+		return nil, errors.New("Failed to upload script: " + err.Error())
+	}
+	if !opts.Keep {
+		defer func() {
+			if client == nil || client.Remove(result.Path) != nil {
+				runSession(conn, "rm -f "+shellQuote(result.Path))
+			}
+		}()
+	}
+
+	cmd := result.Interpreter + " " + shellQuote(result.Path)
+	for _, arg := range opts.Args {
+		cmd += " " + shellQuote(arg)
+	}
+	start := time.Now()
+	result.Stdout, result.Stderr, result.ExitCode, err = runSessionTimeout(conn, cmd, opts.Timeout)
+	result.Duration = float64(time.Since(start).Microseconds()) / 1000
+	if _, ok := err.(*ssh.ExitError); ok {
+		err = nil
+	}
+	return result, err
+}
+
+// runSessionTimeout 在已有连接上执行命令，超时后关闭会话；非零退出码通过 exitCode 与 *ssh.ExitError 返回
+func runSessionTimeout(conn *ssh.Client, cmd string, timeout time.Duration) (string, string, int, error) {
+	session, err := conn.NewSession()
+	if err != nil {
+		return "", "", -1, err
+	}
+	defer session.Close()
+	var b bytes.Buffer
+	var er bytes.Buffer
+	session.Stdout = &b
+	session.Stderr = &er
+	if err := session.Start(cmd); err != nil {
+		return "", "", -1, err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Wait()
+	}()
+	select {
+	case err = <-done:
+	case <-time.After(timeout):
+		session.Signal(ssh.SIGKILL)
+		session.Close()
+		<-done
+		return b.String(), er.String(), -1, errors.New("timeout reached, SSH session canceled")
+	}
+	exitCode := 0
+	if exitErr, ok := err.(*ssh.ExitError); ok {
+		exitCode = exitErr.ExitStatus()
+	} else if err != nil {
+		exitCode = -1
+	}
+	return b.String(), er.String(), exitCode, err
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 会话状态
+const (
+	ShellOpen   = "open"
+	ShellClosed = "closed"
+)
+
+// shellSendTimeout shell_send 每条命令默认的超时
+const shellSendTimeout = 30 * time.Second
+
+// shellReapInterval 检查空闲会话的间隔
+const shellReapInterval = 30 * time.Second
+
+// errShellTimeout 等待命令结束超时
+var errShellTimeout = errors.New("timeout reached, shell session closed")
+
+// ShellSession 保持在连接上的交互式 shell，cd、export 等状态在多次调用之间保留
+type ShellSession struct {
+	ID       string    `json:"id"`
+	Type     string    `json:"type"`
+	Host     string    `json:"host,omitempty"`
+	Shell    string    `json:"shell"`
+	Status   string    `json:"status"`
+	Error    string    `json:"error,omitempty"`
+	Cwd      string    `json:"cwd,omitempty"`
+	Commands int       `json:"commands"`
+	OpenedAt time.Time `json:"opened_at"`
+	LastUsed time.Time `json:"last_used"`
+
+	IdleTimeout int `json:"idle_timeout"` // 秒，0 表示不自动关闭
+
+	mu     sync.Mutex // 保护状态字段
+	sendMu sync.Mutex // 同一会话的命令依次执行
+	stdin  io.Writer
+	stdout *shellStream
+	stderr *shellStream
+	closer func()
+}
+
+// ShellCommand 会话中一条命令的执行结果
+type ShellCommand struct {
+	Command  string  `json:"command"`
+	ExitCode int     `json:"exit_code"`
+	Stdout   string  `json:"stdout"`
+	Stderr   string  `json:"stderr"`
+	Cwd      string  `json:"cwd"`
+	Duration float64 `json:"duration_ms"`
+}
+
+// ShellSendResult shell_send 的返回结果
+type ShellSendResult struct {
+	ID      string          `json:"id"`
+	Results []*ShellCommand `json:"results"`
+}
+
+// shellRegistry 保存所有打开的 shell 会话
+type shellRegistry struct {
+	mu     sync.Mutex
+	items  map[string]*ShellSession
+	reaper sync.Once
+}
+
+var shells = &shellRegistry{items: map[string]*ShellSession{}}
+
+// shellStream 在后台持续读取 shell 的输出，按分隔标记切分每条命令的输出
+type shellStream struct {
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	err    error
+	notify chan struct{}
+}
+
+func newShellStream(r io.Reader) *shellStream {
+	s := &shellStream{notify: make(chan struct{}, 1)}
+	go s.pump(r)
+	return s
+}
+
+func (s *shellStream) pump(r io.Reader) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		s.mu.Lock()
+		s.buf.Write(buf[:n])
+		if err != nil {
+			s.err = err
+		}
+		s.mu.Unlock()
+		select {
+		case s.notify <- struct{}{}:
+		default:
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// readUntil 等待 marker 出现，返回 marker 之前的输出与 marker 之后到行尾的内容
+func (s *shellStream) readUntil(marker string, deadline <-chan time.Time) (string, string, error) {
+	for {
+		s.mu.Lock()
+		data := s.buf.Bytes()
+		if i := bytes.Index(data, []byte(marker)); i >= 0 {
+			if j := bytes.IndexByte(data[i:], '\n'); j >= 0 {
+				out := string(data[:i])
+				tail := string(data[i+len(marker) : i+j])
+				s.buf.Next(i + j + 1)
+				s.mu.Unlock()
+				return out, tail, nil
+			}
+		}
+		err := s.err
+		s.mu.Unlock()
+		if err != nil {
+			return "", "", errors.New("shell exited")
+		}
+		select {
+		case <-s.notify:
+		case <-deadline:
+			return "", "", errShellTimeout
+		}
+	}
+}
+
+// drain 取出已读取但未被命令消费的输出
+func (s *shellStream) drain() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := s.buf.String()
+	s.buf.Reset()
+	return out
+}
+
+// ShellOptions 打开会话的选项
+type ShellOptions struct {
+	ID          string            // 会话ID，为空时自动生成
+	Shell       string            // 启动的 shell，远程为空时使用登录 shell，本地默认 sh
+	Cwd         string            // 本地会话的初始工作目录
+	Env         map[string]string // 本地会话追加的环境变量
+	IdleTimeout time.Duration     // 空闲超过该时间后自动关闭，0 表示不关闭
+}
+
+// parseShellOptions 读取会话选项，idle_timeout 单位为秒，默认 1800
+func parseShellOptions(opts map[string]interface{}) *ShellOptions {
+	sopts := &ShellOptions{
+		ID:          optString(opts, "id", ""),
+		Shell:       optString(opts, "shell", ""),
+		Cwd:         optString(opts, "cwd", ""),
+		Env:         map[string]string{},
+		IdleTimeout: time.Duration(optInt(opts, "idle_timeout", 1800)) * time.Second,
+	}
+	if env, ok := opts["env"].(map[string]interface{}); ok {
+		for key := range env {
+			sopts.Env[key] = optString(env, key, "")
+		}
+	}
+	if sopts.IdleTimeout < 0 {
+		sopts.IdleTimeout = 0
+	}
+	return sopts
+}
+
+// OpenRemoteShell 在 SSH 连接上启动 shell，shell 为空时使用用户的登录 shell
+func OpenRemoteShell(target *SSHTarget, opts *ShellOptions) (*ShellSession, error) {
+	conn, err := target.Dial()
+	if err != nil {
+		return nil, err
+	}
+	session, err := conn.NewSession()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	closer := func() {
+		session.Close()
+		conn.Close()
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		closer()
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		closer()
+		return nil, err
+	}
+	stderr, err := session.StderrPipe()
+	if err != nil {
+		closer()
+		return nil, err
+	}
+	shell := opts.Shell
+	if shell == "" {
+		err = session.Shell()
+		shell = "login"
+	} else {
+		err = session.Start(shell)
+	}
+	if err != nil {
+		closer()
+		return nil, errors.New("Failed to start shell: " + err.Error())
+	}
+
+	s := &ShellSession{
+		Type:   "remote",
+		Host:   target.Address(),
+		Shell:  shell,
+		stdin:  stdin,
+		stdout: newShellStream(stdout),
+		stderr: newShellStream(stderr),
+		closer: closer,
+	}
+	return registerShell(s, opts)
+}
+
+// registerShell 登记会话并执行一条空命令，确认 shell 可用并取得初始工作目录
+func registerShell(s *ShellSession, opts *ShellOptions) (*ShellSession, error) {
+	id := opts.ID
+	if id == "" {
+		id = newTransferID()
+	}
+	s.ID = id
+	s.Status = ShellOpen
+	s.IdleTimeout = int(opts.IdleTimeout / time.Second)
+	s.OpenedAt = time.Now()
+	s.LastUsed = s.OpenedAt
+
+	shells.mu.Lock()
+	if _, ok := shells.items[id]; ok {
+		shells.mu.Unlock()
+		s.closer()
+		return nil, errors.New("Shell session already exists: " + id)
+	}
+	shells.items[id] = s
+	shells.mu.Unlock()
+	shells.reaper.Do(func() { go shells.reap() })
+
+	if _, err := s.Send([]string{":"}, shellSendTimeout); err != nil {
+		s.Close()
+		return nil, errors.New("Failed to start shell: " + err.Error())
+	}
+	s.mu.Lock()
+	s.Commands = 0
+	s.mu.Unlock()
+	return s.snapshot(), nil
+}
+
+// Send 依次执行命令，每条命令的标准输出与错误输出以唯一标记结束，标记行带回退出码与工作目录。
+// 命令的标准输入是 /dev/null，避免读取后续的标记命令；超时或 shell 退出后会话被关闭
+func (s *ShellSession) Send(commands []string, timeout time.Duration) ([]*ShellCommand, error) {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	if timeout <= 0 {
+		timeout = shellSendTimeout
+	}
+
+	results := make([]*ShellCommand, 0, len(commands))
+	for _, command := range commands {
+		s.mu.Lock()
+		closed := s.Status == ShellClosed
+		s.mu.Unlock()
+		if closed {
+			return results, errors.New("Shell session closed: " + s.ID)
+		}
+
+		marker := "__CMDT_" + newTransferID() + "__"
+		script := "{ " + command + "\n} </dev/null\n" +
+			"__cmdt_rc=$?; printf '%s:%d:%s\\n' " + marker + " $__cmdt_rc \"$PWD\"; printf '%s\\n' " + marker + " >&2\n"
+		start := time.Now()
+		if _, err := io.WriteString(s.stdin, script); err != nil {
+			s.fail(err)
+			return results, errors.New("Failed to write to shell: " + err.Error())
+		}
+
+		deadline := time.After(timeout)
+		result := &ShellCommand{Command: command}
+		var tail string
+		var err error
+		result.Stdout, tail, err = s.stdout.readUntil(marker, deadline)
+		if err == nil {
+			result.Stderr, _, err = s.stderr.readUntil(marker, deadline)
+		}
+		result.Duration = float64(time.Since(start).Microseconds()) / 1000
+		if err != nil {
+			// 超时或 shell 退出时返回已经产生的输出
+			result.Stdout, result.Stderr, result.ExitCode = s.stdout.drain(), s.stderr.drain(), -1
+			results = append(results, result)
+			s.fail(err)
+			return results, err
+		}
+		parts := strings.SplitN(strings.TrimPrefix(tail, ":"), ":", 2)
+		result.ExitCode, _ = strconv.Atoi(parts[0])
+		if len(parts) > 1 {
+			result.Cwd = parts[1]
+		}
+		results = append(results, result)
+
+		s.mu.Lock()
+		s.Commands++
+		s.Cwd = result.Cwd
+		s.LastUsed = time.Now()
+		s.mu.Unlock()
+	}
+	return results, nil
+}
+
+// fail 记录错误并关闭会话
+func (s *ShellSession) fail(err error) {
+	s.mu.Lock()
+	s.Error = err.Error()
+	s.mu.Unlock()
+	s.Close()
+}
+
+// Close 结束 shell 并移除会话
+func (s *ShellSession) Close() {
+	s.mu.Lock()
+	if s.Status == ShellClosed {
+		s.mu.Unlock()
+		return
+	}
+	s.Status = ShellClosed
+	s.mu.Unlock()
+	s.closer()
+	shells.remove(s.ID)
+}
+
+// snapshot 返回会话状态快照
+func (s *ShellSession) snapshot() *ShellSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &ShellSession{
+		ID:       s.ID,
+		Type:     s.Type,
+		Host:     s.Host,
+		Shell:    s.Shell,
+		Status:   s.Status,
+		Error:    s.Error,
+		Cwd:      s.Cwd,
+		Commands: s.Commands,
+		OpenedAt: s.OpenedAt,
+		LastUsed: s.LastUsed,
+
+		IdleTimeout: s.IdleTimeout,
+	}
+}
+
+// shellCommands 读取要执行的命令，数组中每项是一条命令，字符串作为一条命令
+func shellCommands(val interface{}) []string {
+	switch data := val.(type) {
+	case []interface{}:
+		return optStrings(map[string]interface{}{"v": data}, "v")
+	case []string:
+		return data
+	case string:
+		if strings.TrimSpace(data) != "" {
+			return []string{data}
+		}
+	}
+	return nil
+}
+
+func (r *shellRegistry) get(id string) (*ShellSession, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.items[id]
+	return s, ok
+}
+
+func (r *shellRegistry) remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.items, id)
+}
+
+// list 返回全部会话的状态快照，按打开时间排序
+func (r *shellRegistry) list() []*ShellSession {
+	r.mu.Lock()
+	items := make([]*ShellSession, 0, len(r.items))
+	for _, s := range r.items {
+		items = append(items, s)
+	}
+	r.mu.Unlock()
+
+	list := make([]*ShellSession, 0, len(items))
+	for _, s := range items {
+		list = append(list, s.snapshot())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].OpenedAt.Before(list[j].OpenedAt) })
+	return list
+}
+
+// reap 定期关闭空闲超时的会话
+func (r *shellRegistry) reap() {
+	for {
+		time.Sleep(shellReapInterval)
+		r.reapIdle()
+	}
+}
+
+// reapIdle 关闭空闲超时的会话，正在执行命令的会话不受影响
+func (r *shellRegistry) reapIdle() {
+	r.mu.Lock()
+	items := make([]*ShellSession, 0, len(r.items))
+	for _, s := range r.items {
+		items = append(items, s)
+	}
+	r.mu.Unlock()
+
+	for _, s := range items {
+		if !s.sendMu.TryLock() {
+			continue
+		}
+		s.mu.Lock()
+		idle := s.IdleTimeout > 0 && time.Since(s.LastUsed) > time.Duration(s.IdleTimeout)*time.Second
+		s.mu.Unlock()
+		if idle {
+			s.fail(errors.New("idle timeout"))
+		}
+		s.sendMu.Unlock()
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestRemoteShellSession(t *testing.T) {
+	server := startTestSSHServer(t)
+	dir := t.TempDir()
+	output := execOutput(t, "shell_open", map[string]interface{}{
+		"host": server.Host, "port": server.Port, "user": testSSHUser, "password": testSSHPassword, "id": "ops",
+	})
+	session := &ShellSession{}
+	json.Unmarshal([]byte(output), session)
+	if session.ID != "ops" || session.Status != ShellOpen || session.Cwd == "" {
+		t.Fatalf("unexpected session %s", output)
+	}
+	t.Cleanup(func() {
+		if item, ok := shells.get("ops"); ok {
+			item.Close()
+		}
+	})
+
+	// cd 与 export 在多次调用之间保留
+	execOutput(t, "shell_send", "ops", []interface{}{"cd " + dir, "export GREETING=hello"})
+	result := &ShellSendResult{}
+	json.Unmarshal([]byte(execOutput(t, "shell_send", "ops", []interface{}{"printf \"$GREETING\"; echo err >&2; false", "pwd; read line; echo \"[$line]\""})), result)
+	if len(result.Results) != 2 {
+		t.Fatalf("unexpected results %+v", result)
+	}
+	first, second := result.Results[0], result.Results[1]
+	if first.Stdout != "hello" || first.Stderr != "err\n" || first.ExitCode != 1 {
+		t.Errorf("unexpected first result %+v", first)
+	}
+	if second.Stdout != dir+"\n[]\n" || second.ExitCode != 0 || second.Cwd != dir {
+		t.Errorf("unexpected second result %+v", second)
+	}
+
+	var list []*ShellSession
+	json.Unmarshal([]byte(execOutput(t, "shell_list")), &list)
+	if len(list) != 1 || list[0].Commands != 4 || list[0].Cwd != dir {
+		t.Errorf("unexpected session list %+v", list)
+	}
+
+	// 超时关闭会话
+	item, _ := shells.get("ops")
+	if _, err := item.Send([]string{"sleep 5"}, 200*time.Millisecond); err != errShellTimeout {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, ok := shells.get("ops"); ok {
+		t.Error("session not removed after timeout")
+	}
+}
+
+func TestRemoteShellClose(t *testing.T) {
+	server := startTestSSHServer(t)
+	output := execOutput(t, "shell_open", map[string]interface{}{
+		"host": server.Host, "port": server.Port, "user": testSSHUser, "password": testSSHPassword, "shell": "sh",
+	})
+	session := &ShellSession{}
+	json.Unmarshal([]byte(output), session)
+	execOutput(t, "shell_close", session.ID)
+	if _, ok := shells.get(session.ID); ok {
+		t.Error("session not removed")
+	}
+}
+
+func TestLocalShellSession(t *testing.T) {
+	dir := t.TempDir()
+	output := execOutput(t, "shell_open", map[string]interface{}{"type": "local", "cwd": dir, "env": map[string]interface{}{"STAGE": "test"}})
+	session := &ShellSession{}
+	json.Unmarshal([]byte(output), session)
+	if session.Type != "local" || session.Cwd != dir || session.IdleTimeout != 1800 {
+		t.Fatalf("unexpected session %s", output)
+	}
+	t.Cleanup(func() {
+		if item, ok := shells.get(session.ID); ok {
+			item.Close()
+		}
+	})
+
+	result := &ShellSendResult{}
+	json.Unmarshal([]byte(execOutput(t, "shell_send", session.ID, []interface{}{"mkdir sub && cd sub", "VALUE=\"$STAGE-1\"", "echo $VALUE; exit_code() { return 3; }; exit_code"})), result)
+	last := result.Results[2]
+	if last.Stdout != "test-1\n" || last.ExitCode != 3 || last.Cwd != dir+"/sub" {
+		t.Errorf("unexpected result %+v", last)
+	}
+
+	// 超时结束整个进程组并关闭会话
+	item, _ := shells.get(session.ID)
+	if _, err := item.Send([]string{"echo started; sleep 30"}, 200*time.Millisecond); err != errShellTimeout {
+		t.Errorf("unexpected error %v", err)
+	}
+	if snap := item.snapshot(); snap.Status != ShellClosed || snap.Error != errShellTimeout.Error() {
+		t.Errorf("unexpected session %+v", snap)
+	}
+
+	if _, err := OpenLocalShell(&ShellOptions{Shell: "fish"}); err == nil {
+		t.Error("expected unsupported shell error")
+	}
+}
+
+func TestShellIdleReap(t *testing.T) {
+	busy, err := OpenLocalShell(&ShellOptions{IdleTimeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	idle, err := OpenLocalShell(&ShellOptions{IdleTimeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keep, err := OpenLocalShell(&ShellOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		item, _ := shells.get(keep.ID)
+		item.Close()
+	}()
+	for _, s := range []*ShellSession{busy, idle, keep} {
+		item, _ := shells.get(s.ID)
+		item.mu.Lock()
+		item.LastUsed = time.Now().Add(-time.Hour)
+		item.mu.Unlock()
+	}
+	item, _ := shells.get(busy.ID)
+	done := make(chan struct{})
+	go func() {
+		item.Send([]string{"sleep 1"}, 5*time.Second)
+		close(done)
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	shells.reapIdle()
+	if _, ok := shells.get(idle.ID); ok {
+		t.Error("idle session not reaped")
+	}
+	if _, ok := shells.get(busy.ID); !ok {
+		t.Error("busy session reaped")
+	}
+	if _, ok := shells.get(keep.ID); !ok {
+		t.Error("session without idle timeout reaped")
+	}
+	<-done
+	item.Close()
+}
@@ -6,8 +6,10 @@ import (
 	"errors"
 	"net"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/sftp"
@@ -52,234 +54,436 @@ func getSShConfig(user string, password string, privateKey string) (*ssh.ClientC
 	}
 	return config, nil
 }
-func SSHCopyFolder(addr string, port string, user string, password string, privateKey string, localFolder, remoteFolder string) error {
+// dialSSH 建立 SSH 连接，端口为空时使用 22，proxy 为空时按环境变量决定是否经代理连接，algorithms 为空时使用默认算法
+func dialSSH(addr string, port string, user string, password string, privateKey string, proxy string, algorithms *SSHAlgorithms) (*ssh.Client, error) {
+	target := &SSHTarget{Host: addr, Port: port, User: user, Password: password, PrivateKey: privateKey, Proxy: proxy, Algorithms: algorithms}
+	return target.Dial()
+}
+
+// SSHTarget 一台主机的 SSH 连接参数
+type SSHTarget struct {
+	Host       string `json:"host"`
+	Port       string `json:"port"`
+	User       string `json:"user"`
+	Password   string `json:"-"`
+	PrivateKey string `json:"-"`
+	Proxy      string `json:"-"` // 上游代理 socks5://、http:// 或 https://，可带用户名密码；direct 表示不使用代理
+
+	Algorithms *SSHAlgorithms `json:"-"` // 覆盖默认的密钥交换、加密、MAC 与主机密钥算法
 
-	lPort := port
-	if lPort == "" {
-		lPort = "22"
+	Timeout time.Duration `json:"-"` // TCP 连接超时，0 表示不限制
+}
+
+// parseSSHTarget 从选项表读取连接参数 {host, port, user, password, private_key, proxy, kex, ciphers, macs, host_key_algorithms}
+func parseSSHTarget(opts map[string]interface{}) *SSHTarget {
+	return &SSHTarget{
+		Host:       optString(opts, "host", ""),
+		Port:       optString(opts, "port", ""),
+		User:       optString(opts, "user", ""),
+		Password:   optString(opts, "password", ""),
+		PrivateKey: optString(opts, "private_key", ""),
+		Proxy:      optString(opts, "proxy", ""),
+		Algorithms: parseSSHAlgorithms(opts),
 	}
-	config, err := getSShConfig(user, password, privateKey)
+}
+
+// Address 返回 host:port，端口为空时使用 22
+func (t *SSHTarget) Address() string {
+	port := t.Port
+	if port == "" {
+		port = "22"
+	}
+	return net.JoinHostPort(t.Host, port)
+}
+
+// Dial 建立到目标主机的 SSH 连接
+func (t *SSHTarget) Dial() (*ssh.Client, error) {
+	config, err := t.clientConfig()
 	if err != nil {
-		return err
+		return nil, err
 	}
+	conn, err := t.dialTCP()
+	if err != nil {
+		return nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, t.Address(), config)
+	if err != nil {
+		conn.Close()
+		return nil, algorithmError(err)
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
 
-	conn, err := ssh.Dial("tcp", net.JoinHostPort(addr, lPort), config)
+// clientConfig 用清单补全连接参数，生成认证与算法配置
+func (t *SSHTarget) clientConfig() (*ssh.ClientConfig, error) {
+	if t.Host == "" {
+		return nil, errors.New("missing host")
+	}
+	if err := t.resolveInventory(); err != nil {
+		return nil, err
+	}
+	config, err := getSShConfig(t.User, t.Password, t.PrivateKey)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer conn.Close()
+	config.Timeout = t.Timeout
+	t.Algorithms.apply(config)
+	return config, nil
+}
 
-	// open an SFTP session over an existing ssh connection.
+// dialTCP 建立到目标主机的 TCP 连接，需要时经过上游代理
+func (t *SSHTarget) dialTCP() (net.Conn, error) {
+	proxyURL, err := sshProxyURL(t.Proxy, t.Host)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL == nil {
+		return net.DialTimeout("tcp", t.Address(), t.Timeout)
+	}
+	return dialThroughProxy(proxyURL, t.Address(), t.Timeout)
+}
+
+// Run 在目标主机上执行一条命令，timeout 覆盖连接与执行的全过程，
+// 命令以非零状态退出时 exitCode 为远程的退出码
+func (t *SSHTarget) Run(cmd string, timeout time.Duration) (stdout string, stderr string, exitCode int, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var b bytes.Buffer
+	var er bytes.Buffer
+	var client *ssh.Client
+	var canceled bool
+	var lock sync.Mutex
+	done := make(chan error, 1)
+	go func() {
+		conn, err := t.Dial()
+		if err != nil {
+			done <- err
+			return
+		}
+		lock.Lock()
+		if canceled {
+			lock.Unlock()
+			conn.Close()
+			return
+		}
+		client = conn
+		lock.Unlock()
+		defer conn.Close()
+		session, err := conn.NewSession()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer session.Close()
+		session.Stdout = &b
+		session.Stderr = &er
+		done <- session.Run(cmd)
+	}()
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		// 关闭连接让远程会话结束；会话已开始时等待协程退出后再读取输出
+		lock.Lock()
+		canceled = true
+		started := client != nil
+		if started {
+			client.Close()
+		}
+		lock.Unlock()
+		if started {
+			<-done
+		}
+		err = errors.New("timeout reached, SSH session canceled")
+	}
+
+	exitCode = 0
+	if exitErr, ok := err.(*ssh.ExitError); ok {
+		exitCode = exitErr.ExitStatus()
+	} else if err != nil {
+		exitCode = -1
+	}
+	return b.String(), er.String(), exitCode, err
+}
+
+// runSession 在已建立的连接上执行一条命令，返回标准输出与标准错误，失败时错误中带上标准错误
+func runSession(conn *ssh.Client, cmd string) (string, string, error) {
+	session, err := conn.NewSession()
+	if err != nil {
+		return "", "", err
+	}
+	defer session.Close()
+	var b bytes.Buffer
+	var er bytes.Buffer
+	session.Stdout = &b
+	session.Stderr = &er
+	err = session.Run(cmd)
+	if err != nil && er.Len() > 0 {
+		err = errors.New(strings.TrimSpace(er.String()))
+	}
+	return b.String(), er.String(), err
+}
+
+// newSFTPClient 打开 SFTP 会话，失败时返回 nil，由调用方改用 SCP 协议
+func newSFTPClient(conn *ssh.Client) *sftp.Client {
 	client, err := sftp.NewClient(conn)
 	if err != nil {
-		return err
+		return nil
+	}
+	return client
+}
+
+func SSHCopyFolder(addr string, port string, user string, password string, privateKey string, localFolder, remoteFolder string, opts *TransferOptions) (*TransferResult, error) {
+
+	conn, err := dialSSH(addr, port, user, password, privateKey, opts.Proxy, opts.Algorithms)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	entries, skipped, err := planFolderCopy(localFolder, opts)
+	if err != nil {
+		return nil, errors.New("Failed to copy local folder to remote folder: " + err.Error())
+	}
+	// 预先统计文件数与总字节数，用于进度计算
+	for _, entry := range entries {
+		if !entry.isDir {
+			opts.Progress.addTotal(entry.size, 1)
+		}
+	}
+
+	// open an SFTP session over an existing ssh connection.
+	client := newSFTPClient(conn)
+	if client == nil {
+		result, err := scpUploadFolder(conn, entries, remoteFolder, opts)
+		result.Protocol = ProtocolSCP
+		result.Skipped = skipped
+		if err == nil && opts.Verify {
+			verifyTransferResult(newRemoteHasher(conn, nil, opts.VerifyMethod), result)
+		}
+		return result, err
 	}
 	defer client.Close()
+
 	// Create remote folder if it does not exist
 	_, err = client.Stat(remoteFolder)
 	if err != nil {
 		if os.IsNotExist(err) {
 			err = client.MkdirAll(remoteFolder)
 			if err != nil {
-				return errors.New("Failed to create remote folder: " + err.Error())
+				return nil, errors.New("Failed to create remote folder: " + err.Error())
 			}
 		} else {
-			return errors.New("Failed to stat remote folder: " + err.Error())
+			return nil, errors.New("Failed to stat remote folder: " + err.Error())
 		}
 	}
 
-	// Copy local folder to remote folder
-	err = filepath.Walk(localFolder, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return errors.New("Error Occurs: " + path + " " + err.Error())
+	result := &TransferResult{Protocol: ProtocolSFTP, Files: []*TransferFile{}, Skipped: skipped}
+	created := map[string]bool{remoteFolder: true}
+	// 按需创建远程目录，设置 include 时不创建没有匹配文件的空目录
+	ensureDir := func(remotePath string) error {
+		if created[remotePath] {
+			return nil
 		}
-
-		relPath, err := filepath.Rel(localFolder, path)
+		_, err := client.Stat(remotePath)
 		if err != nil {
-			return errors.New("Failed to Get File Relation Path: " + err.Error())
+			if !os.IsNotExist(err) {
+				return errors.New("Failed to stat remote folder: " + remotePath + " " + err.Error())
+			}
+			if err = client.MkdirAll(remotePath); err != nil {
+				return errors.New("Failed to create remote folder: " + remotePath + " " + err.Error())
+			}
 		}
+		created[remotePath] = true
+		return nil
+	}
 
-		remotePath := filepath.Join(remoteFolder, relPath)
-
-		if info.IsDir() {
-			_, err = client.Stat(remotePath)
-			if err != nil {
-				if os.IsNotExist(err) {
-					err = client.MkdirAll(remotePath)
-					if err != nil {
-						return errors.New("Failed to create remote folder: " + remotePath + " " + err.Error())
-					}
-				} else {
-					return errors.New("Failed to stat remote folder: " + remotePath + " " + err.Error())
+	// Copy local folder to remote folder
+	for _, entry := range entries {
+		remotePath := path.Join(remoteFolder, entry.rel)
+		if entry.isDir {
+			if len(opts.Include) == 0 {
+				if err = ensureDir(remotePath); err != nil {
+					break
 				}
 			}
-			return nil
+			continue
 		}
-
-		file, err := os.ReadFile(path)
-		if err != nil {
-			return errors.New("Failed to Read File: " + path + " " + err.Error())
+		if err = ensureDir(path.Dir(remotePath)); err != nil {
+			break
 		}
 
-		dst, err := client.Create(remotePath)
-		if err != nil {
-			return errors.New("Failed to Create Remote File: " + remotePath + " " + err.Error())
+		file, uploadErr := uploadFile(client, entry.path, remotePath, opts)
+		if file != nil {
+			result.add(file)
 		}
-
-		_, err = dst.Write(file)
-		if err != nil {
-			return errors.New("Failed to Write Remote File: " + err.Error())
-
+		if uploadErr != nil {
+			err = errors.New("Failed to Write Remote File: " + remotePath + " " + uploadErr.Error())
+			break
 		}
-
-		return nil
-	})
+	}
 	if err != nil {
-		return errors.New("Failed to copy local folder to remote folder: " + err.Error())
+		return result, errors.New("Failed to copy local folder to remote folder: " + err.Error())
 
 	}
 
-	// Create remote folder if it does not exist
+	if opts.Verify {
+		verifyTransferResult(newRemoteHasher(conn, client, opts.VerifyMethod), result)
+	}
 
-	return nil
+	return result, nil
 }
 
-func SSHCopyFile(addr string, port string, user string, password string, privateKey string, srcPath, dstPath string) error {
+func SSHCopyFile(addr string, port string, user string, password string, privateKey string, srcPath, dstPath string, opts *TransferOptions) (*TransferResult, error) {
 
-	lPort := port
-	if lPort == "" {
-		lPort = "22"
-	}
-	config, err := getSShConfig(user, password, privateKey)
+	client, err := dialSSH(addr, port, user, password, privateKey, opts.Proxy, opts.Algorithms)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer client.Close()
 
-	client, err := ssh.Dial("tcp", net.JoinHostPort(addr, lPort), config)
-	if err != nil {
-		return err
+	if info, err := os.Stat(srcPath); err == nil {
+		opts.Progress.addTotal(info.Size(), 1)
 	}
-	defer client.Close()
+	result := &TransferResult{Protocol: ProtocolSFTP, Files: []*TransferFile{}}
 
 	// open an SFTP session over an existing ssh connection.
-	sftp, err := sftp.NewClient(client)
-	if err != nil {
-		return err
+	var file *TransferFile
+	sftp := newSFTPClient(client)
+	if sftp == nil {
+		// SCP 协议不支持续传，总是完整上传
+		result.Protocol = ProtocolSCP
+		file, err = scpUploadFile(client, srcPath, dstPath, opts)
+	} else {
+		defer sftp.Close()
+		file, err = uploadFile(sftp, srcPath, dstPath, opts)
 	}
-	defer sftp.Close()
+	if file != nil {
+		result.add(file)
+	}
+	if err == nil && opts.Verify {
+		verifyTransferResult(newRemoteHasher(client, sftp, opts.VerifyMethod), result)
+	}
+	return result, err
+}
 
-	// Open the source file
-	srcFile, err := os.Open(srcPath)
+// SSHDownloadFile 下载远程文件到本地
+func SSHDownloadFile(addr string, port string, user string, password string, privateKey string, srcPath, dstPath string, opts *TransferOptions) (*TransferResult, error) {
+
+	client, err := dialSSH(addr, port, user, password, privateKey, opts.Proxy, opts.Algorithms)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer srcFile.Close()
+	defer client.Close()
 
-	// Create the destination file
-	dstFile, err := sftp.Create(dstPath)
-	if err != nil {
-		return err
+	// open an SFTP session over an existing ssh connection.
+	sftp := newSFTPClient(client)
+	if sftp == nil {
+		result, err := scpDownload(client, srcPath, dstPath, false, opts)
+		result.Protocol = ProtocolSCP
+		if err == nil && opts.Verify {
+			verifyTransferResult(newRemoteHasher(client, nil, opts.VerifyMethod), result)
+		}
+		return result, err
 	}
-	defer dstFile.Close()
+	defer sftp.Close()
 
-	// write to file
-	if _, err := dstFile.ReadFrom(srcFile); err != nil {
-		return err
+	if info, err := sftp.Stat(srcPath); err == nil {
+		opts.Progress.addTotal(info.Size(), 1)
+	}
+	result := &TransferResult{Protocol: ProtocolSFTP, Files: []*TransferFile{}}
+	file, err := downloadFile(sftp, srcPath, dstPath, opts)
+	if file != nil {
+		result.add(file)
+	}
+	if err == nil && opts.Verify {
+		verifyTransferResult(newRemoteHasher(client, sftp, opts.VerifyMethod), result)
 	}
-	return nil
+	return result, err
 }
 
-func SSHWriteFile(addr string, port string, user string, password string, privateKey string, data, dstPath string) error {
+// SSHDownloadFolder 递归下载远程目录到本地
+func SSHDownloadFolder(addr string, port string, user string, password string, privateKey string, remoteFolder, localFolder string, opts *TransferOptions) (*TransferResult, error) {
 
-	lPort := port
-	if lPort == "" {
-		lPort = "22"
-	}
-	config, err := getSShConfig(user, password, privateKey)
-	if err != nil {
-		return err
-	}
-	client, err := ssh.Dial("tcp", net.JoinHostPort(addr, lPort), config)
+	client, err := dialSSH(addr, port, user, password, privateKey, opts.Proxy, opts.Algorithms)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer client.Close()
 
 	// open an SFTP session over an existing ssh connection.
-	sftp, err := sftp.NewClient(client)
-	if err != nil {
-		return err
+	sftp := newSFTPClient(client)
+	if sftp == nil {
+		result, err := scpDownload(client, remoteFolder, localFolder, true, opts)
+		result.Protocol = ProtocolSCP
+		if err == nil && opts.Verify {
+			verifyTransferResult(newRemoteHasher(client, nil, opts.VerifyMethod), result)
+		}
+		return result, err
 	}
 	defer sftp.Close()
 
-	// Convert String data to io.Reader
-	srcFile := strings.NewReader(data)
-	if err != nil {
-		return err
-	}
-	// Create the destination file
-	dstFile, err := sftp.Create(dstPath)
-	if err != nil {
-		return err
+	// 预先统计文件数与总字节数，用于进度计算
+	files := make([]string, 0)
+	walker := sftp.Walk(remoteFolder)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return nil, errors.New("Failed to walk remote folder: " + err.Error())
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), remoteFolder), "/")
+		localPath := filepath.Join(localFolder, filepath.FromSlash(rel))
+		if walker.Stat().IsDir() {
+			if err := os.MkdirAll(localPath, 0755); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		files = append(files, walker.Path())
+		opts.Progress.addTotal(walker.Stat().Size(), 1)
 	}
-	defer dstFile.Close()
 
-	// write to file
-	if _, err := dstFile.ReadFrom(srcFile); err != nil {
-		return err
+	result := &TransferResult{Protocol: ProtocolSFTP, Files: []*TransferFile{}}
+	for _, remotePath := range files {
+		rel := strings.TrimPrefix(strings.TrimPrefix(remotePath, remoteFolder), "/")
+		file, err := downloadFile(sftp, remotePath, filepath.Join(localFolder, filepath.FromSlash(rel)), opts)
+		if file != nil {
+			result.add(file)
+		}
+		if err != nil {
+			return result, errors.New("Failed to download remote file: " + remotePath + " " + err.Error())
+		}
+	}
+	if opts.Verify {
+		verifyTransferResult(newRemoteHasher(client, sftp, opts.VerifyMethod), result)
 	}
-	return nil
+	return result, nil
 }
 
-// e.g. output, err := SSHRun("root", "MY_IP", "PRIVATE_KEY", "ls")
-func SSHRun(addr string, port string, user string, password string, privateKey string, cmd string) (string, string, error) {
-	// privateKey could be read from a file, or retrieved from another storage
-	// source, such as the Secret Service / GNOME Keyring
-
-	// Create a context with a timeout of 5 seconds
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+func SSHWriteFile(addr string, port string, user string, password string, privateKey string, data, dstPath string, opts *WriteOptions) (*WriteResult, error) {
 
-	lPort := port
-	if lPort == "" {
-		lPort = "22"
-	}
-	config, err := getSShConfig(user, password, privateKey)
+	client, err := dialSSH(addr, port, user, password, privateKey, opts.Proxy, opts.Algorithms)
 	if err != nil {
-		return "", "", err
-	}
-	// Connect
-	client, err := ssh.Dial("tcp", net.JoinHostPort(addr, lPort), config)
-	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 	defer client.Close()
-	// Create a session. It is one session per command.
-	session, err := client.NewSession()
-	if err != nil {
-		return "", "", err
+
+	// open an SFTP session over an existing ssh connection.
+	sftp := newSFTPClient(client)
+	if sftp == nil {
+		return scpWriteFile(client, data, dstPath, opts)
 	}
-	defer session.Close()
-	var b bytes.Buffer  // import "bytes"
-	var er bytes.Buffer // import "bytes"
+	defer sftp.Close()
 
-	session.Stdout = &b  // get output
-	session.Stderr = &er // get output
-	// Create a channel to signal session completion
-	done := make(chan error, 1)
-	// Run the SSH session in a goroutine
-	go func() {
-		// Run your SSH commands
-		done <- session.Run(cmd)
-	}()
+	return writeRemoteFile(client, sftp, data, dstPath, opts)
+}
 
-	// Wait for either session completion or timeout
-	select {
-	case err = <-done:
-		// fmt.Println("SSH session completed successfully")
-	case <-ctx.Done():
-		err = errors.New("timeout reached, SSH session canceled")
-	}
+// e.g. output, err := SSHRun("root", "MY_IP", "PRIVATE_KEY", "ls")
+func SSHRun(addr string, port string, user string, password string, privateKey string, cmd string) (string, string, error) {
+	// privateKey could be read from a file, or retrieved from another storage
+	// source, such as the Secret Service / GNOME Keyring
 
-	return b.String(), er.String(), err
+	target := &SSHTarget{Host: addr, Port: port, User: user, Password: password, PrivateKey: privateKey}
+	stdout, stderr, _, err := target.Run(cmd, 10*time.Second)
+	return stdout, stderr, err
 }
@@ -0,0 +1,716 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	testSSHUser     = "yao"
+	testSSHPassword = "Abcd1234"
+)
+
+// testSSHServer 测试用的进程内 SSH 服务，支持 exec 与 sftp 子系统
+type testSSHServer struct {
+	Host string
+	Port string
+
+	listener net.Listener
+	config   *ssh.ServerConfig
+	noSFTP   bool
+
+	mu    sync.Mutex
+	conns map[net.Conn]bool
+	shell []string // shell 请求执行的命令，为空时使用 sh
+}
+
+// startTestSSHServer 启动测试用的 SSH 服务，configure 可以修改服务端配置，例如限制协商算法
+func startTestSSHServer(t *testing.T, configure ...func(*ssh.ServerConfig)) *testSSHServer {
+	t.Helper()
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() == testSSHUser && string(password) == testSSHPassword {
+				return nil, nil
+			}
+			return nil, io.EOF
+		},
+	}
+	config.AddHostKey(signer)
+	for _, fn := range configure {
+		fn(config)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	server := &testSSHServer{Host: host, Port: port, listener: listener, config: config}
+	go server.serve()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (s *testSSHServer) Close() {
+	s.listener.Close()
+	s.dropConnections()
+}
+
+// setShell 设置 shell 请求执行的命令，用于模拟网络设备等非标准的登录 shell
+func (s *testSSHServer) setShell(args ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shell = args
+}
+
+// dropConnections 断开所有已建立的连接，模拟网络中断
+func (s *testSSHServer) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *testSSHServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handleConn(conn)
+	}
+}
+
+func (s *testSSHServer) handleConn(nConn net.Conn) {
+	s.mu.Lock()
+	if s.conns == nil {
+		s.conns = map[net.Conn]bool{}
+	}
+	s.conns[nConn] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, nConn)
+		s.mu.Unlock()
+	}()
+
+	conn, chans, reqs, err := ssh.NewServerConn(nConn, s.config)
+	if err != nil {
+		nConn.Close()
+		return
+	}
+	defer conn.Close()
+	go s.handleGlobalRequests(conn, reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() == "direct-tcpip" {
+			go handleDirectTCPIP(newChannel)
+			continue
+		}
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go s.handleSession(channel, requests)
+	}
+}
+
+func (s *testSSHServer) handleSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	var pty *ptyRequest
+	for req := range requests {
+		switch req.Type {
+		case "pty-req":
+			pty = &ptyRequest{}
+			req.Reply(ssh.Unmarshal(req.Payload, pty) == nil, nil)
+		case "exec", "shell":
+			s.mu.Lock()
+			shell := append([]string{"sh"}, s.shell...)
+			s.mu.Unlock()
+			cmd := exec.Command(shell[0], shell[1:]...)
+			if req.Type == "exec" {
+				cmd = exec.Command("sh", "-c", parseSSHString(req.Payload))
+			}
+			req.Reply(true, nil)
+			if pty != nil {
+				cmd.Env = append(os.Environ(), "TERM="+pty.Term)
+				master, err := startPTY(cmd, int(pty.Rows), int(pty.Cols))
+				if err != nil {
+					sendExitStatus(channel, err)
+					return
+				}
+				go io.Copy(master, channel)
+				io.Copy(channel, master)
+				master.Close()
+				sendExitStatus(channel, cmd.Wait())
+				return
+			}
+			cmd.Stdout = channel
+			cmd.Stderr = channel.Stderr()
+			// 与 sshd 一致，进程退出即结束会话，不等待客户端关闭标准输入
+			stdin, err := cmd.StdinPipe()
+			if err == nil {
+				go func() {
+					io.Copy(stdin, channel)
+					stdin.Close()
+				}()
+			}
+			sendExitStatus(channel, cmd.Run())
+			return
+		case "subsystem":
+			if parseSSHString(req.Payload) != "sftp" || s.noSFTP {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			server, err := sftp.NewServer(channel)
+			if err != nil {
+				return
+			}
+			server.Serve()
+			server.Close()
+			return
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+// ptyRequest pty-req 请求的内容
+type ptyRequest struct {
+	Term   string
+	Cols   uint32
+	Rows   uint32
+	Width  uint32
+	Height uint32
+	Modes  string
+}
+
+// tcpipAddr direct-tcpip 与 forwarded-tcpip 通道的地址信息
+type tcpipAddr struct {
+	Host       string
+	Port       uint32
+	OriginHost string
+	OriginPort uint32
+}
+
+// handleDirectTCPIP 处理客户端的 -L 转发
+func handleDirectTCPIP(newChannel ssh.NewChannel) {
+	addr := tcpipAddr{}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &addr); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	target, err := net.Dial("tcp", net.JoinHostPort(addr.Host, strconv.Itoa(int(addr.Port))))
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		target.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	go func() {
+		io.Copy(channel, target)
+		channel.CloseWrite()
+	}()
+	io.Copy(target, channel)
+	target.Close()
+}
+
+// handleGlobalRequests 处理客户端的 -R 转发请求
+func (s *testSSHServer) handleGlobalRequests(conn *ssh.ServerConn, reqs <-chan *ssh.Request) {
+	for req := range reqs {
+		if req.Type != "tcpip-forward" {
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+			continue
+		}
+		var bind struct {
+			Host string
+			Port uint32
+		}
+		if err := ssh.Unmarshal(req.Payload, &bind); err != nil {
+			req.Reply(false, nil)
+			continue
+		}
+		listener, err := net.Listen("tcp", net.JoinHostPort(bind.Host, strconv.Itoa(int(bind.Port))))
+		if err != nil {
+			req.Reply(false, nil)
+			continue
+		}
+		port := uint32(listener.Addr().(*net.TCPAddr).Port)
+		req.Reply(true, ssh.Marshal(struct{ Port uint32 }{port}))
+		go func() {
+			conn.Wait()
+			listener.Close()
+		}()
+		go func() {
+			for {
+				accepted, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					origin := accepted.RemoteAddr().(*net.TCPAddr)
+					channel, requests, err := conn.OpenChannel("forwarded-tcpip", ssh.Marshal(tcpipAddr{Host: bind.Host, Port: port, OriginHost: origin.IP.String(), OriginPort: uint32(origin.Port)}))
+					if err != nil {
+						accepted.Close()
+						return
+					}
+					go ssh.DiscardRequests(requests)
+					go func() {
+						io.Copy(channel, accepted)
+						channel.CloseWrite()
+					}()
+					io.Copy(accepted, channel)
+					accepted.Close()
+				}()
+			}
+		}()
+	}
+}
+
+func parseSSHString(payload []byte) string {
+	if len(payload) < 4 {
+		return ""
+	}
+	size := binary.BigEndian.Uint32(payload)
+	if int(size) > len(payload)-4 {
+		return ""
+	}
+	return string(payload[4 : 4+size])
+}
+
+func sendExitStatus(channel ssh.Channel, err error) {
+	status := uint32(0)
+	if err != nil {
+		status = 1
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			status = uint32(exitErr.ExitCode())
+		}
+	}
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, status)
+	channel.SendRequest("exit-status", false, payload)
+}
+
+func writeTestFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestSSHRunLocalServer(t *testing.T) {
+	server := startTestSSHServer(t)
+	out, _, err := SSHRun(server.Host, server.Port, testSSHUser, testSSHPassword, "", "echo hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(out) != "hello" {
+		t.Errorf("unexpected output %q", out)
+	}
+}
+
+func TestSSHCopyFileResume(t *testing.T) {
+	server := startTestSSHServer(t)
+	dir := t.TempDir()
+	local := filepath.Join(dir, "dump.sql")
+	remote := filepath.Join(dir, "remote.sql")
+	content := strings.Repeat("0123456789", 100000)
+	writeTestFile(t, local, content)
+	// 模拟上一次中断后留下的半截文件
+	writeTestFile(t, remote, content[:123456])
+
+	result, err := SSHCopyFile(server.Host, server.Port, testSSHUser, testSSHPassword, "", local, remote, &TransferOptions{Resume: true, Verify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := result.Files[0]
+	if file.Offset != 123456 || file.Bytes != int64(len(content)-123456) || !file.Verified {
+		t.Errorf("unexpected transfer result %+v", file)
+	}
+	if readTestFile(t, remote) != content {
+		t.Error("remote content mismatch")
+	}
+}
+
+func TestSSHDownloadFileResume(t *testing.T) {
+	server := startTestSSHServer(t)
+	dir := t.TempDir()
+	remote := filepath.Join(dir, "remote.bin")
+	local := filepath.Join(dir, "local.bin")
+	content := strings.Repeat("abcdef", 50000)
+	writeTestFile(t, remote, content)
+	writeTestFile(t, local, content[:1000])
+
+	result, err := SSHDownloadFile(server.Host, server.Port, testSSHUser, testSSHPassword, "", remote, local, &TransferOptions{Resume: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Files[0].Offset != 1000 {
+		t.Errorf("expected resume from 1000, got %d", result.Files[0].Offset)
+	}
+	if readTestFile(t, local) != content {
+		t.Error("local content mismatch")
+	}
+}
+
+func TestSSHCopyFolderStream(t *testing.T) {
+	server := startTestSSHServer(t)
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "src", "a.txt"), "a")
+	writeTestFile(t, filepath.Join(dir, "src", "sub", "b.txt"), "bb")
+
+	result, err := SSHCopyFolder(server.Host, server.Port, testSSHUser, testSSHPassword, "", filepath.Join(dir, "src"), filepath.Join(dir, "dst"), &TransferOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Files) != 2 || result.Bytes != 3 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if readTestFile(t, filepath.Join(dir, "dst", "sub", "b.txt")) != "bb" {
+		t.Error("remote content mismatch")
+	}
+}
+
+func TestTransferProgress(t *testing.T) {
+	server := startTestSSHServer(t)
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "src", "a.txt"), strings.Repeat("a", 4096))
+	writeTestFile(t, filepath.Join(dir, "src", "b.txt"), strings.Repeat("b", 1024))
+
+	plugin := &CmdPlugin{}
+	plugin.setLogFile()
+	res, err := plugin.Exec("remote_copy_folder", server.Host, server.Port, testSSHUser, testSSHPassword, filepath.Join(dir, "src"), filepath.Join(dir, "dst"), map[string]interface{}{"id": "job-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := res.MustMap().Get("status"); status != float64(0) {
+		t.Fatalf("unexpected status %v: %v", status, res.MustMap().Get("msg"))
+	}
+
+	progress, ok := transferProgress.get("job-1")
+	if !ok {
+		t.Fatal("progress not registered")
+	}
+	if progress.Status != "done" || progress.Bytes != 5120 || progress.TotalBytes != 5120 || progress.Files != 2 || progress.TotalFiles != 2 {
+		t.Errorf("unexpected progress %+v", progress)
+	}
+
+	// 续传跳过的字节计入百分比，不计入速率
+	resumed := &TransferProgress{Status: "running", TotalBytes: 2000, StartedAt: time.Now().Add(-time.Second)}
+	resumed.beginFile("big.bin", 1000)
+	resumed.addBytes(100)
+	if snap := resumed.snapshot(); snap.Percent != 55 || snap.ResumedBytes != 1000 || snap.Speed > 101 || snap.Speed < 50 {
+		t.Errorf("unexpected resumed progress %+v", snap)
+	}
+}
+
+func TestVerifyTransferResult(t *testing.T) {
+	server := startTestSSHServer(t)
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.local"), "same")
+	writeTestFile(t, filepath.Join(dir, "a.remote"), "same")
+	writeTestFile(t, filepath.Join(dir, "b.local"), "local")
+	writeTestFile(t, filepath.Join(dir, "b.remote"), "remote")
+	writeTestFile(t, filepath.Join(dir, "c.local"), "local")
+	writeTestFile(t, filepath.Join(dir, "d.local"), "local")
+	writeTestFile(t, filepath.Join(dir, "d.remote"), "changed")
+
+	conn, err := dialSSH(server.Host, server.Port, testSSHUser, testSSHPassword, "", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for _, method := range []string{VerifySHA256Sum, VerifySFTP} {
+		result := &TransferResult{}
+		// c.remote 不存在，校验失败后继续校验 d
+		for _, name := range []string{"a", "b", "c", "d"} {
+			result.add(&TransferFile{
+				Target:     name + ".remote",
+				localPath:  filepath.Join(dir, name+".local"),
+				remotePath: filepath.Join(dir, name+".remote"),
+			})
+		}
+		verifyTransferResult(newRemoteHasher(conn, client, method), result)
+		if result.Verified {
+			t.Fatalf("%s: expected mismatch", method)
+		}
+		if !reflect.DeepEqual(result.Mismatches, []string{"b.remote", "d.remote"}) {
+			t.Errorf("%s: unexpected mismatches %v", method, result.Mismatches)
+		}
+		if !result.Files[0].Verified || result.Files[2].Verified || result.Files[2].VerifyError == "" || result.Files[3].VerifyError != "" {
+			t.Errorf("%s: unexpected files %+v %+v", method, result.Files[2], result.Files[3])
+		}
+		if result.Files[0].VerifyMethod != method {
+			t.Errorf("expected verify method %s, got %s", method, result.Files[0].VerifyMethod)
+		}
+	}
+
+	// 单个文件无法读取时其余文件仍用 sha256sum 校验
+	result := &TransferResult{}
+	for _, name := range []string{"c", "d"} {
+		result.add(&TransferFile{Target: name + ".remote", localPath: filepath.Join(dir, name+".local"), remotePath: filepath.Join(dir, name+".remote")})
+	}
+	verifyTransferResult(newRemoteHasher(conn, client, VerifyAuto), result)
+	if result.Files[0].VerifyError == "" || result.Files[1].VerifyMethod != VerifySHA256Sum {
+		t.Errorf("unexpected files %+v %+v", result.Files[0], result.Files[1])
+	}
+}
+
+func TestSSHWriteFileOptions(t *testing.T) {
+	server := startTestSSHServer(t)
+	dir := t.TempDir()
+	target := filepath.Join(dir, "app.conf")
+	writeTestFile(t, target, "old\n")
+
+	opts := &WriteOptions{Atomic: true, Append: true, Backup: ".bak", Mode: 0600}
+	result, err := SSHWriteFile(server.Host, server.Port, testSSHUser, testSSHPassword, "", "new\n", target, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, target); got != "old\nnew\n" {
+		t.Errorf("unexpected content %q", got)
+	}
+	if got := readTestFile(t, result.Backup); got != "old\n" {
+		t.Errorf("unexpected backup %q", got)
+	}
+	info, err := os.Stat(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("unexpected mode %v", info.Mode())
+	}
+	// 原子写入不能留下临时文件
+	if matches, _ := filepath.Glob(filepath.Join(dir, ".app.conf.tmp-*")); len(matches) != 0 {
+		t.Errorf("temp files left: %v", matches)
+	}
+
+	binary := filepath.Join(dir, "data.bin")
+	opts, err = parseWriteOptions(map[string]interface{}{"encoding": "base64", "atomic": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SSHWriteFile(server.Host, server.Port, testSSHUser, testSSHPassword, "", "AAECAw==", binary, opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, binary); got != "\x00\x01\x02\x03" {
+		t.Errorf("unexpected binary content %q", got)
+	}
+}
+
+func TestSCPFallback(t *testing.T) {
+	server := startTestSSHServer(t)
+	server.noSFTP = true
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "src", "a.txt"), "a")
+	writeTestFile(t, filepath.Join(dir, "src", "sub", "deep", "b.txt"), "bb")
+	writeTestFile(t, filepath.Join(dir, "src", "sub", "c.log"), "c")
+	writeTestFile(t, filepath.Join(dir, "src", "z.txt"), "z")
+
+	opts := &TransferOptions{Exclude: []string{"*.log"}, Verify: true, VerifyMethod: VerifyAuto}
+	result, err := SSHCopyFolder(server.Host, server.Port, testSSHUser, testSSHPassword, "", filepath.Join(dir, "src"), filepath.Join(dir, "dst"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Protocol != ProtocolSCP || len(result.Files) != 3 || !result.Verified {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if readTestFile(t, filepath.Join(dir, "dst", "sub", "deep", "b.txt")) != "bb" || readTestFile(t, filepath.Join(dir, "dst", "z.txt")) != "z" {
+		t.Error("remote content mismatch")
+	}
+
+	download, err := SSHDownloadFolder(server.Host, server.Port, testSSHUser, testSSHPassword, "", filepath.Join(dir, "dst"), filepath.Join(dir, "back"), &TransferOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if download.Protocol != ProtocolSCP || len(download.Files) != 3 {
+		t.Fatalf("unexpected download result %+v", download)
+	}
+	if readTestFile(t, filepath.Join(dir, "back", "sub", "deep", "b.txt")) != "bb" {
+		t.Error("downloaded content mismatch")
+	}
+
+	if _, err := SSHCopyFile(server.Host, server.Port, testSSHUser, testSSHPassword, "", filepath.Join(dir, "src", "a.txt"), filepath.Join(dir, "single.txt"), &TransferOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SSHDownloadFile(server.Host, server.Port, testSSHUser, testSSHPassword, "", filepath.Join(dir, "single.txt"), filepath.Join(dir, "single.back"), &TransferOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if readTestFile(t, filepath.Join(dir, "single.back")) != "a" {
+		t.Error("single file content mismatch")
+	}
+
+	target := filepath.Join(dir, "app.conf")
+	writeTestFile(t, target, "old\n")
+	if _, err := SSHWriteFile(server.Host, server.Port, testSSHUser, testSSHPassword, "", "new\n", target, &WriteOptions{Atomic: true, Append: true, Backup: ".bak", Mode: 0600}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, target); got != "old\nnew\n" {
+		t.Errorf("unexpected content %q", got)
+	}
+	if got := readTestFile(t, target+".bak"); got != "old\n" {
+		t.Errorf("unexpected backup %q", got)
+	}
+	if info, _ := os.Stat(target); info.Mode().Perm() != 0600 {
+		t.Errorf("unexpected mode %v", info.Mode())
+	}
+
+	// 目标不存在时追加按普通的原子写入处理
+	created := filepath.Join(dir, "created.conf")
+	if _, err := SSHWriteFile(server.Host, server.Port, testSSHUser, testSSHPassword, "", "first\n", created, &WriteOptions{Atomic: true, Append: true, Mode: 0640}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, created); got != "first\n" {
+		t.Errorf("unexpected content %q", got)
+	}
+	if info, _ := os.Stat(created); info.Mode().Perm() != 0640 {
+		t.Errorf("unexpected mode %v", info.Mode())
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, ".created.conf.tmp-*")); len(matches) != 0 {
+		t.Errorf("temp files left %v", matches)
+	}
+}
+
+func TestSSHRemoteTransfer(t *testing.T) {
+	source := startTestSSHServer(t)
+	target := startTestSSHServer(t)
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "src", "a.txt"), strings.Repeat("a", 70000))
+	writeTestFile(t, filepath.Join(dir, "src", "sub", "b.txt"), "b")
+	writeTestFile(t, filepath.Join(dir, "src", ".git", "HEAD"), "ref")
+	// 目标端已有部分内容，续传
+	writeTestFile(t, filepath.Join(dir, "dst", "a.txt"), strings.Repeat("a", 1000))
+
+	plugin := &CmdPlugin{}
+	plugin.setLogFile()
+	res, err := plugin.Exec("remote_transfer",
+		map[string]interface{}{"host": source.Host, "port": source.Port, "user": testSSHUser, "password": testSSHPassword, "path": filepath.Join(dir, "src")},
+		map[string]interface{}{"host": target.Host, "port": target.Port, "user": testSSHUser, "password": testSSHPassword, "path": filepath.Join(dir, "dst")},
+		map[string]interface{}{"resume": true, "verify": true, "exclude": []interface{}{".git/"}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := res.MustMap()
+	if m.Get("status") != float64(0) {
+		t.Fatalf("unexpected status %v: %v", m.Get("status"), m.Get("msg"))
+	}
+	output := m.Get("data").(map[string]interface{})["output"].(string)
+	if !strings.Contains(output, `"skipped":[".git/"]`) || !strings.Contains(output, `"offset":1000`) || !strings.Contains(output, `"verified":true`) {
+		t.Errorf("unexpected output %s", output)
+	}
+	if readTestFile(t, filepath.Join(dir, "dst", "a.txt")) != strings.Repeat("a", 70000) || readTestFile(t, filepath.Join(dir, "dst", "sub", "b.txt")) != "b" {
+		t.Error("target content mismatch")
+	}
+}
+
+func TestRemoteMulti(t *testing.T) {
+	first := startTestSSHServer(t)
+	second := startTestSSHServer(t)
+	hosts := []interface{}{net.JoinHostPort(first.Host, first.Port), net.JoinHostPort(second.Host, second.Port)}
+
+	plugin := &CmdPlugin{}
+	plugin.setLogFile()
+	res, err := plugin.Exec("remote_multi", hosts, "22", testSSHUser, testSSHPassword, "echo hello", map[string]interface{}{"concurrency": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := res.MustMap()
+	if m.Get("status") != float64(0) {
+		t.Fatalf("unexpected status %v: %v", m.Get("status"), m.Get("msg"))
+	}
+	output := m.Get("data").(map[string]interface{})["output"].(string)
+	if strings.Count(output, `"stdout":"hello\n"`) != 2 || !strings.Contains(output, `"succeeded":2`) {
+		t.Errorf("unexpected output %s", output)
+	}
+
+	// 第一台主机失败后，剩余主机被跳过
+	targets := multiTargets([]string{hosts[0].(string), hosts[1].(string)}, "22", testSSHUser, testSSHPassword, "")
+	result := SSHRunMulti(targets, "exit 3", &MultiOptions{Concurrency: 1, Timeout: 5 * time.Second, StopOnFailure: true})
+	if result.Summary.Failed != 1 || result.Summary.Skipped != 1 || result.Results[0].ExitCode != 3 || !result.Results[1].Skipped {
+		t.Errorf("unexpected result %+v %+v", result.Summary, result.Results[0])
+	}
+}
+
+func TestRemoteScript(t *testing.T) {
+	server := startTestSSHServer(t)
+	dir := t.TempDir()
+	script := "#!/bin/sh\nset -e\ncd " + dir + "\necho \"{{ greeting }} $1 $2\"\necho warn >&2\nexit 4\n"
+
+	output := execOutput(t, "remote_script", server.Host, server.Port, testSSHUser, testSSHPassword, script, map[string]interface{}{
+		"args": []interface{}{"a b", "c"}, "vars": map[string]interface{}{"greeting": "hello"}, "tmp_dir": dir,
+	})
+	if !strings.Contains(output, `"exit_code":4`) || !strings.Contains(output, `"stdout":"hello a b c\n"`) || !strings.Contains(output, `"stderr":"warn\n"`) || !strings.Contains(output, `"interpreter":"/bin/sh"`) {
+		t.Errorf("unexpected output %s", output)
+	}
+	// 执行后删除临时脚本
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("script not removed: %v", entries)
+	}
+
+	// 本地脚本文件
+	file := filepath.Join(dir, "local.py")
+	writeTestFile(t, file, "echo from file")
+	output = execOutput(t, "remote_script", server.Host, server.Port, testSSHUser, testSSHPassword, file, map[string]interface{}{"interpreter": "bash"})
+	if !strings.Contains(output, `"stdout":"from file\n"`) {
+		t.Errorf("unexpected output %s", output)
+	}
+
+	// 没有 vars 时不替换模板
+	output = execOutput(t, "remote_script", server.Host, server.Port, testSSHUser, testSSHPassword, "echo '{{ .Values.name }} {{ name }}'", map[string]interface{}{})
+	if !strings.Contains(output, `"stdout":"{{ .Values.name }} {{ name }}\n"`) {
+		t.Errorf("unexpected output %s", output)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import "time"
+
+// SystemInfo 插件所在主机的资源使用情况
+type SystemInfo struct {
+	Hostname      string            `json:"hostname"`
+	Kernel        string            `json:"kernel"`
+	Arch          string            `json:"arch"`
+	CPUs          int               `json:"cpus"`
+	CPU           *CPUUsage         `json:"cpu"`
+	Load          *LoadAverage      `json:"load"`
+	Memory        *MemoryUsage      `json:"memory"`
+	Mounts        []*MountFacts     `json:"mounts"`
+	Interfaces    []*InterfaceStats `json:"interfaces"`
+	UptimeSeconds float64           `json:"uptime_seconds"`
+	BootTime      int64             `json:"boot_time"` // Unix 时间戳，单位为秒
+}
+
+// CPUUsage 采样间隔内各类 CPU 时间的占比，单位为百分比；间隔为 0 时是开机以来的平均值
+type CPUUsage struct {
+	UsagePercent  float64 `json:"usage_percent"`
+	UserPercent   float64 `json:"user_percent"`
+	SystemPercent float64 `json:"system_percent"`
+	IOWaitPercent float64 `json:"iowait_percent"`
+	StealPercent  float64 `json:"steal_percent"`
+	IdlePercent   float64 `json:"idle_percent"`
+}
+
+// LoadAverage 系统平均负载与进程数
+type LoadAverage struct {
+	Load1        float64 `json:"load1"`
+	Load5        float64 `json:"load5"`
+	Load15       float64 `json:"load15"`
+	RunningProcs int     `json:"running_procs"`
+	TotalProcs   int     `json:"total_procs"`
+}
+
+// MemoryUsage 内存与交换分区，在 MemoryFacts 的基础上给出已用量
+type MemoryUsage struct {
+	*MemoryFacts
+	UsedBytes     uint64  `json:"used_bytes"` // 总量减去可用量
+	UsedPercent   float64 `json:"used_percent"`
+	SwapUsedBytes uint64  `json:"swap_used_bytes"`
+}
+
+// InterfaceStats 网络接口开机以来的累计计数
+type InterfaceStats struct {
+	Name      string `json:"name"`
+	RxBytes   uint64 `json:"rx_bytes"`
+	RxPackets uint64 `json:"rx_packets"`
+	RxErrors  uint64 `json:"rx_errors"`
+	RxDropped uint64 `json:"rx_dropped"`
+	TxBytes   uint64 `json:"tx_bytes"`
+	TxPackets uint64 `json:"tx_packets"`
+	TxErrors  uint64 `json:"tx_errors"`
+	TxDropped uint64 `json:"tx_dropped"`
+}
+
+// SysinfoOptions sysinfo 选项
+type SysinfoOptions struct {
+	Interval  time.Duration // 计算 CPU 使用率的采样间隔
+	AllMounts bool          // 包含 tmpfs 等内存文件系统
+	Loopback  bool          // 包含回环接口
+}
+
+// parseSysinfoOptions 读取选项 {interval, all_mounts, loopback}，interval 单位为毫秒，默认 200，为 0 时不等待
+func parseSysinfoOptions(opts map[string]interface{}) *SysinfoOptions {
+	sopts := &SysinfoOptions{
+		Interval:  time.Duration(optInt(opts, "interval", 200)) * time.Millisecond,
+		AllMounts: optBool(opts, "all_mounts", false),
+		Loopback:  optBool(opts, "loopback", false),
+	}
+	if sopts.Interval < 0 {
+		sopts.Interval = 0
+	}
+	return sopts
+}
+
+// percentOf 返回 part 占 total 的百分比，保留两位小数
+func percentOf(part, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(int(float64(part)/float64(total)*10000)) / 100
+}
@@ -0,0 +1,270 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// GetSystemInfo 读取 /proc 与 statfs 取得本机的资源使用情况，不执行任何命令
+func GetSystemInfo(opts *SysinfoOptions) (*SystemInfo, error) {
+	first, err := readCPUTimes()
+	if err != nil {
+		return nil, err
+	}
+	var second []uint64
+	if opts.Interval > 0 {
+		time.Sleep(opts.Interval)
+		if second, err = readCPUTimes(); err != nil {
+			return nil, err
+		}
+	}
+
+	info := &SystemInfo{Arch: runtime.GOARCH, CPUs: runtime.NumCPU(), CPU: cpuUsage(first, second)}
+	info.Hostname, _ = os.Hostname()
+	var uname unix.Utsname
+	if unix.Uname(&uname) == nil {
+		info.Kernel = unix.ByteSliceToString(uname.Release[:])
+	}
+
+	text, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return nil, errors.New("Failed to read load average: " + err.Error())
+	}
+	info.Load = parseLoadAvg(string(text))
+
+	if text, err = os.ReadFile("/proc/meminfo"); err != nil {
+		return nil, errors.New("Failed to read memory info: " + err.Error())
+	}
+	info.Memory = memoryUsage(parseMeminfo(string(text)))
+
+	if text, err = os.ReadFile("/proc/uptime"); err != nil {
+		return nil, errors.New("Failed to read uptime: " + err.Error())
+	}
+	if fields := strings.Fields(string(text)); len(fields) > 0 {
+		info.UptimeSeconds, _ = strconv.ParseFloat(fields[0], 64)
+		info.BootTime = time.Now().Add(-time.Duration(info.UptimeSeconds * float64(time.Second))).Unix()
+	}
+
+	if text, err = os.ReadFile("/proc/self/mounts"); err != nil {
+		return nil, errors.New("Failed to read mounts: " + err.Error())
+	}
+	info.Mounts = statMounts(parseProcMounts(string(text)), opts.AllMounts)
+
+	if text, err = os.ReadFile("/proc/net/dev"); err != nil {
+		return nil, errors.New("Failed to read network interfaces: " + err.Error())
+	}
+	info.Interfaces = make([]*InterfaceStats, 0)
+	for _, iface := range parseNetDev(string(text)) {
+		if iface.Name != "lo" || opts.Loopback {
+			info.Interfaces = append(info.Interfaces, iface)
+		}
+	}
+	return info, nil
+}
+
+// readCPUTimes 读取 /proc/stat 中所有 CPU 的累计时间：user nice system idle iowait irq softirq steal
+func readCPUTimes() ([]uint64, error) {
+	text, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return nil, errors.New("Failed to read cpu stat: " + err.Error())
+	}
+	times := parseCPUTimes(string(text))
+	if times == nil {
+		return nil, errors.New("Failed to read cpu stat: no cpu line")
+	}
+	return times, nil
+}
+
+// parseCPUTimes 解析 /proc/stat 的 cpu 汇总行，guest 时间已计入 user，不再单独累加
+func parseCPUTimes(text string) []uint64 {
+	for _, line := range strings.Split(text, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 || fields[0] != "cpu" {
+			continue
+		}
+		times := make([]uint64, 8)
+		for i := range times {
+			if i+1 < len(fields) {
+				times[i], _ = strconv.ParseUint(fields[i+1], 10, 64)
+			}
+		}
+		return times
+	}
+	return nil
+}
+
+// cpuUsage 按两次采样的差值计算占比，second 为空时使用开机以来的累计值
+func cpuUsage(first, second []uint64) *CPUUsage {
+	delta := first
+	if second != nil {
+		delta = make([]uint64, len(first))
+		for i := range first {
+			if second[i] > first[i] {
+				delta[i] = second[i] - first[i]
+			}
+		}
+	}
+	var total uint64
+	for _, v := range delta {
+		total += v
+	}
+	idle := delta[3] + delta[4]
+	return &CPUUsage{
+		UsagePercent:  percentOf(total-idle, total),
+		UserPercent:   percentOf(delta[0]+delta[1], total),
+		SystemPercent: percentOf(delta[2]+delta[5]+delta[6], total),
+		IOWaitPercent: percentOf(delta[4], total),
+		StealPercent:  percentOf(delta[7], total),
+		IdlePercent:   percentOf(delta[3], total),
+	}
+}
+
+// parseLoadAvg 解析 /proc/loadavg，例如 0.52 0.58 0.59 2/613 12345
+func parseLoadAvg(text string) *LoadAverage {
+	load := &LoadAverage{}
+	fields := strings.Fields(text)
+	if len(fields) < 4 {
+		return load
+	}
+	load.Load1, _ = strconv.ParseFloat(fields[0], 64)
+	load.Load5, _ = strconv.ParseFloat(fields[1], 64)
+	load.Load15, _ = strconv.ParseFloat(fields[2], 64)
+	if procs := strings.SplitN(fields[3], "/", 2); len(procs) == 2 {
+		load.RunningProcs, _ = strconv.Atoi(procs[0])
+		load.TotalProcs, _ = strconv.Atoi(procs[1])
+	}
+	return load
+}
+
+func memoryUsage(mem *MemoryFacts) *MemoryUsage {
+	if mem == nil {
+		return nil
+	}
+	usage := &MemoryUsage{MemoryFacts: mem}
+	if mem.TotalBytes > mem.AvailableBytes {
+		usage.UsedBytes = mem.TotalBytes - mem.AvailableBytes
+	}
+	usage.UsedPercent = percentOf(usage.UsedBytes, mem.TotalBytes)
+	if mem.SwapTotalBytes > mem.SwapFreeBytes {
+		usage.SwapUsedBytes = mem.SwapTotalBytes - mem.SwapFreeBytes
+	}
+	return usage
+}
+
+// procMount /proc/self/mounts 中的一个挂载点
+type procMount struct {
+	device string
+	mount  string
+	fstype string
+}
+
+// parseProcMounts 解析 /proc/self/mounts，同一挂载点被多次挂载时只保留最后一次，它遮住了之前的挂载
+func parseProcMounts(text string) []*procMount {
+	mounts := make([]*procMount, 0)
+	index := map[string]int{}
+	for _, line := range strings.Split(text, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		mount := &procMount{device: unescapeMountField(fields[0]), mount: unescapeMountField(fields[1]), fstype: fields[2]}
+		if i, ok := index[mount.mount]; ok {
+			mounts[i] = mount
+			continue
+		}
+		index[mount.mount] = len(mounts)
+		mounts = append(mounts, mount)
+	}
+	return mounts
+}
+
+// unescapeMountField 还原挂载信息中以八进制转义的空白与反斜杠，例如 \040
+func unescapeMountField(field string) string {
+	if !strings.Contains(field, `\`) {
+		return field
+	}
+	var sb strings.Builder
+	for i := 0; i < len(field); i++ {
+		if field[i] == '\\' && i+4 <= len(field) {
+			if v, err := strconv.ParseUint(field[i+1:i+4], 8, 8); err == nil {
+				sb.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		sb.WriteByte(field[i])
+	}
+	return sb.String()
+}
+
+// statMounts 用 statfs 取得每个挂载点的容量，容量为 0 的虚拟文件系统总是跳过；
+// 除非 all 为 true，内存文件系统与 /dev、/proc、/sys、/run 下的挂载点也跳过
+func statMounts(mounts []*procMount, all bool) []*MountFacts {
+	result := make([]*MountFacts, 0)
+	for _, mount := range mounts {
+		if !all && (pseudoFilesystems[mount.fstype] || pseudoFilesystems[mount.device] || systemMountPath(mount.mount)) {
+			continue
+		}
+		var st unix.Statfs_t
+		if err := unix.Statfs(mount.mount, &st); err != nil || st.Blocks == 0 {
+			continue
+		}
+		size := uint64(st.Frsize)
+		if size == 0 {
+			size = uint64(st.Bsize)
+		}
+		facts := &MountFacts{
+			Filesystem:     mount.device,
+			Mount:          mount.mount,
+			TotalBytes:     st.Blocks * size,
+			UsedBytes:      (st.Blocks - st.Bfree) * size,
+			AvailableBytes: st.Bavail * size,
+		}
+		// 与 df 一样以已用量加普通用户可用量为分母，不计保留块
+		facts.UsedPercent = percentOf(facts.UsedBytes, facts.UsedBytes+facts.AvailableBytes)
+		result = append(result, facts)
+	}
+	return result
+}
+
+func systemMountPath(path string) bool {
+	for _, prefix := range []string{"/dev", "/proc", "/sys", "/run"} {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseNetDev 解析 /proc/net/dev，接收与发送各 8 列：bytes packets errs drop fifo frame/colls compressed multicast/carrier
+func parseNetDev(text string) []*InterfaceStats {
+	stats := make([]*InterfaceStats, 0)
+	for _, line := range strings.Split(text, "\n") {
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		fields := strings.Fields(line[i+1:])
+		if len(fields) < 16 {
+			continue
+		}
+		values := make([]uint64, 16)
+		for j := range values {
+			values[j], _ = strconv.ParseUint(fields[j], 10, 64)
+		}
+		stats = append(stats, &InterfaceStats{
+			Name:    strings.TrimSpace(line[:i]),
+			RxBytes: values[0], RxPackets: values[1], RxErrors: values[2], RxDropped: values[3],
+			TxBytes: values[8], TxPackets: values[9], TxErrors: values[10], TxDropped: values[11],
+		})
+	}
+	return stats
+}
@@ -0,0 +1,61 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSysinfo(t *testing.T) {
+	info := &SystemInfo{}
+	json.Unmarshal([]byte(execOutput(t, "sysinfo", map[string]interface{}{"interval": 50})), info)
+	if info.Hostname == "" || info.Kernel == "" || info.CPUs <= 0 || info.CPU == nil || info.Load == nil || info.UptimeSeconds <= 0 || info.BootTime <= 0 {
+		t.Fatalf("unexpected info %+v", info)
+	}
+	if info.CPU.UsagePercent < 0 || info.CPU.UsagePercent > 100 {
+		t.Errorf("unexpected cpu usage %+v", info.CPU)
+	}
+	if info.Memory == nil || info.Memory.MemoryFacts == nil || info.Memory.TotalBytes == 0 || info.Memory.UsedBytes > info.Memory.TotalBytes {
+		t.Errorf("unexpected memory %+v", info.Memory)
+	}
+	for _, mount := range info.Mounts {
+		if mount.TotalBytes == 0 || mount.Mount == "/proc" {
+			t.Errorf("unexpected mount %+v", mount)
+		}
+	}
+	for _, iface := range info.Interfaces {
+		if iface.Name == "lo" {
+			t.Errorf("loopback should be skipped")
+		}
+	}
+}
+
+func TestParseProcFiles(t *testing.T) {
+	stat := "cpu  100 0 50 800 40 5 5 0 0 0\ncpu0 100 0 50 800 40 5 5 0 0 0\n"
+	first := parseCPUTimes(stat)
+	second := parseCPUTimes("cpu  160 0 70 900 60 5 5 0 0 0\n")
+	usage := cpuUsage(first, second)
+	if usage.UsagePercent != 40 || usage.UserPercent != 30 || usage.SystemPercent != 10 || usage.IOWaitPercent != 10 || usage.IdlePercent != 50 {
+		t.Errorf("unexpected cpu usage %+v", usage)
+	}
+
+	load := parseLoadAvg("0.52 0.58 0.59 2/613 12345\n")
+	if load.Load1 != 0.52 || load.Load15 != 0.59 || load.RunningProcs != 2 || load.TotalProcs != 613 {
+		t.Errorf("unexpected load %+v", load)
+	}
+
+	mounts := parseProcMounts("/dev/sda1 / ext4 rw 0 0\nproc /proc proc rw 0 0\n/dev/sdb1 /mnt/my\\040disk ext4 rw 0 0\n/dev/sdc1 / xfs rw 0 0\n")
+	if len(mounts) != 3 || mounts[0].device != "/dev/sdc1" || mounts[2].mount != "/mnt/my disk" {
+		t.Errorf("unexpected mounts %+v %+v", mounts[0], mounts[2])
+	}
+
+	ifaces := parseNetDev(`Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:    1000      10    0    0    0     0          0         0     1000      10    0    0    0     0       0          0
+  eth0: 2000000    1500    1    2    0     0          0         0   300000     900    3    4    0     0       0          0
+`)
+	if len(ifaces) != 2 || ifaces[1].Name != "eth0" || ifaces[1].RxBytes != 2000000 || ifaces[1].RxDropped != 2 || ifaces[1].TxPackets != 900 || ifaces[1].TxDropped != 4 {
+		t.Errorf("unexpected interfaces %+v", ifaces)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// GetSystemInfo 目前只支持 Linux
+func GetSystemInfo(opts *SysinfoOptions) (*SystemInfo, error) {
+	return nil, errors.New("sysinfo is not supported on this platform")
+}
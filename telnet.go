@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Telnet 命令与选项，见 RFC 854、RFC 1091、RFC 1073
+const (
+	telnetIAC  = 255
+	telnetDONT = 254
+	telnetDO   = 253
+	telnetWONT = 252
+	telnetWILL = 251
+	telnetSB   = 250
+	telnetSE   = 240
+
+	telnetOptEcho     = 1
+	telnetOptSGA      = 3
+	telnetOptTermType = 24
+	telnetOptNAWS     = 31
+
+	telnetTermIs   = 0
+	telnetTermSend = 1
+)
+
+// TelnetOptions telnet_exec 选项，提示符、分页等与 device_exec 相同
+type TelnetOptions struct {
+	*DeviceOptions
+	LoginPrompt  *regexp.Regexp
+	LoginFailure *regexp.Regexp
+	Proxy        string
+}
+
+// TelnetResult telnet_exec 的执行结果
+type TelnetResult struct {
+	Host     string           `json:"host"`
+	Prompt   string           `json:"prompt"`
+	Commands []*DeviceCommand `json:"commands"`
+}
+
+// parseTelnetOptions 读取 Telnet 选项，行结束符默认 \r\n
+func parseTelnetOptions(opts map[string]interface{}) (*TelnetOptions, error) {
+	if _, ok := opts["newline"]; !ok {
+		opts = mergeVars(opts, map[string]interface{}{"newline": "\r\n"})
+	}
+	dopts, err := parseDeviceOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	topts := &TelnetOptions{DeviceOptions: dopts, Proxy: optString(opts, "proxy", "")}
+	if topts.LoginPrompt, err = regexp.Compile(optString(opts, "login_prompt", `(?i)(login|user ?name)\s*: ?$`)); err != nil {
+		return nil, errors.New("Invalid login prompt: " + err.Error())
+	}
+	if topts.LoginFailure, err = regexp.Compile(optString(opts, "login_failure", `(?i)login incorrect|authentication failed|access denied|invalid (password|login)`)); err != nil {
+		return nil, errors.New("Invalid login failure pattern: " + err.Error())
+	}
+	return topts, nil
+}
+
+// telnetConn 处理 IAC 协商的 Telnet 连接，读取时去掉协议命令，写入时转义 0xFF
+type telnetConn struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	lastCR bool // 上一个数据字节是回车
+	term   string
+	rows   int
+	cols   int
+
+	wmu sync.Mutex
+}
+
+// Read 返回去掉协议命令后的数据，同时应答服务端的选项协商
+func (t *telnetConn) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if n > 0 && t.r.Buffered() == 0 {
+			break
+		}
+		b, err := t.r.ReadByte()
+		if err != nil {
+			return n, err
+		}
+		if b == 0 && t.lastCR {
+			// CR NUL 表示单独的回车，其它位置的 NUL 是数据
+			t.lastCR = false
+			continue
+		}
+		if b != telnetIAC {
+			p[n] = b
+			n++
+			t.lastCR = b == '\r'
+			continue
+		}
+		cmd, err := t.r.ReadByte()
+		if err != nil {
+			return n, err
+		}
+		switch cmd {
+		case telnetIAC:
+			p[n] = telnetIAC
+			n++
+			t.lastCR = false
+		case telnetDO, telnetDONT, telnetWILL, telnetWONT:
+			opt, err := t.r.ReadByte()
+			if err != nil {
+				return n, err
+			}
+			if err := t.negotiate(cmd, opt); err != nil {
+				return n, err
+			}
+		case telnetSB:
+			if err := t.subnegotiate(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// negotiate 同意终端类型、窗口大小、回显与抑制继续，拒绝其它选项；对 DONT/WONT 不应答以免循环
+func (t *telnetConn) negotiate(cmd, opt byte) error {
+	switch cmd {
+	case telnetDO:
+		if opt == telnetOptTermType || opt == telnetOptNAWS || opt == telnetOptSGA {
+			if err := t.command(telnetWILL, opt); err != nil {
+				return err
+			}
+			if opt == telnetOptNAWS {
+				return t.sendNAWS()
+			}
+			return nil
+		}
+		return t.command(telnetWONT, opt)
+	case telnetWILL:
+		if opt == telnetOptEcho || opt == telnetOptSGA {
+			return t.command(telnetDO, opt)
+		}
+		return t.command(telnetDONT, opt)
+	}
+	return nil
+}
+
+// subnegotiate 读取到 IAC SE 为止的子协商，应答终端类型查询
+func (t *telnetConn) subnegotiate() error {
+	data := make([]byte, 0, 8)
+	for {
+		b, err := t.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b == telnetIAC {
+			next, err := t.r.ReadByte()
+			if err != nil {
+				return err
+			}
+			if next == telnetSE {
+				break
+			}
+			b = next
+		}
+		data = append(data, b)
+	}
+	if len(data) >= 2 && data[0] == telnetOptTermType && data[1] == telnetTermSend {
+		reply := append([]byte{telnetIAC, telnetSB, telnetOptTermType, telnetTermIs}, []byte(strings.ToUpper(t.term))...)
+		return t.writeRaw(append(reply, telnetIAC, telnetSE))
+	}
+	return nil
+}
+
+// sendNAWS 发送窗口大小
+func (t *telnetConn) sendNAWS() error {
+	data := []byte{telnetIAC, telnetSB, telnetOptNAWS}
+	for _, v := range []int{t.cols, t.rows} {
+		for _, b := range []byte{byte(v >> 8), byte(v)} {
+			data = append(data, b)
+			if b == telnetIAC {
+				data = append(data, telnetIAC)
+			}
+		}
+	}
+	return t.writeRaw(append(data, telnetIAC, telnetSE))
+}
+
+func (t *telnetConn) command(cmd, opt byte) error {
+	return t.writeRaw([]byte{telnetIAC, cmd, opt})
+}
+
+func (t *telnetConn) writeRaw(data []byte) error {
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+	_, err := t.conn.Write(data)
+	return err
+}
+
+// Write 发送数据，0xFF 转义为 IAC IAC
+func (t *telnetConn) Write(p []byte) (int, error) {
+	data := make([]byte, 0, len(p))
+	for _, b := range p {
+		data = append(data, b)
+		if b == telnetIAC {
+			data = append(data, telnetIAC)
+		}
+	}
+	if err := t.writeRaw(data); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// dialTelnet 建立 TCP 连接，只使用显式指定的代理
+func dialTelnet(addr string, proxy string, timeout time.Duration) (net.Conn, error) {
+	if proxy != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		u, err := sshProxyURL(proxy, host)
+		if err != nil {
+			return nil, err
+		}
+		if u != nil {
+			return dialThroughProxy(u, addr, timeout)
+		}
+	}
+	return net.DialTimeout("tcp", addr, timeout)
+}
+
+// TelnetExec 登录 Telnet 设备并依次执行命令，user 为空时跳过用户名，password 为空时跳过密码
+func TelnetExec(host, port, user, password string, commands []string, opts *TelnetOptions) (*TelnetResult, error) {
+	if port == "" {
+		port = "23"
+	}
+	addr := net.JoinHostPort(host, port)
+	conn, err := dialTelnet(addr, opts.Proxy, opts.Timeout)
+	if err != nil {
+		return nil, errors.New("Failed to dial: " + err.Error())
+	}
+	defer conn.Close()
+	tc := &telnetConn{conn: conn, r: bufio.NewReader(conn), term: opts.Term, rows: opts.Rows, cols: opts.Cols}
+	cli := &deviceCLI{
+		proc: &expectProc{stdin: tc, out: newShellStream(tc)},
+		opts: opts.DeviceOptions,
+	}
+	if err := cli.telnetLogin(user, password, opts); err != nil {
+		return nil, err
+	}
+	result, err := cli.run(commands)
+	if err != nil {
+		return nil, err
+	}
+	return &TelnetResult{Host: addr, Prompt: result.Prompt, Commands: result.Commands}, nil
+}
+
+// telnetLogin 按出现的提示输入用户名和密码，直到出现命令行提示符
+func (c *deviceCLI) telnetLogin(user, password string, opts *TelnetOptions) error {
+	prompt := opts.Prompt
+	if prompt == nil {
+		prompt = regexp.MustCompile(defaultDevicePrompt)
+	}
+	deadline := time.After(opts.Timeout)
+	patterns := []*regexp.Regexp{opts.LoginPrompt, opts.PasswordPrompt, opts.LoginFailure, prompt}
+	sentUser, sentPassword := false, false
+	for {
+		_, match, index, err := c.proc.out.expectAny(patterns, deadline)
+		if err != nil {
+			return errors.New("Failed to login: " + err.Error())
+		}
+		switch index {
+		case 0:
+			if sentUser || user == "" {
+				return errors.New("Failed to login: authentication failed")
+			}
+			sentUser = true
+			err = c.send(user)
+		case 1:
+			if sentPassword {
+				return errors.New("Failed to login: authentication failed")
+			}
+			sentPassword = true
+			err = c.send(password)
+		case 2:
+			return errors.New("Failed to login: " + strings.TrimSpace(match))
+		case 3:
+			c.learnPrompt(match)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testTelnetServer 模拟只支持 Telnet 的设备，用户名 admin，密码 ups
+type testTelnetServer struct {
+	Addr string
+
+	mu         sync.Mutex
+	terminal   string
+	width      int
+	negotiated map[string]bool
+}
+
+func startTestTelnetServer(t *testing.T) *testTelnetServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	server := &testTelnetServer{Addr: listener.Addr().String(), negotiated: map[string]bool{}}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.handle(conn)
+		}
+	}()
+	return server
+}
+
+// readLine 读取一行输入，记录客户端的协商应答并回显输入
+func (s *testTelnetServer) readLine(conn net.Conn, r *bufio.Reader, echo bool) (string, error) {
+	var line []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		switch b {
+		case telnetIAC:
+			cmd, _ := r.ReadByte()
+			if cmd == telnetSB {
+				var data []byte
+				for {
+					c, _ := r.ReadByte()
+					if c == telnetIAC {
+						if next, _ := r.ReadByte(); next == telnetSE {
+							break
+						}
+					}
+					data = append(data, c)
+				}
+				s.mu.Lock()
+				if data[0] == telnetOptTermType && data[1] == telnetTermIs {
+					s.terminal = string(data[2:])
+				}
+				if data[0] == telnetOptNAWS {
+					s.width = int(data[1])<<8 | int(data[2])
+				}
+				s.mu.Unlock()
+				continue
+			}
+			opt, _ := r.ReadByte()
+			s.mu.Lock()
+			s.negotiated[string([]byte{cmd, opt})] = true
+			s.mu.Unlock()
+		case '\r':
+			if echo {
+				conn.Write([]byte("\r\n"))
+			}
+			return string(line), nil
+		case '\n', 0:
+		default:
+			line = append(line, b)
+			if echo {
+				conn.Write([]byte{b})
+			}
+		}
+	}
+}
+
+func (s *testTelnetServer) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	conn.Write([]byte{telnetIAC, telnetDO, telnetOptTermType, telnetIAC, telnetWILL, telnetOptEcho, telnetIAC, telnetDO, telnetOptNAWS, telnetIAC, telnetDO, 39})
+	conn.Write([]byte{telnetIAC, telnetSB, telnetOptTermType, telnetTermSend, telnetIAC, telnetSE})
+	conn.Write([]byte("UPS Network Management Card\r\n\r\n"))
+	for {
+		conn.Write([]byte("User Name : "))
+		user, err := s.readLine(conn, r, true)
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("Password  : "))
+		password, err := s.readLine(conn, r, false)
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("\r\n"))
+		if user == "admin" && password == "ups" {
+			break
+		}
+		conn.Write([]byte("Login incorrect\r\n"))
+		return
+	}
+	for {
+		conn.Write([]byte("apc>"))
+		line, err := s.readLine(conn, r, true)
+		if err != nil {
+			return
+		}
+		switch line {
+		case "upsabout":
+			conn.Write([]byte("Model: Smart-UPS 1500\r\nSerial: \xff\xffAS123\r\n"))
+		case "detstatus -rt":
+			conn.Write([]byte("E000: Success\r\nRuntime Remaining: 1 hr 2 min\r\n"))
+		case "exit":
+			return
+		default:
+			conn.Write([]byte("E101: Command Not Found\r\n"))
+		}
+	}
+}
+
+func TestTelnetExec(t *testing.T) {
+	server := startTestTelnetServer(t)
+	host, port, _ := net.SplitHostPort(server.Addr)
+
+	result := &TelnetResult{}
+	output := execOutput(t, "telnet_exec", host, port, "admin", "ups", "upsabout\ndetstatus -rt", map[string]interface{}{"cols": 132})
+	json.Unmarshal([]byte(output), result)
+	if result.Prompt != "apc>" || len(result.Commands) != 2 || result.Commands[1].Output != "E000: Success\nRuntime Remaining: 1 hr 2 min" {
+		t.Fatalf("unexpected result %s", output)
+	}
+	server.mu.Lock()
+	if server.terminal != "VT100" || server.width != 132 {
+		t.Errorf("unexpected terminal %q width %d", server.terminal, server.width)
+	}
+	for _, want := range [][]byte{{telnetWILL, telnetOptTermType}, {telnetDO, telnetOptEcho}, {telnetWILL, telnetOptNAWS}, {telnetWONT, 39}} {
+		if !server.negotiated[string(want)] {
+			t.Errorf("missing negotiation %v", want)
+		}
+	}
+	server.mu.Unlock()
+
+	// 与 remote 相同的参数形式
+	json.Unmarshal([]byte(execOutput(t, "telnet_exec", host, port, "admin", "ups", "detstatus", "-rt")), result)
+	if len(result.Commands) != 1 || !strings.HasPrefix(result.Commands[0].Output, "E000") {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	// 数据中的 IAC IAC 还原为 0xFF
+	opts, _ := parseTelnetOptions(map[string]interface{}{"timeout": 5})
+	raw, err := TelnetExec(host, port, "admin", "ups", []string{"upsabout"}, opts)
+	if err != nil || raw.Commands[0].Output != "Model: Smart-UPS 1500\nSerial: \xffAS123" {
+		t.Errorf("unexpected result %q %v", raw.Commands[0].Output, err)
+	}
+
+	if _, err := TelnetExec(host, port, "admin", "wrong", []string{"upsabout"}, opts); err == nil || !strings.Contains(err.Error(), "Login incorrect") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestTelnetReadNUL(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		server.Write([]byte("a\r\x00b\x00c\r\nd"))
+		server.Close()
+	}()
+	tc := &telnetConn{conn: client, r: bufio.NewReader(client)}
+	data, _ := io.ReadAll(tc)
+	// 只去掉回车之后的 NUL
+	if string(data) != "a\rb\x00c\r\nd" {
+		t.Errorf("unexpected data %q", data)
+	}
+}
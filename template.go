@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// templateVarRe 匹配 {{ name }} 或 {{ name.field }} 形式的变量
+var templateVarRe = regexp.MustCompile(`\{\{\s*([\w\-]+(?:\.[\w\-]+)*)\s*\}\}`)
+
+// renderTemplate 替换文本中的变量，变量名可以用点号访问嵌套的值，未定义的变量返回错误
+func renderTemplate(text string, vars map[string]interface{}) (string, error) {
+	var missing []string
+	result := templateVarRe.ReplaceAllStringFunc(text, func(match string) string {
+		name := templateVarRe.FindStringSubmatch(match)[1]
+		val, ok := lookupVar(vars, name)
+		if !ok {
+			missing = append(missing, name)
+			return match
+		}
+		return val
+	})
+	if len(missing) > 0 {
+		return "", errors.New("Undefined template variable: " + strings.Join(missing, ", "))
+	}
+	return result, nil
+}
+
+// lookupVar 按点号分隔的路径查找变量，返回字符串形式的值
+func lookupVar(vars map[string]interface{}, name string) (string, bool) {
+	var current interface{} = vars
+	for _, key := range strings.Split(name, ".") {
+		switch data := current.(type) {
+		case map[string]interface{}:
+			val, ok := data[key]
+			if !ok {
+				return "", false
+			}
+			current = val
+		default:
+			return "", false
+		}
+	}
+	switch data := current.(type) {
+	case nil:
+		return "", true
+	case string:
+		return data, true
+	case float64:
+		return optString(map[string]interface{}{"v": data}, "v", ""), true
+	default:
+		return fmt.Sprintf("%v", data), true
+	}
+}
+
+// mergeVars 合并多个变量表，后面的覆盖前面的
+func mergeVars(maps ...map[string]interface{}) map[string]interface{} {
+	vars := map[string]interface{}{}
+	for _, m := range maps {
+		for key, val := range m {
+			vars[key] = val
+		}
+	}
+	return vars
+}
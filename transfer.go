@@ -0,0 +1,302 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/pkg/sftp"
+)
+
+// transferBufferSize 流式传输时使用的缓冲区大小，保证大文件传输内存占用固定
+const transferBufferSize = 256 * 1024
+
+// TransferOptions 文件传输选项
+type TransferOptions struct {
+	Resume bool // 断点续传：目标文件已存在且比源文件小时，从目标文件当前大小处继续
+	Verify bool // 传输完成后比较两端文件的 SHA-256
+
+	VerifyMethod string // 远程校验方式 auto/sha256sum/sftp
+
+	Include    []string // 目录复制时只上传匹配的文件，gitignore 语法
+	Exclude    []string // 目录复制时跳过匹配的文件或目录，gitignore 语法
+	IgnoreFile string   // 读取源目录中的忽略规则文件，为空时不读取
+
+	Proxy      string         // 连接 SSH 主机使用的上游代理，为空时读取环境变量
+	Algorithms *SSHAlgorithms // 连接 SSH 主机使用的算法，为空时使用默认算法
+
+	Progress *TransferProgress // 进度记录，由调用方登记
+}
+
+// parseTransferOptions 从调用选项中读取传输选项
+func parseTransferOptions(opts map[string]interface{}) *TransferOptions {
+	return &TransferOptions{
+		Resume: optBool(opts, "resume", false),
+		Verify: optBool(opts, "verify", false),
+
+		VerifyMethod: optString(opts, "verify_method", VerifyAuto),
+
+		Include:    optStrings(opts, "include"),
+		Exclude:    optStrings(opts, "exclude"),
+		IgnoreFile: ignoreFileOption(opts),
+
+		Proxy:      optString(opts, "proxy", ""),
+		Algorithms: parseSSHAlgorithms(opts),
+	}
+}
+
+// ignoreFileOption 读取 ignore_file 选项，true 表示使用默认的 .ignore 文件
+func ignoreFileOption(opts map[string]interface{}) string {
+	switch val := opts["ignore_file"].(type) {
+	case bool:
+		if val {
+			return defaultIgnoreFile
+		}
+	case string:
+		if b, err := strconv.ParseBool(val); err == nil {
+			if b {
+				return defaultIgnoreFile
+			}
+			return ""
+		}
+		return val
+	}
+	return ""
+}
+
+// TransferFile 单个文件的传输结果
+type TransferFile struct {
+	Source   string `json:"source"`
+	Target   string `json:"target"`
+	Size     int64  `json:"size"`
+	Offset   int64  `json:"offset"`
+	Bytes    int64  `json:"bytes"`
+	Hash     string `json:"sha256,omitempty"`
+	Verified bool   `json:"verified"`
+
+	VerifyMethod string `json:"verify_method,omitempty"`
+	VerifyError  string `json:"verify_error,omitempty"`
+
+	localPath  string
+	remotePath string
+}
+
+// TransferResult 一次传输调用的结果
+type TransferResult struct {
+	ID       string          `json:"id"`
+	Protocol string          `json:"protocol"`
+	Files    []*TransferFile `json:"files"`
+	Bytes    int64           `json:"bytes"`
+
+	Verified   bool     `json:"verified"`
+	Mismatches []string `json:"mismatches,omitempty"`
+	Skipped    []string `json:"skipped,omitempty"`
+}
+
+func (r *TransferResult) add(file *TransferFile) {
+	r.Files = append(r.Files, file)
+	r.Bytes += file.Bytes
+}
+
+// folderEntry 目录复制计划中的一项
+type folderEntry struct {
+	path  string
+	rel   string
+	isDir bool
+	size  int64
+}
+
+// planFolderCopy 遍历本地目录，按 include/exclude 与忽略规则文件筛选需要上传的内容，
+// 被排除的目录以 / 结尾记入 skipped 且不再深入
+func planFolderCopy(localFolder string, opts *TransferOptions) ([]*folderEntry, []string, error) {
+	entries := make([]*folderEntry, 0)
+	skipped := make([]string, 0)
+	include := newPathMatcher(opts.Include, "")
+	exclude := newPathMatcher(opts.Exclude, "")
+	ignore := newPathMatcher(nil, "")
+
+	ignored := func(rel string, isDir bool) bool {
+		if hit, matched := exclude.match(rel, isDir); matched {
+			return hit
+		}
+		hit, _ := ignore.match(rel, isDir)
+		return hit
+	}
+
+	err := filepath.Walk(localFolder, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return errors.New("Error Occurs: " + path + " " + err.Error())
+		}
+		relPath, err := filepath.Rel(localFolder, path)
+		if err != nil {
+			return errors.New("Failed to Get File Relation Path: " + err.Error())
+		}
+		rel := filepath.ToSlash(relPath)
+
+		if info.IsDir() {
+			if rel != "." {
+				if ignored(rel, true) {
+					skipped = append(skipped, rel+"/")
+					return filepath.SkipDir
+				}
+				entries = append(entries, &folderEntry{path: path, rel: rel, isDir: true})
+			}
+			if opts.IgnoreFile != "" {
+				base := rel
+				if base == "." {
+					base = ""
+				}
+				if err := ignore.addFile(filepath.Join(path, opts.IgnoreFile), base); err != nil {
+					return errors.New("Failed to Read Ignore File: " + err.Error())
+				}
+			}
+			return nil
+		}
+
+		if ignored(rel, false) {
+			skipped = append(skipped, rel)
+			return nil
+		}
+		if len(include.rules) > 0 {
+			if hit, _ := include.match(rel, false); !hit {
+				skipped = append(skipped, rel)
+				return nil
+			}
+		}
+		entries = append(entries, &folderEntry{path: path, rel: rel, size: info.Size()})
+		return nil
+	})
+	return entries, skipped, err
+}
+
+// copyFrom 从 offset 处把 src 的剩余内容流式写入 dst
+func copyFrom(dst io.WriteSeeker, src io.ReadSeeker, offset int64, progress *TransferProgress) (int64, error) {
+	if _, err := src.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if _, err := dst.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	// 只暴露 Write 方法，避免 sftp.File.ReadFrom 走并发写入，
+	// 并发写入在中断后可能留下空洞，导致按文件大小续传出错
+	var w io.Writer = struct{ io.Writer }{dst}
+	if progress != nil {
+		w = &progressWriter{w: dst, progress: progress}
+	}
+	return io.CopyBuffer(w, src, make([]byte, transferBufferSize))
+}
+
+// resumeOffset 计算续传起点，目标文件不存在或比源文件大时从头开始
+func resumeOffset(opts *TransferOptions, targetSize int64, targetErr error, sourceSize int64) int64 {
+	if !opts.Resume || targetErr != nil || targetSize > sourceSize {
+		return 0
+	}
+	return targetSize
+}
+
+// uploadFile 将本地文件流式上传到远程，支持断点续传
+func uploadFile(client *sftp.Client, localPath, remotePath string, opts *TransferOptions) (*TransferFile, error) {
+	src, err := os.Open(localPath)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return nil, err
+	}
+	result := &TransferFile{Source: localPath, Target: remotePath, Size: info.Size(), localPath: localPath, remotePath: remotePath}
+
+	var remoteSize int64
+	remoteInfo, statErr := client.Stat(remotePath)
+	if statErr == nil {
+		remoteSize = remoteInfo.Size()
+	}
+	result.Offset = resumeOffset(opts, remoteSize, statErr, info.Size())
+	opts.Progress.beginFile(localPath, result.Offset)
+
+	flags := os.O_WRONLY | os.O_CREATE
+	if result.Offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	dst, err := client.OpenFile(remotePath, flags)
+	if err != nil {
+		return nil, err
+	}
+	defer dst.Close()
+
+	if result.Offset < info.Size() {
+		result.Bytes, err = copyFrom(dst, src, result.Offset, opts.Progress)
+		if err != nil {
+			return result, err
+		}
+	}
+	opts.Progress.endFile()
+	return result, nil
+}
+
+// downloadFile 将远程文件流式下载到本地，支持断点续传
+func downloadFile(client *sftp.Client, remotePath, localPath string, opts *TransferOptions) (*TransferFile, error) {
+	src, err := client.Open(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return nil, err
+	}
+	result := &TransferFile{Source: remotePath, Target: localPath, Size: info.Size(), localPath: localPath, remotePath: remotePath}
+
+	var localSize int64
+	localInfo, statErr := os.Stat(localPath)
+	if statErr == nil {
+		localSize = localInfo.Size()
+	}
+	result.Offset = resumeOffset(opts, localSize, statErr, info.Size())
+	opts.Progress.beginFile(remotePath, result.Offset)
+
+	flags := os.O_WRONLY | os.O_CREATE
+	if result.Offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	dst, err := os.OpenFile(localPath, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer dst.Close()
+
+	if result.Offset < info.Size() {
+		result.Bytes, err = copyFrom(dst, src, result.Offset, opts.Progress)
+		if err != nil {
+			return result, err
+		}
+	}
+	opts.Progress.endFile()
+	return result, nil
+}
+
+// fileSHA256 计算本地文件的 SHA-256
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return readerSHA256(file)
+}
+
+// readerSHA256 流式计算 SHA-256
+func readerSHA256(r io.Reader) (string, error) {
+	hash := sha256.New()
+	if _, err := io.CopyBuffer(hash, r, make([]byte, transferBufferSize)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
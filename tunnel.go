@@ -0,0 +1,432 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// 端口转发类型
+const (
+	TunnelLocal   = "local"   // -L：本地监听，经 SSH 主机连接目标地址
+	TunnelRemote  = "remote"  // -R：SSH 主机上监听，连接回本地的目标地址
+	TunnelDynamic = "dynamic" // -D：本地 SOCKS5 代理，按请求经 SSH 主机连接目标地址
+)
+
+// 隧道状态
+const (
+	TunnelConnecting   = "connecting"
+	TunnelConnected    = "connected"
+	TunnelReconnecting = "reconnecting"
+	TunnelClosed       = "closed"
+)
+
+// tunnelKeepalive 心跳间隔，用于尽早发现断开的连接
+const tunnelKeepalive = 15 * time.Second
+
+// tunnelMaxBackoff 重连的最大间隔
+const tunnelMaxBackoff = 30 * time.Second
+
+// Tunnel 在插件进程内长期运行的端口转发
+type Tunnel struct {
+	ID               string    `json:"id"`
+	Type             string    `json:"type"`
+	SSH              string    `json:"ssh"`    // SSH 主机地址
+	Bind             string    `json:"bind"`   // 监听地址，local 在本机，remote 在 SSH 主机上
+	Target           string    `json:"target"` // 转发目标，local 从 SSH 主机连接，remote 从本机连接，dynamic 为 socks5
+	Status           string    `json:"status"`
+	Error            string    `json:"error,omitempty"`
+	Reconnects       int       `json:"reconnects"`
+	Connections      int64     `json:"connections"`       // 当前活动连接数
+	TotalConnections int64     `json:"total_connections"` // 累计连接数
+	BytesIn          int64     `json:"bytes_in"`          // 从监听端收到并转发出去的字节数
+	BytesOut         int64     `json:"bytes_out"`         // 返回给监听端的字节数
+	OpenedAt         time.Time `json:"opened_at"`
+	ConnectedAt      time.Time `json:"connected_at"`
+
+	target   *SSHTarget
+	listener net.Listener
+	client   *ssh.Client
+	ready    chan struct{} // 连接可用时关闭，重连时重新创建
+	done     chan struct{}
+	mu       sync.Mutex
+}
+
+// tunnelRegistry 保存所有打开的隧道
+type tunnelRegistry struct {
+	mu    sync.Mutex
+	items map[string]*Tunnel
+}
+
+var tunnels = &tunnelRegistry{items: map[string]*Tunnel{}}
+
+// OpenTunnel 建立 SSH 连接并开始转发，第一次连接失败时直接返回错误，之后断开会自动重连
+func OpenTunnel(id, kind string, target *SSHTarget, bind, dest string) (*Tunnel, error) {
+	if kind != TunnelLocal && kind != TunnelRemote && kind != TunnelDynamic {
+		return nil, errors.New("Unsupported tunnel type: " + kind)
+	}
+	if kind == TunnelDynamic {
+		dest = "socks5"
+	}
+	if dest == "" {
+		return nil, errors.New("missing tunnel target")
+	}
+	if id == "" {
+		id = newTransferID()
+	}
+	tunnels.mu.Lock()
+	if _, ok := tunnels.items[id]; ok {
+		tunnels.mu.Unlock()
+		return nil, errors.New("Tunnel already exists: " + id)
+	}
+	t := &Tunnel{
+		ID:       id,
+		Type:     kind,
+		SSH:      target.Address(),
+		Bind:     normalizeBind(bind),
+		Target:   dest,
+		Status:   TunnelConnecting,
+		OpenedAt: time.Now(),
+		target:   target,
+		ready:    make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	tunnels.items[id] = t
+	tunnels.mu.Unlock()
+
+	if err := t.connect(); err != nil {
+		tunnels.remove(id)
+		return nil, err
+	}
+	if kind != TunnelRemote {
+		listener, err := net.Listen("tcp", t.Bind)
+		if err != nil {
+			t.Close()
+			return nil, errors.New("Failed to listen on " + t.Bind + ": " + err.Error())
+		}
+		t.mu.Lock()
+		t.listener = listener
+		t.Bind = listener.Addr().String()
+		t.mu.Unlock()
+		handle := t.forwardLocal
+		if kind == TunnelDynamic {
+			handle = t.forwardDynamic
+		}
+		go t.acceptLoop(listener, handle)
+	}
+	go t.supervise()
+	return t.snapshot(), nil
+}
+
+// normalizeBind 只给出端口时监听在回环地址
+func normalizeBind(bind string) string {
+	if bind == "" {
+		return "127.0.0.1:0"
+	}
+	if !strings.Contains(bind, ":") {
+		return "127.0.0.1:" + bind
+	}
+	return bind
+}
+
+// connect 建立 SSH 连接，remote 类型同时在 SSH 主机上开始监听
+func (t *Tunnel) connect() error {
+	target := *t.target
+	if target.Timeout == 0 {
+		target.Timeout = 10 * time.Second
+	}
+	client, err := target.Dial()
+	if err != nil {
+		return err
+	}
+	bind := t.Bind
+	if t.Type == TunnelRemote {
+		listener, err := client.Listen("tcp", t.Bind)
+		if err != nil {
+			client.Close()
+			return errors.New("Failed to listen on remote " + t.Bind + ": " + err.Error())
+		}
+		// 端口为 0 时由 SSH 主机分配，记录实际端口，重连后沿用
+		bind = listener.Addr().String()
+		go t.acceptLoop(listener, t.forwardRemote)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.Status == TunnelClosed {
+		client.Close()
+		return errors.New("tunnel closed")
+	}
+	t.Bind = bind
+	t.client = client
+	t.Status = TunnelConnected
+	t.Error = ""
+	t.ConnectedAt = time.Now()
+	close(t.ready)
+	return nil
+}
+
+// supervise 发送心跳并在连接断开后按指数退避重连，直到隧道被关闭
+func (t *Tunnel) supervise() {
+	for {
+		t.mu.Lock()
+		client := t.client
+		t.mu.Unlock()
+
+		lost := make(chan struct{})
+		go func() {
+			client.Wait()
+			close(lost)
+		}()
+		ticker := time.NewTicker(tunnelKeepalive)
+	watch:
+		for {
+			select {
+			case <-t.done:
+				ticker.Stop()
+				client.Close()
+				return
+			case <-lost:
+				break watch
+			case <-ticker.C:
+				if _, _, err := client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+					client.Close()
+				}
+			}
+		}
+		ticker.Stop()
+
+		t.mu.Lock()
+		t.Status = TunnelReconnecting
+		t.ready = make(chan struct{})
+		t.mu.Unlock()
+
+		backoff := time.Second
+		for {
+			select {
+			case <-t.done:
+				return
+			case <-time.After(backoff):
+			}
+			err := t.connect()
+			if err == nil {
+				t.mu.Lock()
+				t.Reconnects++
+				t.mu.Unlock()
+				break
+			}
+			t.mu.Lock()
+			closed := t.Status == TunnelClosed
+			t.Error = err.Error()
+			t.mu.Unlock()
+			if closed {
+				return
+			}
+			if backoff *= 2; backoff > tunnelMaxBackoff {
+				backoff = tunnelMaxBackoff
+			}
+		}
+	}
+}
+
+// currentClient 返回可用的 SSH 连接，正在重连时最多等待 timeout
+func (t *Tunnel) currentClient(timeout time.Duration) (*ssh.Client, error) {
+	t.mu.Lock()
+	ready := t.ready
+	t.mu.Unlock()
+	select {
+	case <-ready:
+	case <-t.done:
+		return nil, errors.New("tunnel closed")
+	case <-time.After(timeout):
+		return nil, errors.New("ssh connection not available")
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.client, nil
+}
+
+// acceptLoop 接受连接并交给 handle 处理，监听关闭后退出
+func (t *Tunnel) acceptLoop(listener net.Listener, handle func(conn net.Conn)) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go handle(conn)
+	}
+}
+
+// forwardLocal 通过 SSH 主机连接目标地址
+func (t *Tunnel) forwardLocal(conn net.Conn) {
+	client, err := t.currentClient(10 * time.Second)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	remote, err := client.Dial("tcp", t.Target)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	t.pipe(conn, remote)
+}
+
+// forwardDynamic 完成 SOCKS5 握手后，经 SSH 主机连接客户端请求的地址
+func (t *Tunnel) forwardDynamic(conn net.Conn) {
+	addr, err := socks5Accept(conn)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	client, err := t.currentClient(10 * time.Second)
+	if err != nil {
+		socks5Reply(conn, socks5GeneralFailure)
+		conn.Close()
+		return
+	}
+	remote, err := client.Dial("tcp", addr)
+	if err != nil {
+		socks5Reply(conn, socks5HostUnreachable)
+		conn.Close()
+		return
+	}
+	if err := socks5Reply(conn, socks5Succeeded); err != nil {
+		conn.Close()
+		remote.Close()
+		return
+	}
+	t.pipe(conn, remote)
+}
+
+// forwardRemote 将 SSH 主机上收到的连接转发到本地目标地址
+func (t *Tunnel) forwardRemote(conn net.Conn) {
+	local, err := net.DialTimeout("tcp", t.Target, 10*time.Second)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	t.pipe(conn, local)
+}
+
+// pipe 双向复制数据并统计字节数，accepted 为监听端接受的连接
+func (t *Tunnel) pipe(accepted, dialed net.Conn) {
+	atomic.AddInt64(&t.Connections, 1)
+	atomic.AddInt64(&t.TotalConnections, 1)
+	defer atomic.AddInt64(&t.Connections, -1)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(&countingWriter{w: dialed, n: &t.BytesIn}, accepted)
+		closeWrite(dialed)
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(&countingWriter{w: accepted, n: &t.BytesOut}, dialed)
+		closeWrite(accepted)
+	}()
+	wg.Wait()
+	accepted.Close()
+	dialed.Close()
+}
+
+// countingWriter 写入时累加字节计数，连接持续期间也能看到实时流量
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
+
+// closeWrite 半关闭连接，让对端收到 EOF，不支持时直接关闭
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		return
+	}
+	conn.Close()
+}
+
+// Close 关闭隧道与 SSH 连接
+func (t *Tunnel) Close() {
+	t.mu.Lock()
+	if t.Status == TunnelClosed {
+		t.mu.Unlock()
+		return
+	}
+	t.Status = TunnelClosed
+	close(t.done)
+	if t.listener != nil {
+		t.listener.Close()
+	}
+	if t.client != nil {
+		t.client.Close()
+	}
+	t.mu.Unlock()
+	tunnels.remove(t.ID)
+}
+
+// snapshot 返回隧道状态快照
+func (t *Tunnel) snapshot() *Tunnel {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return &Tunnel{
+		ID:               t.ID,
+		Type:             t.Type,
+		SSH:              t.SSH,
+		Bind:             t.Bind,
+		Target:           t.Target,
+		Status:           t.Status,
+		Error:            t.Error,
+		Reconnects:       t.Reconnects,
+		Connections:      atomic.LoadInt64(&t.Connections),
+		TotalConnections: atomic.LoadInt64(&t.TotalConnections),
+		BytesIn:          atomic.LoadInt64(&t.BytesIn),
+		BytesOut:         atomic.LoadInt64(&t.BytesOut),
+		OpenedAt:         t.OpenedAt,
+		ConnectedAt:      t.ConnectedAt,
+	}
+}
+
+func (r *tunnelRegistry) get(id string) (*Tunnel, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.items[id]
+	return t, ok
+}
+
+func (r *tunnelRegistry) remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.items, id)
+}
+
+// list 返回全部隧道的状态快照，按打开时间排序
+func (r *tunnelRegistry) list() []*Tunnel {
+	r.mu.Lock()
+	items := make([]*Tunnel, 0, len(r.items))
+	for _, t := range r.items {
+		items = append(items, t)
+	}
+	r.mu.Unlock()
+
+	list := make([]*Tunnel, 0, len(items))
+	for _, t := range items {
+		list = append(list, t.snapshot())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].OpenedAt.Before(list[j].OpenedAt) })
+	return list
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// startEchoServer 启动按行回显的 TCP 服务
+func startEchoServer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					conn.Write([]byte(line))
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// echoThrough 通过地址发送一行并读取回显
+func echoThrough(t *testing.T, addr string, line string) {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte(line + "\n"))
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || reply != line+"\n" {
+		t.Fatalf("unexpected reply %q %v", reply, err)
+	}
+}
+
+func openTestTunnel(t *testing.T, server *testSSHServer, kind, target string) *Tunnel {
+	t.Helper()
+	output := execOutput(t, "tunnel_open", map[string]interface{}{
+		"type": kind, "host": server.Host, "port": server.Port, "user": testSSHUser, "password": testSSHPassword, "target": target,
+	})
+	tunnel := &Tunnel{}
+	if err := json.Unmarshal([]byte(output), tunnel); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if item, ok := tunnels.get(tunnel.ID); ok {
+			item.Close()
+		}
+	})
+	return tunnel
+}
+
+func TestLocalTunnelReconnect(t *testing.T) {
+	server := startTestSSHServer(t)
+	echo := startEchoServer(t)
+	tunnel := openTestTunnel(t, server, TunnelLocal, echo)
+	echoThrough(t, tunnel.Bind, "hello")
+
+	// 断开 SSH 连接后自动重连
+	server.dropConnections()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		item, _ := tunnels.get(tunnel.ID)
+		if snap := item.snapshot(); snap.Reconnects > 0 && snap.Status == TunnelConnected {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("tunnel did not reconnect")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	echoThrough(t, tunnel.Bind, "again")
+
+	var list []*Tunnel
+	json.Unmarshal([]byte(execOutput(t, "tunnel_list")), &list)
+	if len(list) != 1 || list[0].TotalConnections != 2 || list[0].BytesIn != 12 || list[0].BytesOut != 12 {
+		t.Errorf("unexpected tunnel list %+v", list[0])
+	}
+
+	execOutput(t, "tunnel_close", tunnel.ID)
+	if _, ok := tunnels.get(tunnel.ID); ok {
+		t.Error("tunnel not removed")
+	}
+	if _, err := net.DialTimeout("tcp", tunnel.Bind, time.Second); err == nil {
+		t.Error("listener still open")
+	}
+}
+
+func TestRemoteTunnel(t *testing.T) {
+	server := startTestSSHServer(t)
+	echo := startEchoServer(t)
+	tunnel := openTestTunnel(t, server, TunnelRemote, echo)
+	echoThrough(t, tunnel.Bind, "remote")
+}
+
+func TestDynamicTunnel(t *testing.T) {
+	server := startTestSSHServer(t)
+	echo := startEchoServer(t)
+	tunnel := openTestTunnel(t, server, TunnelDynamic, "")
+
+	dialer, err := proxy.SOCKS5("tcp", tunnel.Bind, nil, proxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dialer.Dial("tcp", echo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("socks\n"))
+	if reply, err := bufio.NewReader(conn).ReadString('\n'); err != nil || reply != "socks\n" {
+		t.Fatalf("unexpected reply %q %v", reply, err)
+	}
+
+	// 目标不可达时返回错误应答
+	if _, err := dialer.Dial("tcp", "127.0.0.1:1"); err == nil {
+		t.Error("expected dial error")
+	}
+}
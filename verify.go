@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// 远程校验方式
+const (
+	VerifyAuto      = "auto"      // 优先使用 sha256sum，失败时通过 SFTP 回读
+	VerifySHA256Sum = "sha256sum" // 只在远程执行 sha256sum
+	VerifySFTP      = "sftp"      // 只通过 SFTP 回读文件计算
+)
+
+// shellQuote 用单引号包裹参数，供远程 shell 使用
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+// remoteHasher 计算远程文件的 SHA-256
+type remoteHasher struct {
+	conn    *ssh.Client
+	client  *sftp.Client
+	method  string
+	noShell bool // sha256sum 不可用后不再重试
+}
+
+func newRemoteHasher(conn *ssh.Client, client *sftp.Client, method string) *remoteHasher {
+	if method == "" {
+		method = VerifyAuto
+	}
+	return &remoteHasher{conn: conn, client: client, method: method}
+}
+
+// hash 返回远程文件的 SHA-256 以及实际使用的校验方式
+func (h *remoteHasher) hash(path string) (string, string, error) {
+	if h.method != VerifySFTP && !h.noShell && h.conn != nil {
+		sum, unavailable, err := h.sha256sum(path)
+		if err == nil {
+			return sum, VerifySHA256Sum, nil
+		}
+		// 只有命令本身不可用时才改用 SFTP 回读，单个文件读取失败直接报告
+		if h.method == VerifySHA256Sum || !unavailable {
+			return "", VerifySHA256Sum, err
+		}
+		h.noShell = true
+	}
+	if h.client == nil {
+		return "", VerifySFTP, errors.New("sftp subsystem not available")
+	}
+	file, err := h.client.Open(path)
+	if err != nil {
+		return "", VerifySFTP, err
+	}
+	defer file.Close()
+	sum, err := readerSHA256(file)
+	return sum, VerifySFTP, err
+}
+
+// sha256sum 在远程主机执行 sha256sum，不需要回传文件内容。
+// 无法执行命令或命令不存在时第二个返回值为 true
+func (h *remoteHasher) sha256sum(path string) (string, bool, error) {
+	session, err := h.conn.NewSession()
+	if err != nil {
+		return "", true, err
+	}
+	defer session.Close()
+	var out, stderr bytes.Buffer
+	session.Stdout = &out
+	session.Stderr = &stderr
+	if err := session.Run("sha256sum -b -- " + shellQuote(path)); err != nil {
+		exitErr, ok := err.(*ssh.ExitError)
+		unavailable := !ok || exitErr.ExitStatus() == 126 || exitErr.ExitStatus() == 127
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = errors.New(msg)
+		}
+		return "", unavailable, err
+	}
+	fields := strings.Fields(out.String())
+	if len(fields) == 0 || len(fields[0]) != 64 {
+		return "", false, errors.New("unexpected sha256sum output: " + out.String())
+	}
+	return strings.ToLower(fields[0]), false, nil
+}
+
+// verifyTransferResult 校验已传输的文件，逐个记录校验结果，单个文件计算失败时记录在 verify_error 中并继续校验其余文件
+func verifyTransferResult(hasher *remoteHasher, result *TransferResult) {
+	result.Mismatches = []string{}
+	for _, file := range result.Files {
+		localHash, err := fileSHA256(file.localPath)
+		if err != nil {
+			file.VerifyError = "Failed to hash local file: " + err.Error()
+			continue
+		}
+		file.Hash = localHash
+		remoteHash, method, err := hasher.hash(file.remotePath)
+		file.VerifyMethod = method
+		if err != nil {
+			file.VerifyError = "Failed to hash remote file: " + err.Error()
+			continue
+		}
+		file.Verified = localHash == remoteHash
+		if !file.Verified {
+			result.Mismatches = append(result.Mismatches, file.Target)
+		}
+	}
+	result.Verified = result.allVerified()
+}
+
+// verifyRelayResult 校验两台远程主机之间中转的文件
+func verifyRelayResult(source, target *remoteHasher, result *TransferResult) {
+	result.Mismatches = []string{}
+	for _, file := range result.Files {
+		sourceHash, _, err := source.hash(file.Source)
+		if err != nil {
+			file.VerifyError = "Failed to hash source file: " + err.Error()
+			continue
+		}
+		file.Hash = sourceHash
+		targetHash, method, err := target.hash(file.Target)
+		file.VerifyMethod = method
+		if err != nil {
+			file.VerifyError = "Failed to hash target file: " + err.Error()
+			continue
+		}
+		file.Verified = sourceHash == targetHash
+		if !file.Verified {
+			result.Mismatches = append(result.Mismatches, file.Target)
+		}
+	}
+	result.Verified = result.allVerified()
+}
+
+func (r *TransferResult) allVerified() bool {
+	for _, file := range r.Files {
+		if !file.Verified {
+			return false
+		}
+	}
+	return true
+}
+
+// verifyError 校验未通过时返回列出不一致与无法校验的文件的错误，供需要以失败处理的调用方使用
+func (r *TransferResult) verifyError() error {
+	if r == nil || r.Verified {
+		return nil
+	}
+	failed := make([]string, 0)
+	for _, file := range r.Files {
+		if file.VerifyError != "" {
+			failed = append(failed, file.Target+" ("+file.VerifyError+")")
+		}
+	}
+	msg := "Checksum mismatch: " + strings.Join(r.Mismatches, ", ")
+	if len(failed) > 0 {
+		msg += "; failed to verify: " + strings.Join(failed, ", ")
+	}
+	return errors.New(msg)
+}
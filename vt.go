@@ -0,0 +1,379 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ansiRe 匹配 CSI、OSC 与其它两字节的 ESC 序列
+var ansiRe = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[()][0-9A-Za-z]|\x1b[@-Z\\-_=>78]`)
+
+// stripANSI 去掉终端控制序列，并把 \r\n 换成 \n
+func stripANSI(text string) string {
+	text = ansiRe.ReplaceAllString(text, "")
+	return strings.ReplaceAll(text, "\r\n", "\n")
+}
+
+// termCell 屏幕上的一个字符及其显示属性
+type termCell struct {
+	ch  rune
+	sgr string
+}
+
+// termScreen 最小的终端模拟，按 VT100/xterm 常用控制序列维护屏幕内容，
+// 用于取得全屏程序结束时显示的画面
+type termScreen struct {
+	rows, cols int
+	main, alt  [][]termCell
+	lines      [][]termCell // 当前使用的屏幕
+	x, y       int
+	savedX     int
+	savedY     int
+	sgr        string
+	top        int // 滚动区域
+	bottom     int
+
+	inAlt     bool
+	altShot   string // 离开备用屏幕时的画面
+	altShotOK bool   // 离开备用屏幕后主屏幕没有新的输出
+	preserve  bool
+}
+
+func newTermScreen(rows, cols int, preserve bool) *termScreen {
+	s := &termScreen{rows: rows, cols: cols, bottom: rows - 1, preserve: preserve}
+	s.main = s.blank()
+	s.alt = s.blank()
+	s.lines = s.main
+	return s
+}
+
+func (s *termScreen) blank() [][]termCell {
+	lines := make([][]termCell, s.rows)
+	for i := range lines {
+		lines[i] = make([]termCell, s.cols)
+	}
+	return lines
+}
+
+// Write 解析输出并更新屏幕
+func (s *termScreen) Write(data []byte) {
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if r == 0x1b {
+			data = s.escape(data)
+			continue
+		}
+		data = data[size:]
+		switch r {
+		case '\r':
+			s.x = 0
+		case '\n', '\v', '\f':
+			s.lineFeed()
+		case '\b':
+			if s.x > 0 {
+				s.x--
+			}
+		case '\t':
+			s.x = (s.x/8 + 1) * 8
+			if s.x >= s.cols {
+				s.x = s.cols - 1
+			}
+		default:
+			if r < 0x20 || r == 0x7f {
+				continue
+			}
+			if s.x >= s.cols {
+				s.x = 0
+				s.lineFeed()
+			}
+			s.lines[s.y][s.x] = termCell{ch: r, sgr: s.sgr}
+			s.x++
+			if !s.inAlt {
+				s.altShotOK = false
+			}
+		}
+	}
+}
+
+// escape 处理以 ESC 开始的序列，返回剩余数据
+func (s *termScreen) escape(data []byte) []byte {
+	if len(data) < 2 {
+		return nil
+	}
+	switch data[1] {
+	case '[':
+		i := 2
+		for i < len(data) && (data[i] < 0x40 || data[i] > 0x7e) {
+			i++
+		}
+		if i >= len(data) {
+			return nil
+		}
+		s.csi(string(data[2:i]), data[i])
+		return data[i+1:]
+	case ']':
+		for i := 2; i < len(data); i++ {
+			if data[i] == 0x07 {
+				return data[i+1:]
+			}
+			if data[i] == 0x1b && i+1 < len(data) && data[i+1] == '\\' {
+				return data[i+2:]
+			}
+		}
+		return nil
+	case '(', ')':
+		if len(data) < 3 {
+			return nil
+		}
+		return data[3:]
+	case '7':
+		s.savedX, s.savedY = s.x, s.y
+	case '8':
+		s.x, s.y = s.savedX, s.savedY
+	case 'D':
+		s.lineFeed()
+	case 'E':
+		s.x = 0
+		s.lineFeed()
+	case 'M':
+		if s.y == s.top {
+			s.scrollDown(1)
+		} else if s.y > 0 {
+			s.y--
+		}
+	case 'c':
+		*s = *newTermScreen(s.rows, s.cols, s.preserve)
+	}
+	return data[2:]
+}
+
+// csi 执行 CSI 序列
+func (s *termScreen) csi(params string, final byte) {
+	private := strings.HasPrefix(params, "?")
+	params = strings.TrimLeft(params, "?>=<")
+	args := make([]int, 0)
+	for _, item := range strings.Split(params, ";") {
+		n, _ := strconv.Atoi(item)
+		args = append(args, n)
+	}
+	arg := func(i, def int) int {
+		if i < len(args) && args[i] > 0 {
+			return args[i]
+		}
+		return def
+	}
+
+	switch final {
+	case 'A':
+		s.y -= arg(0, 1)
+	case 'B', 'e':
+		s.y += arg(0, 1)
+	case 'C', 'a':
+		s.x += arg(0, 1)
+	case 'D':
+		s.x -= arg(0, 1)
+	case 'E':
+		s.x, s.y = 0, s.y+arg(0, 1)
+	case 'F':
+		s.x, s.y = 0, s.y-arg(0, 1)
+	case 'G', '`':
+		s.x = arg(0, 1) - 1
+	case 'd':
+		s.y = arg(0, 1) - 1
+	case 'H', 'f':
+		s.y, s.x = arg(0, 1)-1, arg(1, 1)-1
+	case 'J':
+		switch arg(0, 0) {
+		case 0:
+			s.clearLine(s.y, s.x, s.cols)
+			s.clearLines(s.y+1, s.rows)
+		case 1:
+			s.clearLines(0, s.y)
+			s.clearLine(s.y, 0, s.x+1)
+		default:
+			s.clearLines(0, s.rows)
+		}
+	case 'K':
+		switch arg(0, 0) {
+		case 0:
+			s.clearLine(s.y, s.x, s.cols)
+		case 1:
+			s.clearLine(s.y, 0, s.x+1)
+		default:
+			s.clearLine(s.y, 0, s.cols)
+		}
+	case 'X':
+		s.clearLine(s.y, s.x, s.x+arg(0, 1))
+	case 'P':
+		line := s.lines[s.y]
+		n := min(arg(0, 1), s.cols-s.x)
+		copy(line[s.x:], line[s.x+n:])
+		s.clearLine(s.y, s.cols-n, s.cols)
+	case '@':
+		line := s.lines[s.y]
+		n := min(arg(0, 1), s.cols-s.x)
+		copy(line[s.x+n:], line[s.x:])
+		s.clearLine(s.y, s.x, s.x+n)
+	case 'L':
+		if s.y >= s.top && s.y <= s.bottom {
+			s.shift(s.y, s.bottom, -arg(0, 1))
+		}
+	case 'M':
+		if s.y >= s.top && s.y <= s.bottom {
+			s.shift(s.y, s.bottom, arg(0, 1))
+		}
+	case 'S':
+		s.shift(s.top, s.bottom, arg(0, 1))
+	case 'T':
+		s.scrollDown(arg(0, 1))
+	case 'r':
+		s.top, s.bottom = arg(0, 1)-1, arg(1, s.rows)-1
+		if s.top < 0 || s.bottom >= s.rows || s.top >= s.bottom {
+			s.top, s.bottom = 0, s.rows-1
+		}
+		s.x, s.y = 0, 0
+	case 's':
+		s.savedX, s.savedY = s.x, s.y
+	case 'u':
+		s.x, s.y = s.savedX, s.savedY
+	case 'm':
+		s.setSGR(params)
+	case 'h', 'l':
+		if private {
+			for _, mode := range args {
+				if mode == 1049 || mode == 1047 || mode == 47 {
+					s.useAlt(final == 'h')
+				}
+			}
+		}
+	}
+	s.clamp()
+}
+
+// setSGR 记录当前的显示属性，0 或空参数表示重置
+func (s *termScreen) setSGR(params string) {
+	if params == "" || params == "0" {
+		s.sgr = ""
+		return
+	}
+	if strings.HasPrefix(params, "0;") {
+		s.sgr = ""
+		params = params[2:]
+	}
+	s.sgr += "\x1b[" + params + "m"
+}
+
+// useAlt 切换备用屏幕，离开时保存备用屏幕的画面
+func (s *termScreen) useAlt(on bool) {
+	if on == s.inAlt {
+		return
+	}
+	s.inAlt = on
+	if on {
+		s.savedX, s.savedY = s.x, s.y
+		s.alt = s.blank()
+		s.lines = s.alt
+		return
+	}
+	s.altShot = s.render()
+	s.altShotOK = true
+	s.lines = s.main
+	s.x, s.y = s.savedX, s.savedY
+}
+
+func (s *termScreen) lineFeed() {
+	if s.y == s.bottom {
+		s.shift(s.top, s.bottom, 1)
+		return
+	}
+	if s.y < s.rows-1 {
+		s.y++
+	}
+}
+
+// shift 把 from 到 to 之间的行向上移动 n 行，n 为负数时向下移动，空出的行清空
+func (s *termScreen) shift(from, to, n int) {
+	if n > 0 {
+		for i := from; i <= to; i++ {
+			if i+n <= to {
+				s.lines[i] = s.lines[i+n]
+			} else {
+				s.lines[i] = make([]termCell, s.cols)
+			}
+		}
+	} else if n < 0 {
+		for i := to; i >= from; i-- {
+			if i+n >= from {
+				s.lines[i] = s.lines[i+n]
+			} else {
+				s.lines[i] = make([]termCell, s.cols)
+			}
+		}
+	}
+}
+
+func (s *termScreen) scrollDown(n int) {
+	s.shift(s.top, s.bottom, -n)
+}
+
+func (s *termScreen) clearLine(y, from, to int) {
+	if y < 0 || y >= s.rows {
+		return
+	}
+	for x := max(from, 0); x < to && x < s.cols; x++ {
+		s.lines[y][x] = termCell{}
+	}
+}
+
+func (s *termScreen) clearLines(from, to int) {
+	for y := from; y < to; y++ {
+		s.clearLine(y, 0, s.cols)
+	}
+}
+
+func (s *termScreen) clamp() {
+	s.x = min(max(s.x, 0), s.cols-1)
+	s.y = min(max(s.y, 0), s.rows-1)
+}
+
+// Screen 返回最后显示的画面，程序退出前离开了备用屏幕且之后没有新输出时返回备用屏幕的画面
+func (s *termScreen) Screen() string {
+	if !s.inAlt && s.altShotOK {
+		return s.altShot
+	}
+	return s.render()
+}
+
+// render 输出当前屏幕，去掉行尾与末尾的空白，preserve 时保留颜色等显示属性
+func (s *termScreen) render() string {
+	rows := make([]string, 0, s.rows)
+	for _, line := range s.lines {
+		end := len(line)
+		for end > 0 && (line[end-1].ch == 0 || line[end-1].ch == ' ') && line[end-1].sgr == "" {
+			end--
+		}
+		var b strings.Builder
+		sgr := ""
+		for _, cell := range line[:end] {
+			if s.preserve && cell.sgr != sgr {
+				b.WriteString("\x1b[0m" + cell.sgr)
+				sgr = cell.sgr
+			}
+			if cell.ch == 0 {
+				b.WriteByte(' ')
+			} else {
+				b.WriteRune(cell.ch)
+			}
+		}
+		if sgr != "" {
+			b.WriteString("\x1b[0m")
+		}
+		rows = append(rows, b.String())
+	}
+	for len(rows) > 0 && rows[len(rows)-1] == "" {
+		rows = rows[:len(rows)-1]
+	}
+	return strings.Join(rows, "\n")
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// WriteOptions 远程写文件选项
+type WriteOptions struct {
+	Atomic   bool        // 先写入同目录下的临时文件，再重命名覆盖目标文件
+	Append   bool        // 追加到文件末尾而不是覆盖
+	Backup   string      // 写入前备份原文件时使用的后缀，为空时不备份
+	Mode     os.FileMode // 文件权限，0 表示不修改（原子写入时沿用原文件权限）
+	Owner    string      // 文件属主，用户名或 uid
+	Group    string      // 文件属组，组名或 gid
+	Encoding string      // 内容编码，base64 表示内容为 base64 编码的二进制数据
+	Proxy    string      // 连接 SSH 主机使用的上游代理，为空时读取环境变量
+
+	Algorithms *SSHAlgorithms // 连接 SSH 主机使用的算法，为空时使用默认算法
+}
+
+// WriteResult 远程写文件结果
+type WriteResult struct {
+	Path   string `json:"path"`
+	Bytes  int64  `json:"bytes"`
+	Backup string `json:"backup,omitempty"`
+	Mode   string `json:"mode,omitempty"`
+}
+
+// parseWriteOptions 从调用选项中读取写文件选项
+func parseWriteOptions(opts map[string]interface{}) (*WriteOptions, error) {
+	wopts := &WriteOptions{
+		Atomic:   optBool(opts, "atomic", false),
+		Append:   optBool(opts, "append", false),
+		Owner:    optString(opts, "owner", ""),
+		Group:    optString(opts, "group", ""),
+		Encoding: strings.ToLower(optString(opts, "encoding", "")),
+		Proxy:    optString(opts, "proxy", ""),
+
+		Algorithms: parseSSHAlgorithms(opts),
+	}
+	switch val := opts["backup"].(type) {
+	case bool:
+		if val {
+			wopts.Backup = ".bak"
+		}
+	case string:
+		if b, err := strconv.ParseBool(val); err == nil {
+			if b {
+				wopts.Backup = ".bak"
+			}
+		} else {
+			wopts.Backup = val
+		}
+	}
+	if mode := optString(opts, "mode", ""); mode != "" {
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			return nil, errors.New("Invalid file mode: " + mode)
+		}
+		wopts.Mode = os.FileMode(m)
+	}
+	if wopts.Encoding != "" && wopts.Encoding != "base64" && wopts.Encoding != "text" {
+		return nil, errors.New("Unsupported content encoding: " + wopts.Encoding)
+	}
+	return wopts, nil
+}
+
+// decodeContent 按选项解码写入内容
+func (opts *WriteOptions) decodeContent(data string) ([]byte, error) {
+	if opts.Encoding == "base64" {
+		content, err := base64.StdEncoding.DecodeString(strings.TrimSpace(data))
+		if err != nil {
+			return nil, errors.New("Failed to decode base64 content: " + err.Error())
+		}
+		return content, nil
+	}
+	return []byte(data), nil
+}
+
+// writeRemoteFile 按选项写入远程文件
+func writeRemoteFile(conn *ssh.Client, client *sftp.Client, data, dstPath string, opts *WriteOptions) (*WriteResult, error) {
+	content, err := opts.decodeContent(data)
+	if err != nil {
+		return nil, err
+	}
+	result := &WriteResult{Path: dstPath, Bytes: int64(len(content))}
+
+	existing, statErr := client.Stat(dstPath)
+	if statErr != nil && !os.IsNotExist(statErr) {
+		return nil, statErr
+	}
+	exists := statErr == nil
+
+	if opts.Backup != "" && exists {
+		result.Backup = dstPath + opts.Backup
+		if err := copyRemoteFile(client, dstPath, result.Backup); err != nil {
+			return nil, errors.New("Failed to backup remote file: " + err.Error())
+		}
+	}
+
+	mode := opts.Mode
+	target := dstPath
+	if opts.Atomic {
+		target = tempWritePath(dstPath)
+		if mode == 0 && exists {
+			mode = existing.Mode().Perm()
+		}
+		if opts.Append && exists {
+			if err := copyRemoteFile(client, dstPath, target); err != nil {
+				client.Remove(target)
+				return nil, err
+			}
+		}
+	}
+
+	if err := writeRemoteContent(client, target, content, opts.Append, opts.Mode); err != nil {
+		if opts.Atomic {
+			client.Remove(target)
+		}
+		return nil, err
+	}
+
+	if err := applyRemoteAttrs(conn, client, target, mode, opts.Owner, opts.Group, existing); err != nil {
+		if opts.Atomic {
+			client.Remove(target)
+		}
+		return nil, err
+	}
+
+	if opts.Atomic {
+		if err := renameRemoteFile(client, target, dstPath); err != nil {
+			client.Remove(target)
+			return nil, errors.New("Failed to rename temp file: " + err.Error())
+		}
+	}
+	if mode != 0 {
+		result.Mode = "0" + strconv.FormatUint(uint64(mode.Perm()), 8)
+	}
+	return result, nil
+}
+
+// writeRemoteContent 写入内容，append 为 true 时追加到文件末尾。
+// 指定了权限时在写入内容之前设置，新建的文件不会以服务端的默认权限暴露内容
+func writeRemoteContent(client *sftp.Client, target string, content []byte, appendMode bool, mode os.FileMode) error {
+	flags := os.O_WRONLY | os.O_CREATE
+	if appendMode {
+		flags |= os.O_APPEND
+	} else {
+		flags |= os.O_TRUNC
+	}
+	file, err := client.OpenFile(target, flags)
+	if err != nil {
+		return err
+	}
+	if mode != 0 {
+		if err := file.Chmod(mode); err != nil {
+			file.Close()
+			return errors.New("Failed to chmod remote file: " + err.Error())
+		}
+	}
+	if appendMode {
+		// 部分 SFTP 服务端忽略 O_APPEND，显式定位到文件末尾
+		if _, err := file.Seek(0, io.SeekEnd); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// tempWritePath 返回原子写入使用的临时文件路径，临时文件放在同一目录，保证 rename 是同一文件系统内的原子操作
+func tempWritePath(dstPath string) string {
+	return path.Join(path.Dir(dstPath), "."+path.Base(dstPath)+".tmp-"+strconv.FormatInt(time.Now().UnixNano(), 36))
+}
+
+// copyRemoteFile 在远程主机上复制文件，保留原文件权限
+func copyRemoteFile(client *sftp.Client, srcPath, dstPath string) error {
+	src, err := client.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	dst, err := client.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyBuffer(struct{ io.Writer }{dst}, src, make([]byte, transferBufferSize)); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return client.Chmod(dstPath, info.Mode().Perm())
+}
+
+// renameRemoteFile 覆盖式重命名，服务端支持 posix-rename 扩展时直接使用，它的错误原样返回
+func renameRemoteFile(client *sftp.Client, oldPath, newPath string) error {
+	if _, ok := client.HasExtension("posix-rename@openssh.com"); ok {
+		return client.PosixRename(oldPath, newPath)
+	}
+	// SFTPv3 的 rename 不能覆盖已存在的文件，先把原文件移到一旁，重命名失败时恢复
+	aside := ""
+	if _, err := client.Lstat(newPath); err == nil {
+		aside = tempWritePath(newPath) + ".old"
+		if err := client.Rename(newPath, aside); err != nil {
+			return err
+		}
+	}
+	if err := client.Rename(oldPath, newPath); err != nil {
+		if aside != "" {
+			client.Rename(aside, newPath)
+		}
+		return err
+	}
+	if aside != "" {
+		client.Remove(aside)
+	}
+	return nil
+}
+
+// applyRemoteAttrs 设置文件权限与属主属组
+func applyRemoteAttrs(conn *ssh.Client, client *sftp.Client, target string, mode os.FileMode, owner, group string, existing os.FileInfo) error {
+	if mode != 0 {
+		if err := client.Chmod(target, mode); err != nil {
+			return errors.New("Failed to chmod remote file: " + err.Error())
+		}
+	}
+	if owner == "" && group == "" {
+		// 原子写入生成的是新文件，沿用原文件的属主属组
+		if existing == nil {
+			return nil
+		}
+		stat, ok := existing.Sys().(*sftp.FileStat)
+		if !ok {
+			return nil
+		}
+		info, err := client.Stat(target)
+		if err != nil {
+			return nil
+		}
+		if current, ok := info.Sys().(*sftp.FileStat); ok && (current.UID != stat.UID || current.GID != stat.GID) {
+			// 非 root 用户无法修改属主，忽略失败
+			client.Chown(target, int(stat.UID), int(stat.GID))
+		}
+		return nil
+	}
+
+	info, err := client.Stat(target)
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(*sftp.FileStat)
+	if !ok {
+		return errors.New("Failed to read remote file owner")
+	}
+	uid, gid := int(stat.UID), int(stat.GID)
+	if owner != "" {
+		if uid, err = resolveRemoteID(conn, "user", owner); err != nil {
+			return errors.New("Failed to resolve owner " + owner + ": " + err.Error())
+		}
+	}
+	if group != "" {
+		if gid, err = resolveRemoteID(conn, "group", group); err != nil {
+			return errors.New("Failed to resolve group " + group + ": " + err.Error())
+		}
+	}
+	if err := client.Chown(target, uid, gid); err != nil {
+		return errors.New("Failed to chown remote file: " + err.Error())
+	}
+	return nil
+}
+
+// resolveRemoteID 将用户名或组名解析为远程主机上的数字ID，数字直接返回
+func resolveRemoteID(conn *ssh.Client, kind string, name string) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	cmd := "id -u " + shellQuote(name)
+	if kind == "group" {
+		cmd = "getent group " + shellQuote(name) + " | cut -d: -f3"
+	}
+	out, _, err := runSession(conn, cmd)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.Atoi(strings.TrimSpace(out))
+	if err != nil {
+		return 0, errors.New("unknown name")
+	}
+	return id, nil
+}